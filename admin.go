@@ -0,0 +1,218 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"time"
+)
+
+// CollectionOptions specifies options for the Database CreateCollection
+// method.
+type CollectionOptions struct {
+	// Create a capped collection with maximum size Size bytes. If Max is
+	// not zero, then Max is the maximum number of documents in the capped
+	// collection.
+	Capped bool
+	Size   int64
+	Max    int64
+
+	// Optional document validation rules. ValidationLevel is "off",
+	// "strict" or "moderate". ValidationAction is "error" or "warn".
+	Validator        interface{}
+	ValidationLevel  string
+	ValidationAction string
+
+	// Default collation for the collection.
+	Collation *Collation
+
+	// Create a time series collection.
+	TimeSeries *TimeSeriesOptions
+
+	// Remove documents from a time series collection after this duration.
+	ExpireAfter time.Duration
+}
+
+// TimeSeriesOptions specifies a time series collection.
+//
+// More information: https://www.mongodb.com/docs/manual/core/timeseries-collections/
+type TimeSeriesOptions struct {
+	// Name of the field that contains the date in each document.
+	TimeField string `bson:"timeField"`
+
+	// Optional name of the field that contains metadata in each document.
+	MetaField string `bson:"metaField,omitempty"`
+
+	// Optional granularity of the time values: "seconds", "minutes" or
+	// "hours".
+	Granularity string `bson:"granularity,omitempty"`
+}
+
+// CreateCollection explicitly creates a collection with name. The options
+// argument can be nil.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/create/
+func (db Database) CreateCollection(name string, options *CollectionOptions) error {
+	cmd := D{{"create", name}}
+	if options != nil {
+		if options.Capped {
+			cmd.Append("capped", true)
+			cmd.Append("size", options.Size)
+			if options.Max != 0 {
+				cmd.Append("max", options.Max)
+			}
+		}
+		if options.Validator != nil {
+			cmd.Append("validator", options.Validator)
+		}
+		if options.ValidationLevel != "" {
+			cmd.Append("validationLevel", options.ValidationLevel)
+		}
+		if options.ValidationAction != "" {
+			cmd.Append("validationAction", options.ValidationAction)
+		}
+		if options.Collation != nil {
+			cmd.Append("collation", options.Collation)
+		}
+		if options.TimeSeries != nil {
+			cmd.Append("timeseries", options.TimeSeries)
+		}
+		if options.ExpireAfter > 0 {
+			cmd.Append("expireAfterSeconds", int64(options.ExpireAfter/time.Second))
+		}
+	}
+	return db.Run(withWriteConcern(cmd, db.WriteConcern), nil)
+}
+
+// Drop removes the collection from the database.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/drop/
+func (c Collection) Drop() error {
+	return c.Db().Run(withWriteConcern(D{{"drop", c.Name()}}, c.WriteConcern), nil)
+}
+
+// Rename renames the collection to name in the same database. If dropTarget
+// is true, then an existing collection with name is dropped before the
+// rename.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/renameCollection/
+func (c Collection) Rename(name string, dropTarget bool) error {
+	dbname, _ := SplitNamespace(c.Namespace)
+	cmd := D{
+		{"renameCollection", c.Namespace},
+		{"to", dbname + "." + name},
+		{"dropTarget", dropTarget},
+	}
+	return Database{Conn: c.conn(), Name: "admin"}.Run(withWriteConcern(cmd, c.WriteConcern), nil)
+}
+
+// Drop removes the database.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/dropDatabase/
+func (db Database) Drop() error {
+	return db.Run(withWriteConcern(D{{"dropDatabase", 1}}, db.WriteConcern), nil)
+}
+
+// CollectionNames returns the names of the collections in the database.
+func (db Database) CollectionNames() ([]string, error) {
+	cursor, err := db.RunCursor(D{{"listCollections", 1}, {"nameOnly", true}}, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var names []string
+	for cursor.HasNext() {
+		var spec struct {
+			Name string `bson:"name"`
+		}
+		if err := cursor.Next(&spec); err != nil {
+			return nil, err
+		}
+		names = append(names, spec.Name)
+	}
+	return names, nil
+}
+
+// DatabaseNames returns the names of the databases on the server.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/listDatabases/
+func DatabaseNames(conn Conn) ([]string, error) {
+	var r struct {
+		Databases []struct {
+			Name string `bson:"name"`
+		} `bson:"databases"`
+	}
+	err := Database{Conn: conn, Name: "admin"}.Run(D{{"listDatabases", 1}, {"nameOnly", true}}, &r)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(r.Databases))
+	for i, d := range r.Databases {
+		names[i] = d.Name
+	}
+	return names, nil
+}
+
+// CollectionStats is the result of the Collection Stats method. Sizes are in
+// bytes.
+type CollectionStats struct {
+	Namespace      string           `bson:"ns"`
+	Count          int64            `bson:"count"`
+	Size           int64            `bson:"size"`
+	AvgObjSize     float64          `bson:"avgObjSize"`
+	StorageSize    int64            `bson:"storageSize"`
+	NIndexes       int              `bson:"nindexes"`
+	TotalIndexSize int64            `bson:"totalIndexSize"`
+	IndexSizes     map[string]int64 `bson:"indexSizes"`
+	Capped         bool             `bson:"capped"`
+	Max            int64            `bson:"max"`
+	MaxSize        int64            `bson:"maxSize"`
+}
+
+// Stats returns storage statistics for the collection.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/collStats/
+func (c Collection) Stats() (*CollectionStats, error) {
+	var stats CollectionStats
+	if err := c.Db().Run(D{{"collStats", c.Name()}}, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// DatabaseStats is the result of the Database Stats method. Sizes are in
+// bytes.
+type DatabaseStats struct {
+	DB          string  `bson:"db"`
+	Collections int     `bson:"collections"`
+	Views       int     `bson:"views"`
+	Objects     int64   `bson:"objects"`
+	AvgObjSize  float64 `bson:"avgObjSize"`
+	DataSize    int64   `bson:"dataSize"`
+	StorageSize int64   `bson:"storageSize"`
+	Indexes     int     `bson:"indexes"`
+	IndexSize   int64   `bson:"indexSize"`
+	TotalSize   int64   `bson:"totalSize"`
+}
+
+// Stats returns storage statistics for the database.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/dbStats/
+func (db Database) Stats() (*DatabaseStats, error) {
+	var stats DatabaseStats
+	if err := db.Run(D{{"dbStats", 1}}, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
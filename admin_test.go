@@ -0,0 +1,125 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var adminCommandTests = []struct {
+	name     string
+	fn       func(db Database) error
+	expected string
+}{
+	{
+		"CreateCollection",
+		func(db Database) error {
+			return db.CreateCollection("c", &CollectionOptions{Capped: true, Size: 4096, Collation: &Collation{Locale: "fr", Strength: 2}})
+		},
+		"map[$db:test capped:true collation:map[locale:fr strength:2] create:c size:4096]",
+	},
+	{
+		"CreateTimeSeries",
+		func(db Database) error {
+			return db.CreateCollection("c", &CollectionOptions{
+				TimeSeries:  &TimeSeriesOptions{TimeField: "t", MetaField: "m"},
+				ExpireAfter: time.Hour,
+			})
+		},
+		"map[$db:test create:c expireAfterSeconds:3600 timeseries:map[metaField:m timeField:t]]",
+	},
+	{
+		"Drop",
+		func(db Database) error { return db.C("c").Drop() },
+		"map[$db:test drop:c]",
+	},
+	{
+		"Rename",
+		func(db Database) error { return db.C("c").Rename("d", true) },
+		"map[$db:admin dropTarget:true renameCollection:test.c to:test.d]",
+	},
+	{
+		"DropDatabase",
+		func(db Database) error { return db.Drop() },
+		"map[$db:test dropDatabase:1]",
+	},
+}
+
+func TestAdminCommands(t *testing.T) {
+	var cmd M
+	c := newMsgConnection(func(m M, sequences map[string][][]byte) interface{} {
+		cmd = m
+		return M{"ok": 1}
+	})
+	defer c.Close()
+	db := Database{Conn: c, Name: "test"}
+
+	for _, tt := range adminCommandTests {
+		if err := tt.fn(db); err != nil {
+			t.Errorf("%s returned %v", tt.name, err)
+			continue
+		}
+		if s := fmt.Sprint(cmd); s != tt.expected {
+			t.Errorf("%s sent %s, want %s", tt.name, s, tt.expected)
+		}
+	}
+}
+
+func TestNamesAndStats(t *testing.T) {
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		switch {
+		case cmd["listDatabases"] != nil:
+			return M{"ok": 1, "databases": []M{{"name": "admin"}, {"name": "test"}}}
+		case cmd["listCollections"] != nil:
+			return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "test.$cmd.listCollections", "firstBatch": []M{{"name": "c"}}}}
+		case cmd["collStats"] != nil:
+			return M{"ok": 1, "ns": "test.c", "count": 3, "size": int64(300), "avgObjSize": 100, "indexSizes": M{"_id_": 4096}}
+		case cmd["dbStats"] != nil:
+			return M{"ok": 1, "db": "test", "collections": 1, "objects": 3, "dataSize": 300.0}
+		}
+		return M{"ok": 0, "errmsg": "unexpected command"}
+	})
+	defer c.Close()
+	db := Database{Conn: c, Name: "test"}
+
+	names, err := DatabaseNames(c)
+	if err != nil || !reflect.DeepEqual(names, []string{"admin", "test"}) {
+		t.Errorf("DatabaseNames() = %v, %v", names, err)
+	}
+	names, err = db.CollectionNames()
+	if err != nil || !reflect.DeepEqual(names, []string{"c"}) {
+		t.Errorf("CollectionNames() = %v, %v", names, err)
+	}
+
+	cstats, err := db.C("c").Stats()
+	if err != nil {
+		t.Fatal("collection stats", err)
+	}
+	expected := &CollectionStats{Namespace: "test.c", Count: 3, Size: 300, AvgObjSize: 100, IndexSizes: map[string]int64{"_id_": 4096}}
+	if !reflect.DeepEqual(cstats, expected) {
+		t.Errorf("collection stats = %+v, want %+v", cstats, expected)
+	}
+
+	dstats, err := db.Stats()
+	if err != nil {
+		t.Fatal("database stats", err)
+	}
+	if dstats.DB != "test" || dstats.Collections != 1 || dstats.Objects != 3 || dstats.DataSize != 300 {
+		t.Errorf("database stats = %+v", dstats)
+	}
+}
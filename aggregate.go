@@ -0,0 +1,108 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"reflect"
+	"time"
+)
+
+// AggregateOptions specifies options for the Collection Aggregate method.
+type AggregateOptions struct {
+	// Allow pipeline stages to write temporary files to disk.
+	AllowDiskUse bool
+
+	// Number of documents in each batch returned from the server. If zero,
+	// then the server default is used.
+	BatchSize int
+
+	// Time limit for the command on the server. Zero means no limit.
+	MaxTime time.Duration
+
+	// Optional collation for string comparisons.
+	Collation *Collation
+
+	// Optional index hint specified by index name or key pattern.
+	Hint interface{}
+
+	// Write concern for pipelines that end with a $out or $merge stage. If
+	// nil, then the collection's write concern is used.
+	WriteConcern *WriteConcern
+}
+
+// pipelineWrites returns true if the last stage of pipeline is $out or
+// $merge.
+func pipelineWrites(pipeline interface{}) bool {
+	v := reflect.ValueOf(pipeline)
+	if (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) || v.Len() == 0 {
+		return false
+	}
+	stage := v.Index(v.Len() - 1)
+	for stage.Kind() == reflect.Interface || stage.Kind() == reflect.Ptr {
+		stage = stage.Elem()
+	}
+	if d, ok := stage.Interface().(D); ok {
+		return len(d) > 0 && (d[0].Key == "$out" || d[0].Key == "$merge")
+	}
+	if stage.Kind() == reflect.Map && stage.Type().Key().Kind() == reflect.String {
+		for _, key := range stage.MapKeys() {
+			if key.String() == "$out" || key.String() == "$merge" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Aggregate runs the aggregation pipeline on the collection and returns a
+// cursor for the results. The pipeline is a slice of stage documents. The
+// cursor fetches batches of results from the server as the application reads
+// the results. Aggregate requires MongoDB 3.2 or later. The options argument
+// can be nil.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/aggregate/
+func (c Collection) Aggregate(pipeline interface{}, options *AggregateOptions) (Cursor, error) {
+	if options == nil {
+		options = &AggregateOptions{}
+	}
+	if pipeline == nil {
+		pipeline = []interface{}{}
+	}
+	cursor := D{}
+	if options.BatchSize > 0 {
+		cursor.Append("batchSize", options.BatchSize)
+	}
+	cmd := D{{"aggregate", c.Name()}, {"pipeline", pipeline}, {"cursor", cursor}}
+	if options.AllowDiskUse {
+		cmd.Append("allowDiskUse", true)
+	}
+	if options.MaxTime > 0 {
+		cmd.Append("maxTimeMS", int64(options.MaxTime/time.Millisecond))
+	}
+	if options.Collation != nil {
+		cmd.Append("collation", options.Collation)
+	}
+	if options.Hint != nil {
+		cmd.Append("hint", options.Hint)
+	}
+	if pipelineWrites(pipeline) {
+		wc := options.WriteConcern
+		if wc == nil {
+			wc = c.WriteConcern
+		}
+		cmd = withWriteConcern(cmd, wc)
+	}
+	return c.Db().RunCursor(cmd, options.BatchSize)
+}
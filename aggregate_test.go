@@ -0,0 +1,117 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"testing"
+	"time"
+)
+
+var pipelineWritesTests = []struct {
+	pipeline interface{}
+	expected bool
+}{
+	{nil, false},
+	{[]D{}, false},
+	{[]D{{{"$match", M{}}}}, false},
+	{[]D{{{"$match", M{}}}, {{"$out", "c"}}}, true},
+	{[]interface{}{M{"$merge": M{"into": "c"}}}, true},
+	{[]M{{"$out": "c"}, {"$sort": M{"x": 1}}}, false},
+}
+
+func TestPipelineWrites(t *testing.T) {
+	for _, tt := range pipelineWritesTests {
+		if actual := pipelineWrites(tt.pipeline); actual != tt.expected {
+			t.Errorf("pipelineWrites(%v) = %v, want %v", tt.pipeline, actual, tt.expected)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	var commands []M
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		commands = append(commands, cmd)
+		switch {
+		case cmd["aggregate"] != nil:
+			return M{"ok": 1, "cursor": M{"id": int64(42), "ns": "test.c", "firstBatch": []M{{"x": 1}}}}
+		case cmd["getMore"] != nil:
+			return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "test.c", "nextBatch": []M{{"x": 2}, {"x": 3}}}}
+		}
+		return M{"ok": 1}
+	})
+	defer c.Close()
+	coll := Collection{Conn: c, Namespace: "test.c"}
+
+	cursor, err := coll.Aggregate([]D{{{"$match", M{}}}}, &AggregateOptions{
+		BatchSize:    1,
+		AllowDiskUse: true,
+		MaxTime:      time.Second,
+	})
+	if err != nil {
+		t.Fatal("aggregate", err)
+	}
+	for i := 1; i <= 3; i++ {
+		var m M
+		if err := cursor.Next(&m); err != nil {
+			t.Fatalf("next %d returned %v", i, err)
+		}
+		if m["x"] != i {
+			t.Errorf("next %d returned %v", i, m)
+		}
+	}
+	if cursor.HasNext() {
+		t.Error("HasNext() = true after last document")
+	}
+	cursor.Close()
+
+	if len(commands) != 2 {
+		t.Fatalf("sent %d commands, want 2", len(commands))
+	}
+	agg := commands[0]
+	if agg["allowDiskUse"] != true || agg["maxTimeMS"] != int64(1000) || agg["cursor"].(map[string]interface{})["batchSize"] != 1 {
+		t.Errorf("aggregate command = %v", agg)
+	}
+	getMore := commands[1]
+	if getMore["getMore"] != int64(42) || getMore["collection"] != "c" || getMore["batchSize"] != 1 {
+		t.Errorf("getMore command = %v", getMore)
+	}
+}
+
+func TestAggregateClose(t *testing.T) {
+	var killed interface{}
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		switch {
+		case cmd["aggregate"] != nil:
+			if cmd["writeConcern"] == nil {
+				return M{"ok": 0, "errmsg": "expected writeConcern"}
+			}
+			return M{"ok": 1, "cursor": M{"id": int64(42), "ns": "test.c", "firstBatch": []M{}}}
+		case cmd["killCursors"] != nil:
+			killed = cmd["cursors"]
+		}
+		return M{"ok": 1}
+	})
+	defer c.Close()
+	coll := Collection{Conn: c, Namespace: "test.c", WriteConcern: &WriteConcern{W: 1}}
+
+	cursor, err := coll.Aggregate([]D{{{"$out", "d"}}}, nil)
+	if err != nil {
+		t.Fatal("aggregate", err)
+	}
+	cursor.Close()
+	if ids, ok := killed.([]interface{}); !ok || len(ids) != 1 || ids[0] != int64(42) {
+		t.Errorf("killCursors cursors = %v", killed)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// Authenticator authenticates connections. Set the Authenticator field in
+// DialOptions to authenticate connections when they are opened.
+// Applications can implement this interface to add authentication
+// mechanisms.
+type Authenticator interface {
+	Authenticate(conn Conn) error
+}
+
+// Authentication mechanisms.
+const (
+	mechanismX509  = "MONGODB-X509"
+	mechanismPlain = "PLAIN"
+)
+
+// PasswordAuthenticator authenticates with a username and password using
+// SCRAM-SHA-256, SCRAM-SHA-1 or MONGODB-CR.
+type PasswordAuthenticator struct {
+	// Database that holds the user's credentials. The default is "admin".
+	Source string
+
+	Username string
+	Password string
+
+	// Authentication mechanism: "SCRAM-SHA-256", "SCRAM-SHA-1" or
+	// "MONGODB-CR". If empty, the mechanism is negotiated with the server.
+	Mechanism string
+}
+
+func (a *PasswordAuthenticator) Authenticate(conn Conn) error {
+	source := a.Source
+	if source == "" {
+		source = "admin"
+	}
+	return authenticate(conn, source, a.Mechanism, a.Username, a.Password)
+}
+
+// X509Authenticator authenticates with the MONGODB-X509 mechanism using the
+// client certificate presented in the TLS handshake.
+type X509Authenticator struct {
+	// Username is the subject of the client certificate in RFC 2253 format.
+	// If empty, the subject is taken from Certificate. If both fields are
+	// empty, the server takes the username from the certificate (MongoDB 3.4
+	// and later).
+	Username string
+
+	// Client certificate.
+	Certificate *tls.Certificate
+}
+
+func (a *X509Authenticator) Authenticate(conn Conn) error {
+	username := a.Username
+	if username == "" && a.Certificate != nil && len(a.Certificate.Certificate) > 0 {
+		cert := a.Certificate.Leaf
+		if cert == nil {
+			var err error
+			if cert, err = x509.ParseCertificate(a.Certificate.Certificate[0]); err != nil {
+				return err
+			}
+		}
+		username = cert.Subject.String()
+	}
+	cmd := D{{"authenticate", 1}, {"mechanism", mechanismX509}}
+	if username != "" {
+		cmd.Append("user", username)
+	}
+	var r CommandResponse
+	if err := runInternal(conn, "$external", cmd, runFindOptions, &r); err != nil {
+		return err
+	}
+	return r.Err()
+}
+
+// PlainAuthenticator authenticates with the SASL PLAIN mechanism. The PLAIN
+// mechanism sends the password to the server without protection. Use PLAIN
+// with TLS connections only.
+type PlainAuthenticator struct {
+	// Database for authentication. The default is "$external".
+	Source string
+
+	Username string
+	Password string
+}
+
+func (a *PlainAuthenticator) Authenticate(conn Conn) error {
+	source := a.Source
+	if source == "" {
+		source = "$external"
+	}
+	return saslConversation(conn, source, &plainClient{username: a.Username, password: a.Password})
+}
+
+type plainClient struct {
+	username string
+	password string
+}
+
+func (c *plainClient) start() (string, []byte, error) {
+	return mechanismPlain, []byte("\x00" + c.username + "\x00" + c.password), nil
+}
+
+func (c *plainClient) next(challenge []byte) ([]byte, error) {
+	return nil, errors.New("mongo: unexpected PLAIN challenge")
+}
+
+func (c *plainClient) completed() bool {
+	return true
+}
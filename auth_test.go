@@ -0,0 +1,121 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"crypto/x509"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// serveTCP starts a fake server on a local TCP port and returns the server
+// address.
+func serveTCP(t *testing.T, handler msgHandler) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveMsg(conn, handler)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestPlainAuthenticator(t *testing.T) {
+	var payload []byte
+	addr := serveTCP(t, func(cmd M, sequences map[string][][]byte) interface{} {
+		switch {
+		case cmd["isMaster"] != nil:
+			return M{"ok": 1, "maxWireVersion": 17}
+		case cmd["saslStart"] != nil:
+			if cmd["$db"] != "$external" || cmd["mechanism"] != "PLAIN" {
+				return M{"ok": 0, "errmsg": "bad saslStart"}
+			}
+			payload = cmd["payload"].([]byte)
+			if string(payload) != "\x00user\x00pencil" {
+				return M{"ok": 0, "errmsg": "Authentication failed."}
+			}
+			return M{"ok": 1, "conversationId": 1, "done": true, "payload": []byte{}}
+		}
+		return M{"ok": 0, "errmsg": "unexpected command"}
+	})
+
+	c, err := DialWithOptions(addr, &DialOptions{Authenticator: &PlainAuthenticator{Username: "user", Password: "pencil"}})
+	if err != nil {
+		t.Fatal("dial", err)
+	}
+	c.Close()
+
+	if _, err := DialWithOptions(addr, &DialOptions{Authenticator: &PlainAuthenticator{Username: "user", Password: "wrong"}}); err == nil {
+		t.Fatal("dial with wrong password succeeded")
+	}
+}
+
+func TestX509Authenticator(t *testing.T) {
+	cert, _ := newTestCertificate(t)
+	var user interface{}
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		if cmd["authenticate"] == nil || cmd["$db"] != "$external" || cmd["mechanism"] != "MONGODB-X509" {
+			return M{"ok": 0, "errmsg": "unexpected command"}
+		}
+		user = cmd["user"]
+		return M{"ok": 1}
+	})
+	defer c.Close()
+
+	if err := (&X509Authenticator{}).Authenticate(c); err != nil || user != nil {
+		t.Errorf("authenticate without user returned %v, user=%v", err, user)
+	}
+	if err := (&X509Authenticator{Certificate: &cert}).Authenticate(c); err != nil || user != "CN=test" {
+		t.Errorf("authenticate with certificate returned %v, user=%v", err, user)
+	}
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if leaf.Subject.String() != "CN=test" {
+		t.Errorf("subject = %s", leaf.Subject)
+	}
+}
+
+func TestURIAuthenticator(t *testing.T) {
+	for _, tt := range []struct {
+		s        string
+		expected Authenticator
+	}{
+		{"mongodb://h", nil},
+		{"mongodb://u:p@h/db", &PasswordAuthenticator{Source: "db", Username: "u", Password: "p"}},
+		{"mongodb://u:p@h/?authMechanism=SCRAM-SHA-256", &PasswordAuthenticator{Source: "admin", Username: "u", Password: "p", Mechanism: "SCRAM-SHA-256"}},
+		{"mongodb://u:p@h/db?authMechanism=PLAIN", &PlainAuthenticator{Source: "$external", Username: "u", Password: "p"}},
+		{"mongodb://h/?authMechanism=MONGODB-X509", &X509Authenticator{}},
+	} {
+		u, err := ParseURI(tt.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		options, err := u.DialOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(options.Authenticator, tt.expected) {
+			t.Errorf("%s: authenticator = %+v, want %+v", tt.s, options.Authenticator, tt.expected)
+		}
+	}
+}
@@ -168,12 +168,73 @@ type BSONData struct {
 // Deocde decodes bd to v. See the Decode function for more information about
 // BSON decoding.
 func (bd BSONData) Decode(v interface{}) error {
-	return decodeInternal(bd.Kind, bd.Data, v)
+	return decodeInternal(bd.Kind, bd.Data, v, nil)
+}
+
+// Marshaler is the interface implemented by types that can encode themselves
+// as a BSON document. MarshalBSON returns the complete encoding of the
+// document including the length prefix and terminating null byte.
+type Marshaler interface {
+	MarshalBSON() ([]byte, error)
+}
+
+// Unmarshaler is the interface implemented by types that can decode a BSON
+// document representation of themselves. UnmarshalBSON must copy the data if
+// it wishes to retain the data after returning.
+type Unmarshaler interface {
+	UnmarshalBSON(data []byte) error
+}
+
+// ValueMarshaler is the interface implemented by types that can encode
+// themselves as a BSON value of any kind. The returned data is in the same
+// format as the BSONData Data field. If kind is zero, then the value is not
+// written to the encoding.
+type ValueMarshaler interface {
+	MarshalBSONValue() (kind int, data []byte, err error)
+}
+
+// ValueUnmarshaler is the interface implemented by types that can decode a
+// BSON value representation of themselves. UnmarshalBSONValue must copy the
+// data if it wishes to retain the data after returning.
+type ValueUnmarshaler interface {
+	UnmarshalBSONValue(kind int, data []byte) error
 }
 
 // Symbol represents a BSON symbol.
 type Symbol string
 
+// Binary represents BSON binary data with a subtype. Use Binary to read or
+// write the subtype. The type []byte is encoded as binary data with the
+// generic subtype.
+type Binary struct {
+	Subtype byte
+	Data    []byte
+}
+
+// Binary data subtypes.
+const (
+	BinaryGeneric     = 0x00
+	BinaryFunction    = 0x01
+	BinaryOld         = 0x02 // Data includes a leading int32 length.
+	BinaryUUIDOld     = 0x03
+	BinaryUUID        = 0x04
+	BinaryMD5         = 0x05
+	BinaryEncrypted   = 0x06
+	BinaryColumn      = 0x07
+	BinarySensitive   = 0x08
+	BinaryUserDefined = 0x80
+)
+
+// Undefined represents the deprecated BSON undefined value.
+type Undefined struct{}
+
+// DBPointer represents the deprecated BSON DBPointer value. New documents
+// should use DBRef instead.
+type DBPointer struct {
+	Namespace string
+	Id        ObjectId
+}
+
 // Code represents Javascript code in BSON.
 type Code string
 
@@ -217,38 +278,70 @@ const (
 	kindDocument      = 0x3
 	kindArray         = 0x4
 	kindBinary        = 0x5
+	kindUndefined     = 0x6
 	kindObjectId      = 0x7
 	kindBool          = 0x8
 	kindDateTime      = 0x9
 	kindNull          = 0xA
 	kindRegexp        = 0xB
+	kindDBPointer     = 0xC
 	kindCode          = 0xD
 	kindSymbol        = 0xE
 	kindCodeWithScope = 0xF
 	kindInt32         = 0x10
 	kindTimestamp     = 0x11
 	kindInt64         = 0x12
+	kindDecimal128    = 0x13
 	kindMinValue      = 0xff
 	kindMaxValue      = 0x7f
 )
 
+// BSON value kinds for use with BSONData, ValueMarshaler and
+// ValueUnmarshaler.
+const (
+	KindFloat         = kindFloat
+	KindString        = kindString
+	KindDocument      = kindDocument
+	KindArray         = kindArray
+	KindBinary        = kindBinary
+	KindUndefined     = kindUndefined
+	KindObjectId      = kindObjectId
+	KindBool          = kindBool
+	KindDateTime      = kindDateTime
+	KindNull          = kindNull
+	KindRegexp        = kindRegexp
+	KindDBPointer     = kindDBPointer
+	KindCode          = kindCode
+	KindSymbol        = kindSymbol
+	KindCodeWithScope = kindCodeWithScope
+	KindInt32         = kindInt32
+	KindTimestamp     = kindTimestamp
+	KindInt64         = kindInt64
+	KindDecimal128    = kindDecimal128
+	KindMinValue      = kindMinValue
+	KindMaxValue      = kindMaxValue
+)
+
 var kindNames = map[int]string{
 	kindFloat:         "float",
 	kindString:        "string",
 	kindDocument:      "document",
 	kindArray:         "array",
 	kindBinary:        "binary",
+	kindUndefined:     "undefined",
 	kindObjectId:      "objectId",
 	kindBool:          "bool",
 	kindDateTime:      "dateTime",
 	kindNull:          "null",
 	kindRegexp:        "regexp",
+	kindDBPointer:     "dbPointer",
 	kindCode:          "code",
 	kindSymbol:        "symbol",
 	kindCodeWithScope: "codeWithScope",
 	kindInt32:         "int32",
 	kindTimestamp:     "timestamp",
 	kindInt64:         "int64",
+	kindDecimal128:    "decimal128",
 	kindMinValue:      "minValue",
 	kindMaxValue:      "maxValue",
 }
@@ -271,68 +364,105 @@ type structSpec struct {
 	m      map[string]*fieldSpec
 	l      []*fieldSpec
 	fields D
+	// inline is the index of the field that holds document elements not
+	// matched by other fields or nil if there is no such field.
+	inline []int
 }
 
 func (ss *structSpec) fieldSpec(name []byte) *fieldSpec {
 	return ss.m[string(name)]
 }
 
-func compileStructSpec(t reflect.Type, depth map[string]int, index []int, ss *structSpec) {
+// isInlineMap returns true if a field with type t can hold the elements not
+// matched by the other fields in a struct.
+func isInlineMap(t reflect.Type) bool {
+	if t == typeD {
+		return true
+	}
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String && t.Elem() == typeInterface
+}
+
+func compileStructSpec(t reflect.Type, depth map[string]int, index []int, ss *structSpec, parents []reflect.Type) {
+	parents = append(parents, t)
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
-		switch {
-		case f.PkgPath != "":
+		if f.PkgPath != "" {
 			// Ignore unexported fields.
-		case f.Anonymous:
-			// TODO: Handle pointers. Requires change to decoder and
-			// protection against infinite recursion.
-			if f.Type.Kind() == reflect.Struct {
-				compileStructSpec(f.Type, depth, append(index, i), ss)
-			}
-		default:
-			fs := &fieldSpec{name: f.Name}
-			tag := f.Tag.Get("bson")
-			if strings.Contains(tag, "/c") {
-				panic("use ,omitempty instead of /c in bson field tag")
+			continue
+		}
+		fs := &fieldSpec{name: f.Name}
+		inline := f.Anonymous && f.Type.Kind() == reflect.Struct
+		tag := f.Tag.Get("bson")
+		if strings.Contains(tag, "/c") {
+			panic("use ,omitempty instead of /c in bson field tag")
+		}
+		p := strings.Split(tag, ",")
+		if len(p) > 0 && p[0] != "-" {
+			if len(p[0]) > 0 {
+				fs.name = p[0]
 			}
-			p := strings.Split(tag, ",")
-			if len(p) > 0 && p[0] != "-" {
-				if len(p[0]) > 0 {
-					fs.name = p[0]
-				}
-				for _, s := range p[1:] {
-					switch s {
-					case "omitempty":
-						fs.omitEmpty = true
-					default:
-						panic(errors.New("bson: unknown field flag " + s + " for type " + t.Name()))
-					}
+			for _, s := range p[1:] {
+				switch s {
+				case "omitempty":
+					fs.omitEmpty = true
+				case "inline":
+					inline = true
+				default:
+					panic(errors.New("bson: unknown field flag " + s + " for type " + t.Name()))
 				}
 			}
-			d, found := depth[fs.name]
-			if !found {
-				d = 1 << 30
+		}
+		if inline {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+				ft = ft.Elem()
 			}
 			switch {
-			case len(index) == d:
-				// At same depth, remove from result.
-				delete(ss.m, fs.name)
-				j := 0
-				for i := 0; i < len(ss.l); i++ {
-					if fs.name != ss.l[i].name {
-						ss.l[j] = ss.l[i]
-						j += 1
+			case isInlineMap(f.Type):
+				if ss.inline != nil {
+					panic(errors.New("bson: multiple inline maps in type " + t.Name()))
+				}
+				ss.inline = append(append([]int(nil), index...), i)
+			case ft.Kind() == reflect.Struct:
+				for _, pt := range parents {
+					if pt == ft {
+						panic(errors.New("bson: recursive inline field " + f.Name + " in type " + t.Name()))
 					}
 				}
-				ss.l = ss.l[:j]
-			case len(index) < d:
-				fs.index = make([]int, len(index)+1)
-				copy(fs.index, index)
-				fs.index[len(index)] = i
-				depth[fs.name] = len(index)
-				ss.m[fs.name] = fs
-				ss.l = append(ss.l, fs)
+				compileStructSpec(ft, depth, append(index, i), ss, parents)
+			default:
+				panic(errors.New("bson: inline field " + f.Name + " in type " + t.Name() + " is not a struct or map"))
 			}
+			continue
+		}
+		if f.Anonymous {
+			// Embedded types other than structs are ignored unless the
+			// field has the inline flag.
+			continue
+		}
+		d, found := depth[fs.name]
+		if !found {
+			d = 1 << 30
+		}
+		switch {
+		case len(index) == d:
+			// At same depth, remove from result.
+			delete(ss.m, fs.name)
+			j := 0
+			for i := 0; i < len(ss.l); i++ {
+				if fs.name != ss.l[i].name {
+					ss.l[j] = ss.l[i]
+					j += 1
+				}
+			}
+			ss.l = ss.l[:j]
+		case len(index) < d:
+			fs.index = make([]int, len(index)+1)
+			copy(fs.index, index)
+			fs.index[len(index)] = i
+			depth[fs.name] = len(index)
+			ss.m[fs.name] = fs
+			ss.l = append(ss.l, fs)
 		}
 	}
 }
@@ -360,7 +490,7 @@ func structSpecForType(t reflect.Type) *structSpec {
 	}
 
 	ss = &structSpec{m: make(map[string]*fieldSpec)}
-	compileStructSpec(t, make(map[string]int), nil, ss)
+	compileStructSpec(t, make(map[string]int), nil, ss, nil)
 
 	hasId := false
 	for _, fs := range ss.l {
@@ -380,9 +510,14 @@ func structSpecForType(t reflect.Type) *structSpec {
 }
 
 // StructFields returns a MongoDB field specification for the given struct
-// type.
+// type. StructFields returns nil if the struct has an inline map field because
+// all fields in the document are needed to fill the map.
 func StructFields(t reflect.Type) interface{} {
-	return structSpecForType(t).fields
+	ss := structSpecForType(t)
+	if ss.inline != nil {
+		return nil
+	}
+	return ss.fields
 }
 
 type aborted struct{ err error }
@@ -54,29 +54,46 @@ func (e *DecodeTypeError) Error() string {
 //      Integer32           -> signed and unsigned integers, floats, bool
 //      Integer64           -> signed and unsigned integers, floats, bool
 //      Array               -> []interface{}, other slice types
-//      Binary              -> []byte
+//      Binary              -> []byte, mongo.Binary
 //      Boolean             -> bool
+//      Code                -> mongo.Code, string, mongo.CodeWithScope
+//      CodeWithScope       -> mongo.CodeWithScope
 //      Datetime            -> time.Time, int64
-//      Document            -> map[string]interface{}, struct types
+//      DBPointer           -> mongo.DBPointer
+//      Decimal128          -> mongo.Decimal128
+//      Document            -> map[string]interface{}, mongo.D, struct types
 //      Double              -> signed and unsigned integers, floats, bool
 //      MinValue, MaxValue  -> mongo.MinMax
 //      ObjectID            -> mongo.ObjectId
+//      Regular expression  -> mongo.Regexp
 //      Symbol              -> mongo.Symbol, string
 //      Timestamp           -> mongo.Timestamp, int64
+//      Undefined           -> mongo.Undefined
 //      string              -> string
 //
+// If a pointer to the target value implements ValueUnmarshaler or
+// Unmarshaler, then the interface method is called with the BSON value instead
+// of using the conversions above. UnmarshalBSON is only called for documents.
+//
 // If a number overflows the target type or the BSON value cannot be converted
 // to the target type, then the decoding completes the best it can and an error
 // is returned.
 //
 // To decode a BSON value into a nil interface value, the first type listed in
-// the right hand column of the table above is used.
+// the right hand column of the table above is used, except that binary data
+// with a subtype other than the generic subtype is decoded to mongo.Binary.
 func Decode(data []byte, v interface{}) (err error) {
-	return decodeInternal(kindDocument, data, v)
+	return decodeInternal(kindDocument, data, v, nil)
+}
+
+// DecodeWithRegistry is like Decode, but uses the decoders in registry r
+// before the conversions described in the documentation for Decode.
+func DecodeWithRegistry(data []byte, v interface{}, r *Registry) error {
+	return decodeInternal(kindDocument, data, v, r)
 }
 
 // decodeInternal decodes BSON data with given kind to v.
-func decodeInternal(kind int, data []byte, v interface{}) (err error) {
+func decodeInternal(kind int, data []byte, v interface{}, r *Registry) (err error) {
 	defer handleAbort(&err)
 	value, ok := v.(reflect.Value)
 	if !ok {
@@ -96,7 +113,7 @@ func decodeInternal(kind int, data []byte, v interface{}) (err error) {
 		}
 	}
 
-	d := decodeState{data: data}
+	d := decodeState{data: data, registry: r}
 	d.decodeValue(kind, value)
 	return d.savedError
 }
@@ -106,10 +123,11 @@ type decodeState struct {
 	data       []byte
 	offset     int // read offset in data
 	savedError error
+	registry   *Registry
 }
 
-// saveError saves the first err it is called with, for reporting at the end of
-// Decode.
+// saveError saves the first non-nil err it is called with, for reporting at the
+// end of Decode.
 func (d *decodeState) saveError(err error) {
 	if d.savedError == nil {
 		d.savedError = err
@@ -188,6 +206,41 @@ func (d *decodeState) scanBinary() ([]byte, int) {
 	return d.scanSlice(n), subtype
 }
 
+func (d *decodeState) scanDecimal128() Decimal128 {
+	p := d.scanSlice(16)
+	return Decimal128{h: wire.Uint64(p[8:]), l: wire.Uint64(p[:8])}
+}
+
+func (d *decodeState) scanCString() string {
+	for i, b := range d.data[d.offset:] {
+		if b == 0 {
+			s := string(d.data[d.offset : d.offset+i])
+			d.offset += i + 1
+			return s
+		}
+	}
+	abort(ErrEOD)
+	panic("unreachable")
+}
+
+func (d *decodeState) scanRegexp() Regexp {
+	pattern := d.scanCString()
+	return Regexp{Pattern: pattern, Options: d.scanCString()}
+}
+
+func (d *decodeState) scanDBPointer() DBPointer {
+	ns := d.scanString()
+	return DBPointer{Namespace: ns, Id: ObjectId(d.scanSlice(12))}
+}
+
+func (d *decodeState) scanCodeWithScope() CodeWithScope {
+	offset := d.beginDoc()
+	code := d.scanString()
+	scope := d.decodeValueInterface(kindDocument).(map[string]interface{})
+	d.endDoc(offset)
+	return CodeWithScope{Code: code, Scope: scope}
+}
+
 func (d *decodeState) scanBool() bool {
 	b := d.scanByte()
 	if b == 0 {
@@ -204,8 +257,35 @@ func (d *decodeState) scanInt64() int64 {
 	return int64(wire.Uint64(d.scanSlice(8)))
 }
 
+// scanValue returns the encoding of the value with the given kind.
+func (d *decodeState) scanValue(kind int) []byte {
+	start := d.offset
+	d.skipValue(kind)
+	return d.data[start:d.offset]
+}
+
 func (d *decodeState) decodeValue(kind int, v reflect.Value) {
+	if dec := d.registry.decoder(v); dec != nil {
+		d.saveError(dec(kind, d.scanValue(kind), v))
+		return
+	}
 	v = d.indirect(v)
+	if dec := d.registry.decoder(v); dec != nil {
+		d.saveError(dec(kind, d.scanValue(kind), v))
+		return
+	}
+	switch u := unmarshaler(v).(type) {
+	case ValueUnmarshaler:
+		d.saveError(u.UnmarshalBSONValue(kind, d.scanValue(kind)))
+		return
+	case Unmarshaler:
+		if kind != kindDocument {
+			d.saveErrorAndSkip(kind, v.Type())
+			return
+		}
+		d.saveError(u.UnmarshalBSON(d.scanValue(kind)))
+		return
+	}
 	t := v.Type()
 	decoder, ok := typeDecoder[t]
 	if !ok {
@@ -237,6 +317,25 @@ func (d *decodeState) indirect(v reflect.Value) reflect.Value {
 	return v
 }
 
+// unmarshaler returns the Unmarshaler or ValueUnmarshaler implemented by a
+// pointer to v or v. If neither interface is implemented, then nil is
+// returned.
+func unmarshaler(v reflect.Value) interface{} {
+	if v.CanAddr() {
+		v = v.Addr()
+	}
+	if v.Type().NumMethod() == 0 || !v.CanInterface() {
+		return nil
+	}
+	switch u := v.Interface().(type) {
+	case ValueUnmarshaler:
+		return u
+	case Unmarshaler:
+		return u
+	}
+	return nil
+}
+
 func decodeFloat(d *decodeState, kind int, v reflect.Value) {
 	var f float64
 	switch kind {
@@ -341,11 +440,65 @@ func decodeObjectId(d *decodeState, kind int, v reflect.Value) {
 	v.SetString(string(p))
 }
 
+func decodeDecimal128(d *decodeState, kind int, v reflect.Value) {
+	if kind != kindDecimal128 {
+		d.saveErrorAndSkip(kind, v.Type())
+		return
+	}
+	v.Set(reflect.ValueOf(d.scanDecimal128()))
+}
+
+func decodeBinary(d *decodeState, kind int, v reflect.Value) {
+	if kind != kindBinary {
+		d.saveErrorAndSkip(kind, v.Type())
+		return
+	}
+	p, subtype := d.scanBinary()
+	b := Binary{Subtype: byte(subtype), Data: make([]byte, len(p))}
+	copy(b.Data, p)
+	v.Set(reflect.ValueOf(b))
+}
+
+func decodeUndefined(d *decodeState, kind int, v reflect.Value) {
+	if kind != kindUndefined {
+		d.saveErrorAndSkip(kind, v.Type())
+	}
+}
+
+func decodeRegexp(d *decodeState, kind int, v reflect.Value) {
+	if kind != kindRegexp {
+		d.saveErrorAndSkip(kind, v.Type())
+		return
+	}
+	v.Set(reflect.ValueOf(d.scanRegexp()))
+}
+
+func decodeDBPointer(d *decodeState, kind int, v reflect.Value) {
+	if kind != kindDBPointer {
+		d.saveErrorAndSkip(kind, v.Type())
+		return
+	}
+	v.Set(reflect.ValueOf(d.scanDBPointer()))
+}
+
+func decodeCodeWithScope(d *decodeState, kind int, v reflect.Value) {
+	var c CodeWithScope
+	switch kind {
+	default:
+		d.saveErrorAndSkip(kind, v.Type())
+		return
+	case kindCode:
+		c.Code = d.scanString()
+	case kindCodeWithScope:
+		c = d.scanCodeWithScope()
+	}
+	v.Set(reflect.ValueOf(c))
+}
+
 func decodeBSONData(d *decodeState, kind int, v reflect.Value) {
-	start := d.offset
-	d.skipValue(kind)
-	bd := BSONData{Kind: kind, Data: make([]byte, d.offset-start)}
-	copy(bd.Data, d.data[start:d.offset])
+	p := d.scanValue(kind)
+	bd := BSONData{Kind: kind, Data: make([]byte, len(p))}
+	copy(bd.Data, p)
 	v.Set(reflect.ValueOf(bd))
 }
 
@@ -428,6 +581,24 @@ func decodeMapStringInterface(d *decodeState, kind int, v reflect.Value) {
 	d.endDoc(offset)
 }
 
+func decodeD(d *decodeState, kind int, v reflect.Value) {
+	if kind != kindDocument {
+		d.saveErrorAndSkip(kind, v.Type())
+		return
+	}
+	var doc D
+	offset := d.beginDoc()
+	for {
+		kind, name := d.scanKindName()
+		if kind == 0 {
+			break
+		}
+		doc = append(doc, DocItem{string(name), d.decodeValueInterface(kind)})
+	}
+	d.endDoc(offset)
+	v.Set(reflect.ValueOf(doc))
+}
+
 func decodeMap(d *decodeState, kind int, v reflect.Value) {
 	t := v.Type()
 	if t.Key().Kind() != reflect.String || kind != kindDocument {
@@ -514,25 +685,60 @@ func decodeArray(d *decodeState, kind int, v reflect.Value) {
 	d.endDoc(offset)
 }
 
+// fieldByIndexAlloc returns the nested field of v with the given index.
+// Nil pointers in the path to the field are allocated.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
 func decodeStruct(d *decodeState, kind int, v reflect.Value) {
 	t := v.Type()
 	ss := structSpecForType(t)
+	var inline reflect.Value
+	var inlineD D
+	if ss.inline != nil {
+		inline = fieldByIndexAlloc(v, ss.inline)
+		if inline.Type() != typeD && inline.IsNil() {
+			inline.Set(reflect.MakeMap(inline.Type()))
+		}
+	}
 	offset := d.beginDoc()
 	for {
 		kind, name := d.scanKindName()
 		if kind == 0 {
 			break
 		}
-		if kind == kindNull {
-			continue
-		}
-		if fs := ss.fieldSpec(name); fs != nil {
-			d.decodeValue(kind, v.FieldByIndex(fs.index))
-		} else {
+		fs := ss.fieldSpec(name)
+		switch {
+		case fs == nil && inline.IsValid():
+			value := d.decodeValueInterface(kind)
+			if inline.Type() == typeD {
+				inlineD.Append(string(name), value)
+			} else {
+				key := reflect.ValueOf(string(name)).Convert(inline.Type().Key())
+				inline.SetMapIndex(key, reflect.ValueOf(&value).Elem())
+			}
+		case kind == kindNull:
+			// Leave the field unchanged.
+		case fs != nil:
+			d.decodeValue(kind, fieldByIndexAlloc(v, fs.index))
+		default:
 			d.skipValue(kind)
 		}
 	}
 	d.endDoc(offset)
+	if inline.IsValid() && inline.Type() == typeD {
+		inline.Set(reflect.ValueOf(inlineD))
+	}
 }
 
 func decodeInterface(d *decodeState, kind int, v reflect.Value) {
@@ -570,10 +776,15 @@ func (d *decodeState) decodeValueInterface(kind int) interface{} {
 		d.endDoc(offset)
 		return a
 	case kindBinary:
-		p, _ := d.scanBinary()
+		p, subtype := d.scanBinary()
 		newp := make([]byte, len(p))
 		copy(newp, p)
+		if subtype != BinaryGeneric {
+			return Binary{Subtype: byte(subtype), Data: newp}
+		}
 		return newp
+	case kindUndefined:
+		return Undefined{}
 	case kindObjectId:
 		return ObjectId(string(d.scanSlice(12)))
 	case kindBool:
@@ -582,6 +793,14 @@ func (d *decodeState) decodeValueInterface(kind int) interface{} {
 		return timeFromMS(d.scanInt64())
 	case kindNull:
 		return nil
+	case kindRegexp:
+		return d.scanRegexp()
+	case kindDBPointer:
+		return d.scanDBPointer()
+	case kindCode:
+		return Code(d.scanString())
+	case kindCodeWithScope:
+		return d.scanCodeWithScope()
 	case kindSymbol:
 		return Symbol(d.scanString())
 	case kindInt32:
@@ -590,6 +809,8 @@ func (d *decodeState) decodeValueInterface(kind int) interface{} {
 		return Timestamp(d.scanInt64())
 	case kindInt64:
 		return d.scanInt64()
+	case kindDecimal128:
+		return d.scanDecimal128()
 	case kindMinValue:
 		return MinValue
 	case kindMaxValue:
@@ -602,10 +823,16 @@ func (d *decodeState) decodeValueInterface(kind int) interface{} {
 
 func (d *decodeState) skipValue(kind int) {
 	switch kind {
-	case kindString, kindSymbol:
+	case kindString, kindSymbol, kindCode:
 		n := int(d.scanInt32())
 		d.offset += n
-	case kindDocument, kindArray:
+	case kindDBPointer:
+		n := int(d.scanInt32())
+		d.offset += n + 12
+	case kindRegexp:
+		d.scanCString()
+		d.scanCString()
+	case kindDocument, kindArray, kindCodeWithScope:
 		n := int(d.scanInt32())
 		d.offset += n - 4
 	case kindBinary:
@@ -619,7 +846,9 @@ func (d *decodeState) skipValue(kind int) {
 		d.offset += 8
 	case kindInt32:
 		d.offset += 4
-	case kindMinValue, kindMaxValue, kindNull:
+	case kindDecimal128:
+		d.offset += 16
+	case kindMinValue, kindMaxValue, kindNull, kindUndefined:
 		d.offset += 0
 	default:
 		abort(&DecodeTypeError{kind})
@@ -654,6 +883,12 @@ func init() {
 	}
 	typeDecoder = map[reflect.Type]decoderFunc{
 		reflect.TypeOf(BSONData{}):                   decodeBSONData,
+		reflect.TypeOf(Binary{}):                     decodeBinary,
+		reflect.TypeOf(CodeWithScope{}):              decodeCodeWithScope,
+		reflect.TypeOf(DBPointer{}):                  decodeDBPointer,
+		reflect.TypeOf(Regexp{}):                     decodeRegexp,
+		reflect.TypeOf(Undefined{}):                  decodeUndefined,
+		reflect.TypeOf(Decimal128{}):                 decodeDecimal128,
 		reflect.TypeOf(time.Time{}):                  decodeTime,
 		reflect.TypeOf(MinMax(0)):                    decodeMinMax,
 		reflect.TypeOf(ObjectId("")):                 decodeObjectId,
@@ -661,6 +896,7 @@ func init() {
 		reflect.TypeOf(Timestamp(0)):                 decodeTimestamp,
 		reflect.TypeOf(make(map[string]interface{})): decodeMapStringInterface,
 		reflect.TypeOf(M{}):                          decodeMapStringInterface,
+		reflect.TypeOf(D{}):                          decodeD,
 		reflect.TypeOf(new(interface{})).Elem():      decodeInterface,
 	}
 }
@@ -15,6 +15,7 @@
 package mongo
 
 import (
+	"bytes"
 	"errors"
 	"math"
 	"reflect"
@@ -23,10 +24,11 @@ import (
 )
 
 var (
-	typeD        = reflect.TypeOf(D{})
-	typeBSONData = reflect.TypeOf(BSONData{})
-	idKey        = reflect.ValueOf("_id")
-	itoas        = [...]string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
+	typeD         = reflect.TypeOf(D{})
+	typeBSONData  = reflect.TypeOf(BSONData{})
+	typeInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+	idKey         = reflect.ValueOf("_id")
+	itoas         = [...]string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
 )
 
 // EncodeTypeError is the error indicating that Encode could not encode an input type.
@@ -40,6 +42,7 @@ func (e *EncodeTypeError) Error() string {
 
 type encodeState struct {
 	buffer
+	registry *Registry
 }
 
 // Encode appends the BSON encoding of doc to buf and returns the new slice.
@@ -56,8 +59,20 @@ type encodeState struct {
 //
 //  omitempty   If the field is the zero value, then the field is not
 //              written to the encoding.
+//  inline      If the field is a struct or a pointer to a struct, then the
+//              fields of the struct are encoded in-line with the containing
+//              struct. If the field is a D or a map with string keys and
+//              interface{} values, then the elements of the field are encoded
+//              in-line with the containing struct. Decode stores document
+//              elements that do not match another field in the inline D or
+//              map. A struct can have at most one inline D or map. Nil
+//              elements of an inline D or map are encoded as BSON null and
+//              an _id element is encoded before the struct fields.
 //
 // Anonymous struct fields are encoded in-line with the containing struct.
+// Anonymous pointer to struct fields are encoded in-line only when the field
+// has the inline option. Fields reached through a nil pointer to an in-line
+// struct are not encoded.
 //
 // Array and slice values encode as BSON arrays.
 //
@@ -81,20 +96,33 @@ type encodeState struct {
 //      string              -> String
 //      []byte              -> Binary data
 //      time.Time           -> UTC Datetime
+//      mongo.Binary        -> Binary data with subtype
 //      mongo.Code          -> Javascript code
 //      mongo.CodeWithScope -> Javascript code with scope
 //      mongo.D             -> Document. Use when element order is important.
+//      mongo.DBPointer     -> DBPointer (deprecated)
+//      mongo.Decimal128    -> Decimal128
 //      mongo.MinMax        -> Minimum / Maximum value
 //      mongo.ObjectId      -> ObjectId
 //      mongo.Regexp        -> Regular expression
 //      mongo.Symbol        -> Symbol
 //      mongo.Timestamp     -> Timestamp
+//      mongo.Undefined     -> Undefined (deprecated)
+//
+// Types that implement ValueMarshaler or Marshaler are encoded using the
+// interface method. The interfaces are checked before the table above.
 //
 // Other types including channels, complex and function values cannot be encoded.
 //
 // BSON cannot represent cyclic data structure and Encode does not handle them.
 // Passing cyclic structures to Encode will result in an infinite recursion.
 func Encode(buf []byte, doc interface{}) (result []byte, err error) {
+	return EncodeWithRegistry(buf, doc, nil)
+}
+
+// EncodeWithRegistry is like Encode, but uses the encoders in registry r
+// before the encodings described in the documentation for Encode.
+func EncodeWithRegistry(buf []byte, doc interface{}, r *Registry) (result []byte, err error) {
 	defer handleAbort(&err)
 
 	v := reflect.ValueOf(doc)
@@ -102,7 +130,35 @@ func Encode(buf []byte, doc interface{}) (result []byte, err error) {
 		v = v.Elem()
 	}
 
-	e := encodeState{buffer: buf}
+	e := encodeState{buffer: buf, registry: r}
+	if enc := r.encoder(v); enc != nil {
+		kind, data, err := enc(v)
+		if err != nil {
+			return nil, err
+		}
+		if kind != kindDocument {
+			return nil, &EncodeTypeError{v.Type()}
+		}
+		checkValue(kind, data)
+		e.Write(data)
+		return e.buffer, nil
+	}
+	switch m := marshaler(v).(type) {
+	case ValueMarshaler:
+		kind, data, err := m.MarshalBSONValue()
+		if err != nil {
+			return nil, err
+		}
+		if kind != kindDocument {
+			return nil, &EncodeTypeError{v.Type()}
+		}
+		checkValue(kind, data)
+		e.Write(data)
+		return e.buffer, nil
+	case Marshaler:
+		e.Write(marshalDocument(m))
+		return e.buffer, nil
+	}
 	switch v.Type() {
 	case typeD:
 		e.writeD(v.Interface().(D))
@@ -141,16 +197,77 @@ func (e *encodeState) writeKindName(kind int, name string) {
 	e.WriteCString(name)
 }
 
+// fieldByIndex returns the nested field of v with the given index. The zero
+// Value is returned if the path to the field contains a nil pointer.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
 func (e *encodeState) writeStruct(v reflect.Value) {
 	offset := e.beginDoc()
 	ss := structSpecForType(v.Type())
+	var inline reflect.Value
+	if ss.inline != nil {
+		// Write an _id from the inline map first, as is done for the _id in
+		// a top-level map.
+		inline = fieldByIndex(v, ss.inline)
+		e.writeInline(ss, inline, true)
+	}
 	for _, fs := range ss.l {
-		e.encodeValue(fs.name, fs, v.FieldByIndex(fs.index))
+		e.encodeValue(fs.name, fs, fieldByIndex(v, fs.index))
+	}
+	if ss.inline != nil {
+		e.writeInline(ss, inline, false)
 	}
 	e.WriteByte(0)
 	e.endDoc(offset)
 }
 
+// writeInline writes the elements of an inline map field. If id is true, then
+// only the _id element is written. Otherwise, all elements except _id are
+// written.
+func (e *encodeState) writeInline(ss *structSpec, v reflect.Value, id bool) {
+	if !v.IsValid() || v.IsNil() {
+		return
+	}
+	if v.Type() == typeD {
+		for _, kv := range v.Interface().(D) {
+			if (kv.Key == "_id") == id {
+				e.writeInlineValue(ss, kv.Key, reflect.ValueOf(kv.Value))
+			}
+		}
+		return
+	}
+	for _, k := range v.MapKeys() {
+		if name := k.String(); (name == "_id") == id {
+			e.writeInlineValue(ss, name, v.MapIndex(k))
+		}
+	}
+}
+
+// writeInlineValue writes an element of an inline map field. Unlike other
+// fields, nil values are written as BSON null so that documents decoded to the
+// inline map are written back unchanged.
+func (e *encodeState) writeInlineValue(ss *structSpec, name string, v reflect.Value) {
+	if ss.m[name] != nil {
+		abort(errors.New("bson: inline map key " + name + " duplicates struct field"))
+	}
+	if !v.IsValid() || (v.Kind() == reflect.Interface && v.IsNil()) {
+		e.writeKindName(kindNull, name)
+		return
+	}
+	e.encodeValue(name, defaultFieldSpec, v)
+}
+
 func (e *encodeState) writeMap(v reflect.Value, topLevel bool) {
 	if v.IsNil() {
 		return
@@ -190,6 +307,20 @@ func (e *encodeState) encodeValue(name string, fs *fieldSpec, v reflect.Value) {
 	if !v.IsValid() {
 		return
 	}
+	if enc := e.registry.encoder(v); enc != nil {
+		e.writeValue(name, enc, v)
+		return
+	}
+	switch m := marshaler(v).(type) {
+	case ValueMarshaler:
+		e.writeValue(name, func(reflect.Value) (int, []byte, error) { return m.MarshalBSONValue() }, v)
+		return
+	case Marshaler:
+		data := marshalDocument(m)
+		e.writeKindName(kindDocument, name)
+		e.Write(data)
+		return
+	}
 	t := v.Type()
 	encoder, found := typeEncoder[t]
 	if !found {
@@ -201,6 +332,116 @@ func (e *encodeState) encodeValue(name string, fs *fieldSpec, v reflect.Value) {
 	encoder(e, name, fs, v)
 }
 
+// writeValue writes the value returned by enc. If the kind returned by enc is
+// zero, then nothing is written.
+func (e *encodeState) writeValue(name string, enc ValueEncoder, v reflect.Value) {
+	kind, data, err := enc(v)
+	if err != nil {
+		abort(err)
+	}
+	if kind != 0 {
+		checkValue(kind, data)
+		e.writeKindName(kind, name)
+		e.Write(data)
+	}
+}
+
+// encodeValueData returns the encoding of a single value v as BSONData. If v
+// is not written to the encoding, then the zero BSONData is returned.
+func encodeValueData(v interface{}, r *Registry) (bd BSONData, err error) {
+	defer handleAbort(&err)
+	e := encodeState{registry: r}
+	e.encodeValue("", defaultFieldSpec, reflect.ValueOf(v))
+	if len(e.buffer) == 0 {
+		return BSONData{}, nil
+	}
+	// Skip the kind and the empty name.
+	return BSONData{Kind: int(e.buffer[0]), Data: e.buffer[2:]}, nil
+}
+
+// marshaler returns the Marshaler or ValueMarshaler implemented by v or a
+// pointer to v. If neither interface is implemented, then nil is returned.
+func marshaler(v reflect.Value) interface{} {
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		if m := marshaler(v.Addr()); m != nil {
+			return m
+		}
+	}
+	if v.Type().NumMethod() == 0 || !v.CanInterface() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	switch m := v.Interface().(type) {
+	case ValueMarshaler:
+		return m
+	case Marshaler:
+		return m
+	}
+	return nil
+}
+
+func marshalDocument(m Marshaler) []byte {
+	data, err := m.MarshalBSON()
+	if err != nil {
+		abort(err)
+	}
+	if len(data) < 5 || int(wire.Uint32(data)) != len(data) || data[len(data)-1] != 0 {
+		abort(errors.New("bson: MarshalBSON returned invalid document"))
+	}
+	return data
+}
+
+// checkValue aborts the encoding if data is not a valid encoding of a value
+// with the given kind. Data returned from ValueMarshaler and ValueEncoder
+// implementations is copied to the output and must be checked first.
+func checkValue(kind int, data []byte) {
+	n := -1
+	switch kind {
+	case kindDocument, kindArray, kindCodeWithScope:
+		if len(data) >= 5 && data[len(data)-1] == 0 {
+			n = int(wire.Uint32(data))
+		}
+	case kindString, kindCode, kindSymbol:
+		if len(data) >= 5 && data[len(data)-1] == 0 {
+			n = 4 + int(wire.Uint32(data))
+		}
+	case kindDBPointer:
+		if len(data) >= 17 && data[len(data)-13] == 0 {
+			n = 16 + int(wire.Uint32(data))
+		}
+	case kindBinary:
+		if len(data) >= 5 {
+			n = 5 + int(wire.Uint32(data))
+		}
+	case kindRegexp:
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			if j := bytes.IndexByte(data[i+1:], 0); j >= 0 {
+				n = i + j + 2
+			}
+		}
+	case kindFloat, kindDateTime, kindTimestamp, kindInt64:
+		n = 8
+	case kindInt32:
+		n = 4
+	case kindObjectId:
+		n = 12
+	case kindBool:
+		n = 1
+	case kindDecimal128:
+		n = 16
+	case kindMinValue, kindMaxValue, kindNull, kindUndefined:
+		n = 0
+	}
+	if n != len(data) {
+		abort(errors.New("bson: invalid " + kindName(kind) + " value returned by encoder"))
+	}
+}
+
 func encodeBool(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
 	b := v.Bool()
 	if b == false && fs.omitEmpty {
@@ -334,6 +575,35 @@ func encodeBSONData(e *encodeState, name string, fs *fieldSpec, v reflect.Value)
 	e.Write(bd.Data)
 }
 
+func encodeBinary(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
+	b := v.Interface().(Binary)
+	if b.Subtype == 0 && len(b.Data) == 0 && fs.omitEmpty {
+		return
+	}
+	e.writeKindName(kindBinary, name)
+	e.WriteUint32(uint32(len(b.Data)))
+	e.WriteByte(b.Subtype)
+	e.Write(b.Data)
+}
+
+func encodeUndefined(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
+	e.writeKindName(kindUndefined, name)
+}
+
+func encodeDBPointer(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
+	p := v.Interface().(DBPointer)
+	if p.Namespace == "" && p.Id == "" && fs.omitEmpty {
+		return
+	}
+	if len(p.Id) != 12 {
+		abort(errors.New("bson: object id length != 12"))
+	}
+	e.writeKindName(kindDBPointer, name)
+	e.WriteUint32(uint32(len(p.Namespace) + 1))
+	e.WriteCString(p.Namespace)
+	copy(e.Next(12), p.Id)
+}
+
 func encodeCodeWithScope(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
 	c := v.Interface().(CodeWithScope)
 	if c.Code == "" && c.Scope == nil && fs.omitEmpty {
@@ -376,6 +646,16 @@ func encodeTime(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
 	e.WriteUint64(uint64(msFromTime(t)))
 }
 
+func encodeDecimal128(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
+	d := v.Interface().(Decimal128)
+	if d == (Decimal128{}) && fs.omitEmpty {
+		return
+	}
+	e.writeKindName(kindDecimal128, name)
+	e.WriteUint64(d.l)
+	e.WriteUint64(d.h)
+}
+
 func encodeStruct(e *encodeState, name string, fs *fieldSpec, v reflect.Value) {
 	e.writeKindName(kindDocument, name)
 	e.writeStruct(v)
@@ -483,7 +763,10 @@ func init() {
 		reflect.TypeOf(Code("")): func(e *encodeState, name string, fs *fieldSpec, value reflect.Value) {
 			encodeString(e, kindCode, name, fs, value)
 		},
+		reflect.TypeOf(Binary{}):        encodeBinary,
 		reflect.TypeOf(CodeWithScope{}): encodeCodeWithScope,
+		reflect.TypeOf(DBPointer{}):     encodeDBPointer,
+		reflect.TypeOf(Decimal128{}):    encodeDecimal128,
 		reflect.TypeOf(time.Time{}):     encodeTime,
 		reflect.TypeOf(MinMax(0)):       encodeMinMax,
 		reflect.TypeOf(ObjectId("")):    encodeObjectId,
@@ -494,5 +777,6 @@ func init() {
 		reflect.TypeOf(Timestamp(0)): func(e *encodeState, name string, fs *fieldSpec, value reflect.Value) {
 			encodeInt64(e, kindTimestamp, name, fs, value)
 		},
+		reflect.TypeOf(Undefined{}): encodeUndefined,
 	}
 }
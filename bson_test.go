@@ -16,6 +16,9 @@ package mongo
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"math"
 	"reflect"
 	"testing"
@@ -130,6 +133,22 @@ type stCodeWithScope struct {
 	Test CodeWithScope `bson:"test,omitempty"`
 }
 
+type stCode struct {
+	Test Code `bson:"test,omitempty"`
+}
+
+type stUndefined struct {
+	Test Undefined `bson:"test"`
+}
+
+type stDBPointer struct {
+	Test DBPointer `bson:"test,omitempty"`
+}
+
+type stBinarySubtype struct {
+	Test Binary `bson:"test,omitempty"`
+}
+
 type stAny struct {
 	Test interface{} `bson:"test,omitempty"`
 }
@@ -191,6 +210,9 @@ var bsonTests = []struct {
 	{stRegexp{}, empty, empty, "\x05\x00\x00\x00\x00"},
 	{stTimestamp{}, empty, empty, "\x05\x00\x00\x00\x00"},
 	{stTime{}, empty, empty, "\x05\x00\x00\x00\x00"},
+	{stCode{}, empty, empty, "\x05\x00\x00\x00\x00"},
+	{stDBPointer{}, empty, empty, "\x05\x00\x00\x00\x00"},
+	{stBinarySubtype{}, empty, empty, "\x05\x00\x00\x00\x00"},
 
 	{
 		stEmpty{},
@@ -358,7 +380,7 @@ var bsonTests = []struct {
 	{
 		stRegexp{Regexp{"a*b", "i"}},
 		testMap(Regexp{"a*b", "i"}),
-		nil,
+		testMap(Regexp{"a*b", "i"}),
 		"\x11\x00\x00\x00\vtest\x00a*b\x00i\x00\x00",
 	},
 
@@ -369,6 +391,48 @@ var bsonTests = []struct {
 		"\x1d\x00\x00\x00\x0ftest\x00\x12\x00\x00\x00\x05\x00\x00\x00test\x00\x05\x00\x00\x00\x00\x00",
 	},
 
+	{
+		stCodeWithScope{CodeWithScope{"x", map[string]interface{}{"y": 1}}},
+		testMap(CodeWithScope{"x", map[string]interface{}{"y": 1}}),
+		testMap(CodeWithScope{"x", map[string]interface{}{"y": 1}}),
+		"!\x00\x00\x00\x0ftest\x00\x16\x00\x00\x00\x02\x00\x00\x00x\x00\x0c\x00\x00\x00\x10y\x00\x01\x00\x00\x00\x00\x00",
+	},
+
+	{
+		stCode{"x"},
+		testMap(Code("x")),
+		testMap(Code("x")),
+		"\x11\x00\x00\x00\x0dtest\x00\x02\x00\x00\x00x\x00\x00",
+	},
+
+	{
+		stUndefined{},
+		testMap(Undefined{}),
+		testMap(Undefined{}),
+		"\x0b\x00\x00\x00\x06test\x00\x00",
+	},
+
+	{
+		stDBPointer{DBPointer{"db.c", ObjectId("\x4c\x9b\x8f\xb4\xa3\x82\xaa\xfe\x17\xc8\x6e\x63")}},
+		testMap(DBPointer{"db.c", ObjectId("\x4c\x9b\x8f\xb4\xa3\x82\xaa\xfe\x17\xc8\x6e\x63")}),
+		testMap(DBPointer{"db.c", ObjectId("\x4c\x9b\x8f\xb4\xa3\x82\xaa\xfe\x17\xc8\x6e\x63")}),
+		"\x20\x00\x00\x00\x0ctest\x00\x05\x00\x00\x00db.c\x00\x4c\x9b\x8f\xb4\xa3\x82\xaa\xfe\x17\xc8\x6e\x63\x00",
+	},
+
+	{
+		stBinarySubtype{Binary{BinaryUUID, []byte("0123456789abcdef")}},
+		testMap(Binary{BinaryUUID, []byte("0123456789abcdef")}),
+		testMap(Binary{BinaryUUID, []byte("0123456789abcdef")}),
+		"\x20\x00\x00\x00\x05test\x00\x10\x00\x00\x00\x040123456789abcdef\x00",
+	},
+
+	{
+		stBinarySubtype{Binary{BinaryGeneric, []byte("test")}},
+		testMap(Binary{BinaryGeneric, []byte("test")}),
+		testMap([]byte("test")),
+		"\x14\x00\x00\x00\x05test\x00\x04\x00\x00\x00\x00test\x00",
+	},
+
 	{
 		stTimestamp{1168216211000},
 		testMap(Timestamp(1168216211000)),
@@ -605,6 +669,293 @@ func TestEncodeOrderedMap(t *testing.T) {
 	}
 }
 
+func TestDecodeOrderedMap(t *testing.T) {
+	expected := D{{"z", 1}, {"a", "hello"}, {"m", map[string]interface{}{"x": true}}}
+	p, err := Encode(nil, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual D
+	if err := Decode(p, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Decode() = %v, want %v", actual, expected)
+	}
+}
+
+// cents encodes as a string with two decimal places.
+type cents int64
+
+func (c cents) MarshalBSONValue() (int, []byte, error) {
+	s := fmt.Sprintf("%d.%02d", c/100, c%100)
+	p := make([]byte, 4, 4+len(s)+1)
+	binary.LittleEndian.PutUint32(p, uint32(len(s)+1))
+	p = append(p, s...)
+	return KindString, append(p, 0), nil
+}
+
+func (c *cents) UnmarshalBSONValue(kind int, data []byte) error {
+	var s string
+	if err := (BSONData{Kind: kind, Data: data}).Decode(&s); err != nil {
+		return err
+	}
+	var whole, frac int64
+	if _, err := fmt.Sscanf(s, "%d.%d", &whole, &frac); err != nil {
+		return err
+	}
+	*c = cents(whole*100 + frac)
+	return nil
+}
+
+// point encodes as the document {xy: [x, y]}.
+type point struct{ x, y int }
+
+func (p *point) MarshalBSON() ([]byte, error) {
+	return Encode(nil, M{"xy": []int{p.x, p.y}})
+}
+
+func (p *point) UnmarshalBSON(data []byte) error {
+	var m struct {
+		XY []int `bson:"xy"`
+	}
+	if err := Decode(data, &m); err != nil {
+		return err
+	}
+	if len(m.XY) != 2 {
+		return errors.New("bad point")
+	}
+	p.x, p.y = m.XY[0], m.XY[1]
+	return nil
+}
+
+type stMarshaler struct {
+	Price cents
+	Pos   point
+	Ptr   *point
+	Nil   *point
+}
+
+func TestMarshaler(t *testing.T) {
+	v := stMarshaler{Price: 1234, Pos: point{1, 2}, Ptr: &point{3, 4}}
+	p, err := Encode(nil, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m M
+	if err := Decode(p, &m); err != nil {
+		t.Fatal(err)
+	}
+	expected := "map[Pos:map[xy:[1 2]] Price:12.34 Ptr:map[xy:[3 4]]]"
+	if s := fmt.Sprint(m); s != expected {
+		t.Errorf("encoded %s, want %s", s, expected)
+	}
+
+	var actual stMarshaler
+	if err := Decode(p, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, v) {
+		t.Errorf("Decode() = %+v, want %+v", actual, v)
+	}
+
+	p, err = Encode(nil, &point{5, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pt point
+	if err := Decode(p, &pt); err != nil || pt != (point{5, 6}) {
+		t.Errorf("Decode() = %v, %v, want {5 6}", pt, err)
+	}
+
+	p, _ = Encode(nil, M{"Pos": "bad"})
+	if err := Decode(p, &actual); err == nil {
+		t.Error("Decode string to Unmarshaler did not return an error")
+	}
+}
+
+// rawValue encodes as the kind and data in the value.
+type rawValue BSONData
+
+func (v rawValue) MarshalBSONValue() (int, []byte, error) {
+	return v.Kind, v.Data, nil
+}
+
+var valueMarshalerTests = []struct {
+	v  rawValue
+	ok bool
+}{
+	{rawValue{KindDocument, []byte{1, 2, 3}}, false},
+	{rawValue{KindDocument, []byte("\x07\x00\x00\x00\x00\x00")}, false},
+	{rawValue{KindDocument, []byte("\x05\x00\x00\x00\x01")}, false},
+	{rawValue{KindDocument, []byte("\x05\x00\x00\x00\x00")}, true},
+	{rawValue{KindArray, []byte("\x05\x00\x00\x00")}, false},
+	{rawValue{KindString, []byte("\x02\x00\x00\x00a\x00")}, true},
+	{rawValue{KindString, []byte("\x03\x00\x00\x00a\x00")}, false},
+	{rawValue{KindString, []byte("\x02\x00\x00\x00ab")}, false},
+	{rawValue{KindBinary, []byte("\x01\x00\x00\x00\x00a")}, true},
+	{rawValue{KindBinary, []byte("\x02\x00\x00\x00\x00a")}, false},
+	{rawValue{KindRegexp, []byte("a\x00i\x00")}, true},
+	{rawValue{KindRegexp, []byte("a\x00i")}, false},
+	{rawValue{KindInt32, []byte{1, 0, 0, 0}}, true},
+	{rawValue{KindInt32, []byte{1, 0, 0}}, false},
+	{rawValue{KindInt64, []byte{1, 0, 0, 0}}, false},
+	{rawValue{KindObjectId, make([]byte, 12)}, true},
+	{rawValue{KindDecimal128, make([]byte, 8)}, false},
+	{rawValue{KindBool, []byte{}}, false},
+	{rawValue{KindNull, nil}, true},
+	{rawValue{KindNull, []byte{0}}, false},
+	{rawValue{0x20, nil}, false},
+}
+
+func TestValueMarshalerCheck(t *testing.T) {
+	for _, tt := range valueMarshalerTests {
+		p, err := Encode(nil, M{"v": tt.v})
+		if tt.ok != (err == nil) {
+			t.Errorf("Encode(%v) = %q, %v, want ok=%v", tt.v, p, err, tt.ok)
+		}
+	}
+	if _, err := Encode(nil, rawValue{KindDocument, []byte{1, 2, 3}}); err == nil {
+		t.Error("Encode of invalid top-level document did not return an error")
+	}
+}
+
+type InlinePart struct {
+	B int `bson:"b"`
+}
+
+type stInline struct {
+	A           int `bson:"a"`
+	*InlinePart `bson:",inline"`
+	Rest        M `bson:",inline"`
+}
+
+type inlineKey string
+
+type stInlineKey struct {
+	A    int                       `bson:"a"`
+	Rest map[inlineKey]interface{} `bson:",inline"`
+}
+
+type stInlineD struct {
+	A    int `bson:"a"`
+	Rest D   `bson:",inline"`
+}
+
+func TestInline(t *testing.T) {
+	v := stInline{A: 1, Rest: M{"x": "y"}}
+	p, err := Encode(nil, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m M
+	if err := Decode(p, &m); err != nil {
+		t.Fatal(err)
+	}
+	expected := "map[a:1 x:y]"
+	if s := fmt.Sprint(m); s != expected {
+		t.Errorf("encoded %s, want %s", s, expected)
+	}
+
+	p, _ = Encode(nil, D{{"a", 1}, {"b", 2}, {"x", "y"}})
+	var actual stInline
+	if err := Decode(p, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if actual.A != 1 || actual.InlinePart == nil || actual.B != 2 || !reflect.DeepEqual(actual.Rest, M{"x": "y"}) {
+		t.Errorf("Decode() = %+v", actual)
+	}
+
+	var d stInlineD
+	if err := Decode(p, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.A != 1 || !reflect.DeepEqual(d.Rest, D{{"b", 2}, {"x", "y"}}) {
+		t.Errorf("Decode() = %+v", d)
+	}
+	p2, err := Encode(nil, &d)
+	if err != nil || string(p2) != string(p) {
+		t.Errorf("Encode(%+v) = %q, %v, want %q", d, p2, err, p)
+	}
+
+	var k stInlineKey
+	if err := Decode(p, &k); err != nil {
+		t.Fatal(err)
+	}
+	if k.A != 1 || !reflect.DeepEqual(k.Rest, map[inlineKey]interface{}{"b": 2, "x": "y"}) {
+		t.Errorf("Decode() = %+v", k)
+	}
+	if p2, err := Encode(nil, &k); err != nil || len(p2) != len(p) {
+		t.Errorf("Encode(%+v) = %q, %v, want %q", k, p2, err, p)
+	}
+
+	// Null elements and _id round trip through the inline D and map.
+	p, _ = Encode(nil, D{{"_id", 7}, {"a", 1}, {"n", BSONData{Kind: KindNull}}, {"z", 2}})
+	d = stInlineD{}
+	if err := Decode(p, &d); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.Rest, D{{"_id", 7}, {"n", nil}, {"z", 2}}) {
+		t.Errorf("Decode() = %+v", d)
+	}
+	if p2, err := Encode(nil, &d); err != nil || string(p2) != string(p) {
+		t.Errorf("Encode(%+v) = %q, %v, want %q", d, p2, err, p)
+	}
+	var nv stInline
+	if err := Decode(p, &nv); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := nv.Rest["n"]; !ok || n != nil {
+		t.Errorf("Decode() = %+v, want n:nil", nv)
+	}
+	p2, err = Encode(nil, &nv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dn D
+	if err := Decode(p2, &dn); err != nil {
+		t.Fatal(err)
+	}
+	if len(dn) != 4 || dn[0].Key != "_id" || dn[1].Key != "a" {
+		t.Errorf("Encode(%+v) = %v, want _id and a first", nv, dn)
+	}
+	for _, kv := range dn {
+		if kv.Key == "n" && kv.Value != nil {
+			t.Errorf("Encode(%+v) = %v, want n:nil", nv, dn)
+		}
+	}
+
+	v.Rest["a"] = 2
+	if _, err := Encode(nil, &v); err == nil {
+		t.Error("Encode with duplicate inline key did not return an error")
+	}
+	if fields := StructFields(reflect.TypeOf(v)); fields != nil {
+		t.Errorf("StructFields() = %v, want nil", fields)
+	}
+}
+
+func TestSkipLegacyKinds(t *testing.T) {
+	p, err := Encode(nil, D{
+		{"a", Undefined{}},
+		{"b", DBPointer{"db.c", NewObjectId()}},
+		{"c", Regexp{"a*b", "i"}},
+		{"d", Code("x")},
+		{"e", CodeWithScope{"x", M{"y": 1}}},
+		{"f", Binary{BinaryMD5, []byte("0123456789abcdef")}},
+		{"z", 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		Z int `bson:"z"`
+	}
+	if err := Decode(p, &v); err != nil || v.Z != 1 {
+		t.Errorf("Decode() = %+v, %v, want {Z:1}", v, err)
+	}
+}
+
 func TestObjectId(t *testing.T) {
 	t1 := time.Now()
 	min := MinObjectIdForTime(t1)
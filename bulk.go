@@ -0,0 +1,420 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+)
+
+// Kinds of bulk operations.
+const (
+	bulkInsert = iota
+	bulkUpdate
+	bulkDelete
+)
+
+// bulkCommands is the write command name and document array field for each
+// kind of bulk operation.
+var bulkCommands = [...]struct{ name, field string }{
+	bulkInsert: {"insert", "documents"},
+	bulkUpdate: {"update", "updates"},
+	bulkDelete: {"delete", "deletes"},
+}
+
+// Space reserved for the command fields and message header in a write
+// command.
+const commandOverhead = 16 * 1024
+
+// errCodeBSONObjectTooLarge is the server error code for a document that
+// exceeds maxBsonObjectSize.
+const errCodeBSONObjectTooLarge = 10334
+
+// Checks on the update document in an update operation.
+const (
+	checkNone        = iota
+	checkOperators   // all top-level keys must be update operators
+	checkReplacement // no top-level keys can be update operators
+)
+
+type bulkOp struct {
+	kind int
+	doc  interface{}
+
+	// The update document and the check on the update document for update
+	// operations. The update is the value of the "u" element at index 1 in
+	// doc.
+	update interface{}
+	check  int
+}
+
+// Bulk is a list of write operations on a collection. Use the Collection Bulk
+// method to create a Bulk, add operations with the Bulk methods and then call
+// Run to send the operations to the server.
+type Bulk struct {
+	c            Collection
+	ops          []bulkOp
+	unordered    bool
+	writeConcern *WriteConcern
+}
+
+// BulkResult is the result of Bulk.Run. The map keys in InsertedIds and
+// UpsertedIds are the index of the operation in the bulk.
+type BulkResult struct {
+	WriteResult
+
+	// Number of documents inserted by insert operations.
+	InsertedCount int
+}
+
+// Bulk returns a new ordered bulk operation on the collection.
+func (c Collection) Bulk() *Bulk {
+	return &Bulk{c: c}
+}
+
+// Unordered sets the bulk to unordered mode. The server attempts all
+// operations in an unordered bulk. By default, the server stops at the first
+// failed operation.
+func (b *Bulk) Unordered() *Bulk {
+	b.unordered = true
+	return b
+}
+
+// WriteConcern sets the write concern for the bulk. The default is the
+// collection's write concern.
+func (b *Bulk) WriteConcern(wc *WriteConcern) *Bulk {
+	b.writeConcern = wc
+	return b
+}
+
+// Insert adds an insert operation for each document. Documents without an
+// _id are inserted with a new ObjectId.
+func (b *Bulk) Insert(documents ...interface{}) *Bulk {
+	for _, doc := range documents {
+		b.ops = append(b.ops, bulkOp{kind: bulkInsert, doc: doc})
+	}
+	return b
+}
+
+func (b *Bulk) update(selector, update interface{}, upsert, multi bool, check int) *Bulk {
+	if selector == nil {
+		selector = emptyDoc
+	}
+	u := D{{"q", selector}, {"u", update}, {"upsert", upsert}, {"multi", multi}}
+	b.ops = append(b.ops, bulkOp{kind: bulkUpdate, doc: u, update: update, check: check})
+	return b
+}
+
+// UpdateOne adds an operation that updates the first document matching
+// selector with update. The update must contain only update operators.
+func (b *Bulk) UpdateOne(selector, update interface{}) *Bulk {
+	return b.update(selector, update, false, false, checkOperators)
+}
+
+// UpdateMany adds an operation that updates all documents matching selector
+// with update. The update must contain only update operators.
+func (b *Bulk) UpdateMany(selector, update interface{}) *Bulk {
+	return b.update(selector, update, false, true, checkOperators)
+}
+
+// UpsertMany adds an operation that updates all documents matching selector
+// with update. If no document matches, then a document created from the
+// selector and update is inserted instead. The update must contain only
+// update operators.
+func (b *Bulk) UpsertMany(selector, update interface{}) *Bulk {
+	return b.update(selector, update, true, true, checkOperators)
+}
+
+// ReplaceOne adds an operation that replaces the first document matching
+// selector with replacement. The replacement must not contain update
+// operators.
+func (b *Bulk) ReplaceOne(selector, replacement interface{}) *Bulk {
+	return b.update(selector, replacement, false, false, checkReplacement)
+}
+
+// UpsertReplace adds an operation that replaces the first document matching
+// selector with replacement. If no document matches, then the replacement is
+// inserted instead. The replacement must not contain update operators.
+func (b *Bulk) UpsertReplace(selector, replacement interface{}) *Bulk {
+	return b.update(selector, replacement, true, false, checkReplacement)
+}
+
+// Upsert adds an operation that updates the first document matching selector
+// with update. If no document matches, then the update is inserted instead.
+// The update can be a replacement document or contain update operators.
+func (b *Bulk) Upsert(selector, update interface{}) *Bulk {
+	return b.update(selector, update, true, false, checkNone)
+}
+
+// DeleteOne adds an operation that removes the first document matching
+// selector.
+func (b *Bulk) DeleteOne(selector interface{}) *Bulk {
+	return b.delete(selector, 1)
+}
+
+// DeleteMany adds an operation that removes all documents matching selector.
+func (b *Bulk) DeleteMany(selector interface{}) *Bulk {
+	return b.delete(selector, 0)
+}
+
+func (b *Bulk) delete(selector interface{}, limit int) *Bulk {
+	if selector == nil {
+		selector = emptyDoc
+	}
+	b.ops = append(b.ops, bulkOp{kind: bulkDelete, doc: D{{"q", selector}, {"limit", limit}}})
+	return b
+}
+
+// bulkBatch is a list of operations of the same kind sent in one write
+// command.
+type bulkBatch struct {
+	kind    int
+	indexes []int // index of each operation in the bulk
+	size    int   // encoded size of the operations
+}
+
+// batchLimits returns the maximum number of operations in a write command,
+// the maximum encoded size of a single operation and the maximum encoded size
+// of the operations in a write command.
+func batchLimits(conn Conn) (int, int, int) {
+	count := defaultMaxWriteBatchSize
+	size := defaultMaxBSONObjectSize
+	messageSize := defaultMaxMessageSizeBytes
+	if info := serverInfo(conn); info != nil {
+		if info.MaxWriteBatchSize > 0 {
+			count = info.MaxWriteBatchSize
+		}
+		if info.MaxBSONObjectSize > 0 {
+			size = info.MaxBSONObjectSize
+		}
+		if info.MaxMessageSizeBytes > 0 {
+			messageSize = info.MaxMessageSizeBytes
+		}
+	}
+	// The operations are sent in an array in the command document.
+	batchSize := size
+	if batchSize > messageSize-commandOverhead {
+		batchSize = messageSize - commandOverhead
+	}
+	return count, size, batchSize
+}
+
+// batches splits the operations into batches. The size of each encoded
+// operation is in sizes.
+func (b *Bulk) batches(sizes []int, maxCount, maxSize int) []*bulkBatch {
+	var result []*bulkBatch
+	current := make(map[int]*bulkBatch)
+	var last *bulkBatch
+	for i, op := range b.ops {
+		batch := current[op.kind]
+		if !b.unordered {
+			batch = last
+		}
+		// Each operation is an array element with the decimal index as
+		// the element name.
+		size := sizes[i] + len(strconv.Itoa(len(b.ops))) + 2
+		if batch == nil ||
+			batch.kind != op.kind ||
+			len(batch.indexes) >= maxCount ||
+			(len(batch.indexes) > 0 && batch.size+size > maxSize) {
+			batch = &bulkBatch{kind: op.kind}
+			result = append(result, batch)
+			current[op.kind] = batch
+			last = batch
+		}
+		batch.indexes = append(batch.indexes, i)
+		batch.size += size
+	}
+	return result
+}
+
+// Run sends the operations to the server and returns the aggregated result.
+// Operations are sent in batches as limited by the server's
+// maxWriteBatchSize, maxBsonObjectSize and maxMessageSizeBytes. Unordered
+// operations are grouped by kind.
+//
+// If an operation fails, then Run returns the result of the operations
+// applied by the server and a *WriteErrors containing every write error. The
+// Index of each write error is the index of the operation in the bulk. An
+// ordered bulk stops at the first failed operation.
+//
+// Run returns an error without sending any operations if an update document
+// does not match the kind of update or if an operation is larger than the
+// server's maxBsonObjectSize. The error for an operation that is too large is
+// a *WriteErrors.
+func (b *Bulk) Run() (*BulkResult, error) {
+	if len(b.ops) == 0 {
+		return nil, errors.New("mongo: no operations in bulk")
+	}
+	if !writeCommands(b.c.Conn) {
+		return nil, errNoWriteCommands
+	}
+
+	maxCount, maxObjectSize, maxBatchSize := batchLimits(b.c.Conn)
+	docs := make([]interface{}, len(b.ops))
+	sizes := make([]int, len(b.ops))
+	ids := make([]interface{}, len(b.ops))
+	var werr WriteErrors
+	for i, op := range b.ops {
+		var data BSONData
+		var err error
+		if op.kind == bulkInsert {
+			data, ids[i], err = documentWithId(op.doc, b.c.registry())
+		} else {
+			doc := op.doc
+			if op.check != checkNone {
+				doc, err = b.checkUpdate(i, op)
+			}
+			if err == nil {
+				data.Kind = kindDocument
+				data.Data, err = EncodeWithRegistry(nil, doc, b.c.registry())
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = data
+		sizes[i] = len(data.Data)
+		if sizes[i] > maxObjectSize {
+			werr.Errors = append(werr.Errors, WriteError{
+				Index:  i,
+				Code:   errCodeBSONObjectTooLarge,
+				Errmsg: "operation size " + strconv.Itoa(sizes[i]) + " exceeds maxBsonObjectSize " + strconv.Itoa(maxObjectSize),
+			})
+		}
+	}
+	if len(werr.Errors) > 0 {
+		return nil, &werr
+	}
+
+	result := &BulkResult{}
+	for _, batch := range b.batches(sizes, maxCount, maxBatchSize) {
+		batchDocs := make([]interface{}, len(batch.indexes))
+		for i, index := range batch.indexes {
+			batchDocs[i] = docs[index]
+		}
+		command := bulkCommands[batch.kind]
+		cmd := D{{command.name, b.c.Name()}, {command.field, batchDocs}, {"ordered", !b.unordered}}
+		r, err := b.c.runWrite(cmd, b.writeConcern)
+		if err == nil {
+			err = r.CommandResponse.Err()
+		}
+		if err != nil {
+			return result, err
+		}
+
+		applied := len(batch.indexes)
+		failed := make(map[int]bool)
+		for _, e := range r.WriteErrors {
+			if !b.unordered && e.Index < applied {
+				applied = e.Index
+			}
+			failed[e.Index] = true
+			e.Index = batch.indexes[e.Index]
+			werr.Errors = append(werr.Errors, e)
+		}
+		if r.WriteConcernError != nil {
+			werr.WriteConcernError = r.WriteConcernError
+		}
+
+		switch batch.kind {
+		case bulkInsert:
+			result.InsertedCount += r.N
+			for i, index := range batch.indexes[:applied] {
+				if failed[i] {
+					continue
+				}
+				if result.InsertedIds == nil {
+					result.InsertedIds = make(map[int]interface{})
+				}
+				result.InsertedIds[index] = ids[index]
+			}
+		case bulkUpdate:
+			result.MatchedCount += r.N - len(r.Upserted)
+			result.ModifiedCount += r.NModified
+			result.UpsertedCount += len(r.Upserted)
+			for _, u := range r.Upserted {
+				if result.UpsertedIds == nil {
+					result.UpsertedIds = make(map[int]interface{})
+				}
+				result.UpsertedIds[batch.indexes[u.Index]] = u.Id
+			}
+		case bulkDelete:
+			result.DeletedCount += r.N
+		}
+
+		if !b.unordered && len(r.WriteErrors) > 0 {
+			break
+		}
+	}
+
+	switch {
+	case len(werr.Errors) > 0:
+		sort.Slice(werr.Errors, func(i, j int) bool { return werr.Errors[i].Index < werr.Errors[j].Index })
+		return result, &werr
+	case werr.WriteConcernError != nil:
+		return result, werr.WriteConcernError
+	}
+	return result, nil
+}
+
+// checkUpdate returns an error if the update document in operation op at
+// index i does not match the kind of update. Otherwise, checkUpdate returns
+// the operation document with the update document replaced by its encoding.
+func (b *Bulk) checkUpdate(i int, op bulkOp) (D, error) {
+	update := op.update
+	if update == nil {
+		update = emptyDoc
+	}
+	p, err := EncodeWithRegistry(nil, update, b.c.registry())
+	if err != nil {
+		return nil, err
+	}
+	n, operators, err := countOperators(p)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case op.check == checkOperators && (n == 0 || operators != n):
+		return nil, errors.New("mongo: update at index " + strconv.Itoa(i) + " must contain only update operators")
+	case op.check == checkReplacement && operators != 0:
+		return nil, errors.New("mongo: replacement at index " + strconv.Itoa(i) + " must not contain update operators")
+	}
+	doc := append(D(nil), op.doc.(D)...)
+	doc[1].Value = BSONData{Kind: kindDocument, Data: p}
+	return doc, nil
+}
+
+// countOperators returns the number of top-level keys in encoded document p
+// and the number of those keys that start with '$'.
+func countOperators(p []byte) (n, operators int, err error) {
+	defer handleAbort(&err)
+	d := decodeState{data: p}
+	offset := d.beginDoc()
+	for {
+		kind, name := d.scanKindName()
+		if kind == 0 {
+			break
+		}
+		n += 1
+		if len(name) > 0 && name[0] == '$' {
+			operators += 1
+		}
+		d.skipValue(kind)
+	}
+	d.endDoc(offset)
+	return n, operators, nil
+}
@@ -0,0 +1,219 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// bulkServer returns a connection to a fake server that records the write
+// commands as "name:count" strings. The handler returns the reply for each
+// command.
+func bulkServer(info *ServerInfo, commands *[]string, handler func(name string, ops []interface{}) M) *connection {
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		for _, command := range bulkCommands {
+			if cmd[command.name] != nil {
+				ops := cmd[command.field].([]interface{})
+				*commands = append(*commands, fmt.Sprintf("%s:%d", command.name, len(ops)))
+				return handler(command.name, ops)
+			}
+		}
+		return M{"ok": 0, "errmsg": "unexpected command"}
+	})
+	c.info = info
+	return c
+}
+
+var bulkInfo = &ServerInfo{
+	MaxWireVersion:      6,
+	MaxBSONObjectSize:   defaultMaxBSONObjectSize,
+	MaxMessageSizeBytes: defaultMaxMessageSizeBytes,
+	MaxWriteBatchSize:   2,
+}
+
+func TestBulkOrdered(t *testing.T) {
+	var commands []string
+	c := bulkServer(bulkInfo, &commands, func(name string, ops []interface{}) M {
+		switch name {
+		case "update":
+			return M{"ok": 1, "n": 2, "nModified": 1, "upserted": []M{{"index": 1, "_id": "u"}}}
+		}
+		return M{"ok": 1, "n": len(ops)}
+	})
+	defer c.Close()
+
+	result, err := Collection{Conn: c, Namespace: "test.c"}.Bulk().
+		Insert(M{"_id": 1}, M{"_id": 2}, M{"_id": 3}).
+		UpdateOne(M{"_id": 1}, M{"$set": M{"x": 1}}).
+		Upsert(M{"_id": "u"}, M{"x": 2}).
+		DeleteMany(nil).
+		Run()
+	if err != nil {
+		t.Fatal("run", err)
+	}
+	expectedCommands := "insert:2 insert:1 update:2 delete:1"
+	if s := strings.Join(commands, " "); s != expectedCommands {
+		t.Errorf("commands = %s, want %s", s, expectedCommands)
+	}
+	expected := &BulkResult{
+		WriteResult: WriteResult{
+			InsertedIds:   map[int]interface{}{0: 1, 1: 2, 2: 3},
+			MatchedCount:  1,
+			ModifiedCount: 1,
+			UpsertedCount: 1,
+			UpsertedIds:   map[int]interface{}{4: "u"},
+			DeletedCount:  1,
+		},
+		InsertedCount: 3,
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("result = %+v, want %+v", result, expected)
+	}
+}
+
+func TestBulkWriteErrors(t *testing.T) {
+	var commands []string
+	c := bulkServer(bulkInfo, &commands, func(name string, ops []interface{}) M {
+		if name == "insert" {
+			return M{"ok": 1, "n": 1, "writeErrors": []M{{"index": 0, "code": 11000, "errmsg": "dup"}}}
+		}
+		return M{"ok": 1, "n": len(ops)}
+	})
+	defer c.Close()
+	coll := Collection{Conn: c, Namespace: "test.c"}
+
+	// The unordered bulk groups the inserts and continues after the error.
+	result, err := coll.Bulk().Unordered().
+		Insert(M{"_id": 1}).
+		DeleteOne(M{"_id": 2}).
+		Insert(M{"_id": 3}).
+		Run()
+	werr, ok := err.(*WriteErrors)
+	if !ok || len(werr.Errors) != 1 || werr.Errors[0].Index != 0 {
+		t.Fatalf("unordered run returned %v", err)
+	}
+	if s := strings.Join(commands, " "); s != "insert:2 delete:1" {
+		t.Errorf("unordered commands = %s", s)
+	}
+	if !reflect.DeepEqual(result.InsertedIds, map[int]interface{}{2: 3}) || result.DeletedCount != 1 {
+		t.Errorf("unordered result = %+v", result)
+	}
+
+	// The ordered bulk stops at the first error.
+	commands = nil
+	result, err = coll.Bulk().
+		DeleteOne(M{"_id": 2}).
+		Insert(M{"_id": 1}, M{"_id": 3}).
+		DeleteOne(M{"_id": 4}).
+		Run()
+	werr, ok = err.(*WriteErrors)
+	if !ok || len(werr.Errors) != 1 || werr.Errors[0].Index != 1 {
+		t.Fatalf("ordered run returned %v", err)
+	}
+	if s := strings.Join(commands, " "); s != "delete:1 insert:2" {
+		t.Errorf("ordered commands = %s", s)
+	}
+	if len(result.InsertedIds) != 0 || result.DeletedCount != 1 {
+		t.Errorf("ordered result = %+v", result)
+	}
+}
+
+func TestBulkSplitBySize(t *testing.T) {
+	info := *bulkInfo
+	info.MaxWriteBatchSize = 1000
+	info.MaxBSONObjectSize = 250
+	var commands []string
+	c := bulkServer(&info, &commands, func(name string, ops []interface{}) M {
+		return M{"ok": 1, "n": len(ops)}
+	})
+	defer c.Close()
+
+	b := Collection{Conn: c, Namespace: "test.c"}.Bulk()
+	for i := 0; i < 5; i++ {
+		b.Insert(M{"_id": i, "s": strings.Repeat("x", 100)})
+	}
+	result, err := b.Run()
+	if err != nil {
+		t.Fatal("run", err)
+	}
+	if s := strings.Join(commands, " "); s != "insert:2 insert:2 insert:1" {
+		t.Errorf("commands = %s", s)
+	}
+	if result.InsertedCount != 5 {
+		t.Errorf("InsertedCount = %d, want 5", result.InsertedCount)
+	}
+}
+
+func TestBulkUpdateChecks(t *testing.T) {
+	var commands []string
+	var updates []interface{}
+	c := bulkServer(bulkInfo, &commands, func(name string, ops []interface{}) M {
+		updates = append(updates, ops...)
+		return M{"ok": 1, "n": len(ops)}
+	})
+	defer c.Close()
+	coll := Collection{Conn: c, Namespace: "test.c"}
+
+	for _, b := range []*Bulk{
+		coll.Bulk().UpdateOne(M{"_id": 1}, M{"x": 1}),
+		coll.Bulk().UpdateMany(nil, M{"$set": M{"x": 1}, "y": 1}),
+		coll.Bulk().UpsertMany(nil, nil),
+		coll.Bulk().Insert(M{"_id": 1}).ReplaceOne(M{"_id": 1}, M{"$set": M{"x": 1}}),
+		coll.Bulk().UpsertReplace(M{"_id": 1}, D{{"x", 1}, {"$inc", M{"y": 1}}}),
+	} {
+		if _, err := b.Run(); err == nil {
+			t.Errorf("run of %+v did not return an error", b.ops)
+		}
+	}
+	if len(commands) != 0 {
+		t.Fatalf("commands = %v, want none", commands)
+	}
+
+	_, err := coll.Bulk().
+		UpsertMany(M{"x": 1}, M{"$set": M{"y": 1}}).
+		UpsertReplace(M{"_id": 1}, M{"x": 2}).
+		Run()
+	if err != nil {
+		t.Fatal("run", err)
+	}
+	expected := "[map[multi:true q:map[x:1] u:map[$set:map[y:1]] upsert:true] map[multi:false q:map[_id:1] u:map[x:2] upsert:true]]"
+	if s := fmt.Sprint(updates); s != expected {
+		t.Errorf("updates = %s, want %s", s, expected)
+	}
+}
+
+func TestBulkTooLarge(t *testing.T) {
+	info := *bulkInfo
+	info.MaxBSONObjectSize = 250
+	var commands []string
+	c := bulkServer(&info, &commands, func(name string, ops []interface{}) M {
+		return M{"ok": 1, "n": len(ops)}
+	})
+	defer c.Close()
+
+	_, err := Collection{Conn: c, Namespace: "test.c"}.Bulk().
+		Insert(M{"_id": 1}, M{"_id": 2, "s": strings.Repeat("x", 300)}).
+		Run()
+	werr, ok := err.(*WriteErrors)
+	if !ok || len(werr.Errors) != 1 || werr.Errors[0].Index != 1 || werr.Errors[0].Code != errCodeBSONObjectTooLarge {
+		t.Fatalf("run returned %v", err)
+	}
+	if len(commands) != 0 {
+		t.Errorf("commands = %v, want none", commands)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"context"
+	"errors"
+)
+
+// commandCursor is a cursor for the {cursor: {id, ns, firstBatch}} document
+// returned by commands such as aggregate, listCollections and listIndexes. The
+// cursor fetches more results with the getMore command and kills the cursor on
+// the server with the killCursors command.
+type commandCursor struct {
+	conn      Conn
+	namespace string
+	id        int64
+	batchSize int
+	docs      []BSONData
+	registry  *Registry
+	err       error
+}
+
+// runCursor runs the command cmd on database dbname and returns a cursor for
+// the command reply. The batch size is used for getMore commands.
+func runCursor(ctx context.Context, conn Conn, dbname string, cmd interface{}, batchSize int) (*commandCursor, error) {
+	var r cursorReply
+	if err := runInternalContext(ctx, conn, dbname, cmd, runFindOptions, &r); err != nil {
+		return nil, err
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return &commandCursor{
+		conn:      conn,
+		namespace: r.Cursor.Namespace,
+		id:        r.Cursor.Id,
+		batchSize: batchSize,
+		docs:      r.batch(),
+		registry:  connRegistry(conn),
+	}, nil
+}
+
+// RunCursor runs the command cmd on the database and returns a cursor for the
+// cursor document in the command reply. Use RunCursor for commands that return
+// the document {cursor: {id, ns, firstBatch}}. The cursor fetches batches of
+// batchSize documents with the getMore command. If batchSize is zero, then the
+// server default is used. Close the cursor to kill the cursor on the server.
+// RunCursor requires MongoDB 3.2 or later.
+func (db Database) RunCursor(cmd interface{}, batchSize int) (Cursor, error) {
+	r, err := runCursor(context.Background(), db.Conn, db.Name, cmd, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListCollections returns a cursor for the specifications of the collections
+// in the database. The optional filter selects the collections. The
+// specification documents have the fields name, type, options and info.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/listCollections/
+func (db Database) ListCollections(filter interface{}) (Cursor, error) {
+	cmd := D{{"listCollections", 1}}
+	if filter != nil {
+		cmd.Append("filter", filter)
+	}
+	return db.RunCursor(cmd, 0)
+}
+
+// ListIndexes returns a cursor for the specifications of the indexes on the
+// collection. Use IndexSpecs to decode all of the specifications.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/listIndexes/
+func (c Collection) ListIndexes() (Cursor, error) {
+	return c.Db().RunCursor(D{{"listIndexes", c.Name()}}, 0)
+}
+
+func (r *commandCursor) Close() error {
+	if r.err != nil {
+		return nil
+	}
+	if r.id != 0 && r.conn.Err() == nil {
+		dbname, cmd := killCursorsCommand(r.namespace, []uint64{uint64(r.id)})
+		var reply BSONData
+		runInternal(r.conn, dbname, cmd, runFindOptions, &reply)
+	}
+	r.id = 0
+	r.docs = nil
+	r.err = errors.New("mongo: cursor closed")
+	return nil
+}
+
+func (r *commandCursor) fatal(err error) error {
+	if r.err == nil {
+		r.Close()
+		r.err = err
+	}
+	return err
+}
+
+func (r *commandCursor) Err() error {
+	return r.err
+}
+
+func (r *commandCursor) HasNext() bool {
+	return r.HasNextContext(context.Background())
+}
+
+func (r *commandCursor) HasNextContext(ctx context.Context) bool {
+	for {
+		if r.err != nil {
+			return r.err != Done
+		}
+		if len(r.docs) > 0 || ctx.Err() != nil {
+			return true
+		}
+		if r.id == 0 {
+			r.fatal(Done)
+			return false
+		}
+		dbname, cname := SplitNamespace(r.namespace)
+		cmd := D{{"getMore", r.id}, {"collection", cname}}
+		if r.batchSize > 0 {
+			cmd.Append("batchSize", r.batchSize)
+		}
+		var reply cursorReply
+		if err := runInternalContext(ctx, r.conn, dbname, cmd, runFindOptions, &reply); err != nil {
+			// The position of the cursor on the server is not known after a
+			// failed getMore.
+			r.fatal(err)
+			return true
+		}
+		if err := reply.Err(); err != nil {
+			r.id = 0
+			r.fatal(err)
+			return true
+		}
+		r.id = reply.Cursor.Id
+		r.docs = reply.batch()
+		if len(r.docs) == 0 && r.id != 0 {
+			// Tailable cursors and getMores cut short by maxTimeMS return
+			// an empty batch for a live cursor. Let the caller decide
+			// whether to try again.
+			return false
+		}
+	}
+}
+
+func (r *commandCursor) Next(value interface{}) error {
+	return r.NextContext(context.Background(), value)
+}
+
+func (r *commandCursor) NextContext(ctx context.Context, value interface{}) error {
+	if !r.HasNextContext(ctx) {
+		return Done
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.docs) == 0 {
+		return ctx.Err()
+	}
+	bd := r.docs[0]
+	r.docs[0] = BSONData{}
+	r.docs = r.docs[1:]
+	return decodeInternal(bd.Kind, bd.Data, value, r.registry)
+}
@@ -0,0 +1,132 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"testing"
+)
+
+func TestListCollectionsAndIndexes(t *testing.T) {
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		switch {
+		case cmd["listCollections"] != nil:
+			if cmd["filter"] == nil {
+				return M{"ok": 0, "errmsg": "expected filter"}
+			}
+			return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "test.$cmd.listCollections", "firstBatch": []M{
+				{"name": "a", "type": "collection"},
+				{"name": "b", "type": "view"},
+			}}}
+		case cmd["listIndexes"] == "c":
+			return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "test.c", "firstBatch": []M{
+				{"v": 2, "key": M{"_id": 1}, "name": "_id_"},
+			}}}
+		}
+		return M{"ok": 0, "errmsg": "unexpected command"}
+	})
+	defer c.Close()
+	db := Database{Conn: c, Name: "test"}
+
+	cursor, err := db.ListCollections(M{"type": "collection"})
+	if err != nil {
+		t.Fatal("ListCollections", err)
+	}
+	var names []string
+	for cursor.HasNext() {
+		var spec struct {
+			Name string `bson:"name"`
+		}
+		if err := cursor.Next(&spec); err != nil {
+			t.Fatal("next", err)
+		}
+		names = append(names, spec.Name)
+	}
+	cursor.Close()
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("names = %v", names)
+	}
+
+	cursor, err = db.C("c").ListIndexes()
+	if err != nil {
+		t.Fatal("ListIndexes", err)
+	}
+	var index M
+	if err := cursor.Next(&index); err != nil || index["name"] != "_id_" {
+		t.Errorf("next returned %v, %v", index, err)
+	}
+	if err := cursor.Next(&index); err != Done {
+		t.Errorf("next returned %v, want Done", err)
+	}
+	cursor.Close()
+
+	if _, err := db.RunCursor(D{{"bogus", 1}}, 0); err == nil {
+		t.Error("RunCursor with bad command did not return error")
+	}
+}
+
+func TestCommandCursorNotFound(t *testing.T) {
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		if cmd["getMore"] != nil {
+			return M{"ok": 0, "code": 43, "errmsg": "cursor id 7 not found"}
+		}
+		return M{"ok": 1, "cursor": M{"id": int64(7), "ns": "test.c", "firstBatch": []M{}}}
+	})
+	defer c.Close()
+
+	cursor, err := Database{Conn: c, Name: "test"}.RunCursor(D{{"find", "c"}}, 0)
+	if err != nil {
+		t.Fatal("RunCursor", err)
+	}
+	var m M
+	if err := cursor.Next(&m); err == nil || err == Done {
+		t.Errorf("next returned %v, want cursor not found error", err)
+	}
+	if cursor.Err() == nil {
+		t.Error("Err() = nil after failed getMore")
+	}
+}
+
+func TestCommandCursorEmptyBatch(t *testing.T) {
+	getMores := 0
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		if cmd["getMore"] != nil {
+			getMores += 1
+			if getMores == 1 {
+				return M{"ok": 1, "cursor": M{"id": int64(7), "ns": "test.c", "nextBatch": []M{}}}
+			}
+			return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "test.c", "nextBatch": []M{{"x": 1}}}}
+		}
+		return M{"ok": 1, "cursor": M{"id": int64(7), "ns": "test.c", "firstBatch": []M{}}}
+	})
+	defer c.Close()
+
+	cursor, err := Database{Conn: c, Name: "test"}.RunCursor(D{{"find", "c"}, {"tailable", true}}, 0)
+	if err != nil {
+		t.Fatal("RunCursor", err)
+	}
+	if cursor.HasNext() {
+		t.Fatal("HasNext() = true for empty batch")
+	}
+	if getMores != 1 || cursor.Err() != nil {
+		t.Fatalf("getMores = %d, Err() = %v, want 1, nil", getMores, cursor.Err())
+	}
+	var m M
+	if err := cursor.Next(&m); err != nil || m["x"] != 1 {
+		t.Errorf("next returned %v, %v", m, err)
+	}
+	if cursor.HasNext() || cursor.Err() != Done {
+		t.Errorf("Err() = %v, want Done", cursor.Err())
+	}
+}
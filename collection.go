@@ -39,9 +39,29 @@ type Collection struct {
 	// name of the database and <collection> is the name of the collection.
 	Namespace string
 
-	// Command used to check for errors after on insert, update or remove
-	// operation on the collection. If nil, then errors are not checked.
-	LastErrorCmd interface{}
+	// Write concern for insert, update and remove operations on the
+	// collection. If nil, the server default is used.
+	WriteConcern *WriteConcern
+
+	// If not nil, documents sent to and received from the collection are
+	// encoded and decoded using the registry.
+	Registry *Registry
+}
+
+// conn returns the connection for the collection's operations.
+func (c Collection) conn() Conn {
+	if c.Registry != nil {
+		return NewRegistryConn(c.Conn, c.Registry)
+	}
+	return c.Conn
+}
+
+// registry returns the registry used to encode the collection's documents.
+func (c Collection) registry() *Registry {
+	if c.Registry != nil {
+		return c.Registry
+	}
+	return connRegistry(c.Conn)
 }
 
 // Name returns the collection's name.
@@ -54,34 +74,93 @@ func (c Collection) Name() string {
 func (c Collection) Db() Database {
 	name, _ := SplitNamespace(c.Namespace)
 	return Database{
-		Conn:         c.Conn,
+		Conn:         c.conn(),
 		Name:         name,
-		LastErrorCmd: c.LastErrorCmd,
+		WriteConcern: c.WriteConcern,
 	}
 }
 
+// writeCommands returns true if the server supports the insert, update and
+// delete commands.
+func writeCommands(conn Conn) bool {
+	info := serverInfo(conn)
+	return info == nil || info.MaxWireVersion >= writeCommandsWireVersion
+}
+
+// runWrite runs the write command cmd with write concern wc. If wc is nil,
+// then the collection's write concern is used.
+func (c Collection) runWrite(cmd D, wc *WriteConcern) (*writeReply, error) {
+	if wc == nil {
+		wc = c.WriteConcern
+	}
+	dbname, _ := SplitNamespace(c.Namespace)
+	var r writeReply
+	if err := runInternal(c.conn(), dbname, withWriteConcern(cmd, wc), runFindOptions, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// checkError checks the result of a write on a server that does not support
+// write commands using getLastError with the collection's write concern.
 func (c Collection) checkError(err error) (*MongoError, error) {
 	if err != nil {
 		return nil, err
 	}
-	if c.LastErrorCmd == nil {
+	if !c.WriteConcern.acknowledged() {
 		return nil, nil
 	}
-	return c.Db().LastError(c.LastErrorCmd)
+	return c.Db().LastError(c.WriteConcern.lastErrorCmd())
 }
 
 // Insert adds document to the collection.
 func (c Collection) Insert(documents ...interface{}) error {
-	_, err := c.checkError(c.Conn.Insert(c.Namespace, nil, documents...))
-	return err
+	if !writeCommands(c.Conn) {
+		_, err := c.checkError(c.conn().Insert(c.Namespace, nil, documents...))
+		return err
+	}
+	if len(documents) == 0 {
+		return errors.New("mongo: insert with no documents")
+	}
+	r, err := c.runWrite(D{{"insert", c.Name()}, {"documents", documents}}, nil)
+	if err != nil {
+		return err
+	}
+	return r.Err()
+}
+
+// update updates documents matching selector and returns the number of
+// matched documents.
+func (c Collection) update(selector, update interface{}, options *UpdateOptions) (int, error) {
+	if selector == nil {
+		selector = emptyDoc
+	}
+	if !writeCommands(c.Conn) {
+		merr, err := c.checkError(c.conn().Update(c.Namespace, selector, update, options))
+		if merr == nil || !merr.Updated {
+			return 0, err
+		}
+		return merr.N, err
+	}
+	u := D{
+		{"q", selector},
+		{"u", update},
+		{"upsert", options.Upsert},
+		{"multi", options.Multi},
+	}
+	r, err := c.runWrite(D{{"update", c.Name()}, {"updates", []interface{}{u}}}, nil)
+	if err != nil {
+		return 0, err
+	}
+	return r.N, r.Err()
 }
 
 // Update updates the first document in the collection found by selector with
 // update. If a matching document is not found, then mongo.ErrNotFound is
-// returned.
+// returned. ErrNotFound is not returned when the write is not acknowledged.
 func (c Collection) Update(selector, update interface{}) error {
-	merr, err := c.checkError(c.Conn.Update(c.Namespace, selector, update, nil))
-	if merr != nil && err == nil && !merr.Updated {
+	n, err := c.update(selector, update, &UpdateOptions{})
+	if err == nil && n == 0 && c.WriteConcern.acknowledged() {
 		err = ErrNotFound
 	}
 	return err
@@ -89,9 +168,10 @@ func (c Collection) Update(selector, update interface{}) error {
 
 // UpdateAll updates all documents matching selector with update. If no
 // matching documents are found, then mongo.ErrNotFound is returned.
+// ErrNotFound is not returned when the write is not acknowledged.
 func (c Collection) UpdateAll(selector interface{}, update interface{}) error {
-	merr, err := c.checkError(c.Conn.Update(c.Namespace, selector, update, updateAllOptions))
-	if merr != nil && err == nil && !merr.Updated {
+	n, err := c.update(selector, update, updateAllOptions)
+	if err == nil && n == 0 && c.WriteConcern.acknowledged() {
 		err = ErrNotFound
 	}
 	return err
@@ -100,20 +180,38 @@ func (c Collection) UpdateAll(selector interface{}, update interface{}) error {
 // Upsert updates the first document found by selector with update. If no
 // document is found, then the update is inserted instead.
 func (c Collection) Upsert(selector interface{}, update interface{}) error {
-	_, err := c.checkError(c.Conn.Update(c.Namespace, selector, update, upsertOptions))
+	_, err := c.update(selector, update, upsertOptions)
 	return err
 }
 
+// remove removes documents matching selector.
+func (c Collection) remove(selector interface{}, options *RemoveOptions) error {
+	if selector == nil {
+		selector = emptyDoc
+	}
+	if !writeCommands(c.Conn) {
+		_, err := c.checkError(c.conn().Remove(c.Namespace, selector, options))
+		return err
+	}
+	limit := 0
+	if options.Single {
+		limit = 1
+	}
+	r, err := c.runWrite(D{{"delete", c.Name()}, {"deletes", []interface{}{D{{"q", selector}, {"limit", limit}}}}}, nil)
+	if err != nil {
+		return err
+	}
+	return r.Err()
+}
+
 // RemoveFirst removes the first document found by selector.
 func (c Collection) RemoveFirst(selector interface{}) error {
-	_, err := c.checkError(c.Conn.Remove(c.Namespace, selector, removeFirstOptions))
-	return err
+	return c.remove(selector, removeFirstOptions)
 }
 
 // Remove removes all documents found by selector.
 func (c Collection) Remove(selector interface{}) error {
-	_, err := c.checkError(c.Conn.Remove(c.Namespace, selector, nil))
-	return err
+	return c.remove(selector, &RemoveOptions{})
 }
 
 // Find returns a query object for the given filter.
@@ -122,7 +220,7 @@ func (c Collection) Find(filter interface{}) *Query {
 		filter = emptyDoc
 	}
 	return &Query{
-		Conn:      c.Conn,
+		Conn:      c.conn(),
 		Namespace: c.Namespace,
 		Spec:      QuerySpec{Query: filter},
 	}
@@ -168,36 +266,128 @@ type IndexOptions struct {
 	// Do not index documents with missing key fields.
 	Sparse bool `bson:"sparse,omitempty"`
 
+	// Hide the index from the query planner.
+	Hidden bool `bson:"hidden,omitempty"`
+
+	// Remove documents from the collection this many seconds after the
+	// time in the indexed date field (TTL index).
+	ExpireAfterSeconds *int `bson:"expireAfterSeconds"`
+
+	// Only index documents that match this filter.
+	PartialFilterExpression interface{} `bson:"partialFilterExpression"`
+
+	// Collation for string comparisons in the index.
+	Collation *Collation `bson:"collation"`
+
+	// Text index options.
+	Weights          interface{} `bson:"weights"`
+	DefaultLanguage  string      `bson:"default_language,omitempty"`
+	LanguageOverride string      `bson:"language_override,omitempty"`
+	TextIndexVersion int         `bson:"textIndexVersion,omitempty"`
+
+	// 2dsphere index version.
+	SphereIndexVersion int `bson:"2dsphereIndexVersion,omitempty"`
+
+	// Fields included in or excluded from a wildcard index.
+	WildcardProjection interface{} `bson:"wildcardProjection"`
+
 	// Geospatial options
 	Min  interface{} `bson:"min"`
 	Max  interface{} `bson:"max"`
 	Bits int         `bson:"bits,omitempty"`
 }
 
-// CreateIndex creates an index on keys.
+// IndexSpec specifies an index. The IndexSpecs method returns IndexSpecs and
+// the CreateIndexes method creates indexes from IndexSpecs.
+type IndexSpec struct {
+	// Index keys specified by (key, direction) pairs or (key, index type)
+	// pairs.
+	Key D `bson:"key"`
+
+	// Index version.
+	Version int `bson:"v,omitempty"`
+
+	IndexOptions
+}
+
+// CreateIndex creates an index on keys. CreateIndex uses the createIndexes
+// command on servers that support write commands.
 //
 // More information: http://www.mongodb.org/display/DOCS/Indexes
 func (c Collection) CreateIndex(keys D, options *IndexOptions) error {
+	spec := IndexSpec{Key: keys}
+	if options != nil {
+		spec.IndexOptions = *options
+	}
+	if writeCommands(c.Conn) {
+		return c.CreateIndexes(spec)
+	}
+
 	index := struct {
 		Keys      D      `bson:"key"`
 		Namespace string `bson:"ns"`
 		IndexOptions
 	}{
-		Keys:      keys,
-		Namespace: c.Namespace,
-	}
-
-	if options != nil {
-		index.IndexOptions = *options
+		Keys:         keys,
+		Namespace:    c.Namespace,
+		IndexOptions: spec.IndexOptions,
 	}
 
 	if index.Name == "" {
 		index.Name = IndexName(keys)
 	}
 
-	if c.LastErrorCmd == nil {
-		c.LastErrorCmd = DefaultLastErrorCmd
+	return c.Db().C("system.indexes").Insert(&index)
+}
+
+// CreateIndexes creates indexes with the createIndexes command. Indexes
+// without a name are named with IndexName.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/createIndexes/
+func (c Collection) CreateIndexes(indexes ...IndexSpec) error {
+	specs := make([]IndexSpec, len(indexes))
+	for i, index := range indexes {
+		if index.Name == "" {
+			index.Name = IndexName(index.Key)
+		}
+		specs[i] = index
 	}
+	cmd := D{{"createIndexes", c.Name()}, {"indexes", specs}}
+	return c.Db().Run(withWriteConcern(cmd, c.WriteConcern), nil)
+}
 
-	return c.Db().C("system.indexes").Insert(&index)
+// DropIndex removes the index with name from the collection.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/dropIndexes/
+func (c Collection) DropIndex(name string) error {
+	cmd := D{{"dropIndexes", c.Name()}, {"index", name}}
+	return c.Db().Run(withWriteConcern(cmd, c.WriteConcern), nil)
+}
+
+// DropIndexes removes all indexes except the _id index from the collection.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/dropIndexes/
+func (c Collection) DropIndexes() error {
+	return c.DropIndex("*")
+}
+
+// IndexSpecs returns the specifications of the indexes on the collection.
+func (c Collection) IndexSpecs() ([]IndexSpec, error) {
+	cursor, err := c.ListIndexes()
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var indexes []IndexSpec
+	for cursor.HasNext() {
+		var index IndexSpec
+		if err := cursor.Next(&index); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	if err := cursor.Err(); err != nil && err != Done {
+		return nil, err
+	}
+	return indexes, nil
 }
@@ -15,6 +15,8 @@
 package mongo
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -91,3 +93,71 @@ func TestIndexName(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateIndexes(t *testing.T) {
+	var cmd M
+	c := newMsgConnection(func(m M, sequences map[string][][]byte) interface{} {
+		cmd = m
+		return M{"ok": 1}
+	})
+	defer c.Close()
+	coll := Collection{Conn: c, Namespace: "test.c"}
+
+	ttl := 3600
+	err := coll.CreateIndexes(
+		IndexSpec{Key: D{{"t", 1}}, IndexOptions: IndexOptions{ExpireAfterSeconds: &ttl}},
+		IndexSpec{Key: D{{"$**", 1}}, IndexOptions: IndexOptions{Name: "w", Hidden: true, WildcardProjection: M{"a": 1}}},
+		IndexSpec{Key: D{{"s", "text"}}, IndexOptions: IndexOptions{Weights: M{"s": 2}, DefaultLanguage: "english"}})
+	if err != nil {
+		t.Fatal("CreateIndexes", err)
+	}
+	expected := "map[$db:test createIndexes:c indexes:[" +
+		"map[expireAfterSeconds:3600 key:map[t:1] name:t_1] " +
+		"map[hidden:true key:map[$**:1] name:w wildcardProjection:map[a:1]] " +
+		"map[default_language:english key:map[s:text] name:s_text weights:map[s:2]]]]"
+	if s := fmt.Sprint(cmd); s != expected {
+		t.Errorf("CreateIndexes sent\n%s, want\n%s", s, expected)
+	}
+
+	if err := coll.CreateIndex(D{{"x", 1}}, &IndexOptions{Unique: true}); err != nil {
+		t.Fatal("CreateIndex", err)
+	}
+	if s := fmt.Sprint(cmd); s != "map[$db:test createIndexes:c indexes:[map[key:map[x:1] name:x_1 unique:true]]]" {
+		t.Errorf("CreateIndex sent %s", s)
+	}
+
+	if err := coll.DropIndexes(); err != nil {
+		t.Fatal("DropIndexes", err)
+	}
+	if s := fmt.Sprint(cmd); s != "map[$db:test dropIndexes:c index:*]" {
+		t.Errorf("DropIndexes sent %s", s)
+	}
+}
+
+func TestIndexSpecs(t *testing.T) {
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "test.c", "firstBatch": []D{
+			{{"v", 2}, {"key", D{{"_id", 1}}}, {"name", "_id_"}},
+			{{"v", 2}, {"key", D{{"b", 1}, {"a", -1}}}, {"name", "b_1_a_-1"}, {"expireAfterSeconds", 60.0},
+				{"partialFilterExpression", M{"a": M{"$gt": 1}}}},
+		}}}
+	})
+	defer c.Close()
+
+	indexes, err := Collection{Conn: c, Namespace: "test.c"}.IndexSpecs()
+	if err != nil {
+		t.Fatal("IndexSpecs", err)
+	}
+	ttl := 60
+	expected := []IndexSpec{
+		{Key: D{{"_id", 1}}, Version: 2, IndexOptions: IndexOptions{Name: "_id_"}},
+		{Key: D{{"b", 1}, {"a", -1}}, Version: 2, IndexOptions: IndexOptions{
+			Name:                    "b_1_a_-1",
+			ExpireAfterSeconds:      &ttl,
+			PartialFilterExpression: map[string]interface{}{"a": map[string]interface{}{"$gt": 1}},
+		}},
+	}
+	if !reflect.DeepEqual(indexes, expected) {
+		t.Errorf("IndexSpecs() = %+v, want %+v", indexes, expected)
+	}
+}
@@ -0,0 +1,484 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// DialConcurrent connects to the server at addr and returns a connection that
+// is safe for concurrent use by multiple goroutines. The connection multiplexes
+// requests from all goroutines over a single socket. A reader goroutine
+// dispatches each reply to the waiting request by request id and a writer
+// goroutine serializes outgoing messages.
+//
+// The server must support OP_MSG (MongoDB 3.6 and later). Cursors returned by
+// the connection can be used concurrently with other operations on the
+// connection, but each cursor must only be used by one goroutine at a time.
+// Exhaust cursors are not supported; the Exhaust option is ignored.
+//
+// Cancelling the context passed to a method of the connection abandons the
+// request without affecting other requests on the connection.
+func DialConcurrent(addr string) (Conn, error) {
+	return DialConcurrentWithOptions(addr, nil)
+}
+
+// DialConcurrentWithOptions connects to the server at addr using the specified
+// options and returns a connection that is safe for concurrent use. See
+// DialConcurrent and DialWithOptions for more information.
+func DialConcurrentWithOptions(addr string, options *DialOptions) (Conn, error) {
+	c, err := dial(addr, options)
+	if err != nil {
+		return nil, err
+	}
+	if !c.msg {
+		c.Close()
+		return nil, errors.New("mongo: server does not support OP_MSG")
+	}
+	return newConcurrentConn(c.conn, c.br, c.info, c.requestId), nil
+}
+
+type concurrentConn struct {
+	conn   net.Conn
+	info   *ServerInfo
+	writes chan []byte
+	closed chan struct{}
+
+	// If not nil, replyRead is called by the reader goroutine after a reply
+	// is removed from the pending requests and before the reply is
+	// delivered. Tests set replyRead before the connection is used.
+	replyRead func()
+
+	// The following fields are protected by mu.
+	mu        sync.Mutex
+	requestId uint32
+	pending   map[uint32]chan concurrentReply
+	err       error
+}
+
+type concurrentReply struct {
+	body []byte
+	err  error
+}
+
+type concurrentCursor struct {
+	conn *concurrentConn
+	spec cursor // namespace, cursor id, limit, batch size, flags and count
+	docs [][]byte
+	err  error
+}
+
+func newConcurrentConn(conn net.Conn, br *bufio.Reader, info *ServerInfo, requestId uint32) *concurrentConn {
+	c := &concurrentConn{
+		conn:      conn,
+		info:      info,
+		writes:    make(chan []byte, 64),
+		closed:    make(chan struct{}),
+		requestId: requestId,
+		pending:   make(map[uint32]chan concurrentReply),
+	}
+	go c.readLoop(br)
+	go c.writeLoop()
+	return c
+}
+
+// fatal closes the connection with permanent error err and fails all pending
+// requests.
+func (c *concurrentConn) fatal(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = err
+	pending := c.pending
+	c.pending = nil
+	close(c.closed)
+	c.mu.Unlock()
+
+	c.conn.Close()
+	for _, ch := range pending {
+		ch <- concurrentReply{err: err}
+	}
+}
+
+// Close closes the connection to the server.
+func (c *concurrentConn) Close() error {
+	c.fatal(errors.New("mongo: connection closed"))
+	return nil
+}
+
+func (c *concurrentConn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *concurrentConn) ServerInfo() *ServerInfo {
+	return c.info
+}
+
+// writeLoop writes queued messages to the socket. The loop flushes the socket
+// buffer when there are no more messages in the queue.
+func (c *concurrentConn) writeLoop() {
+	bw := bufio.NewWriter(c.conn)
+	for {
+		select {
+		case <-c.closed:
+			return
+		case p := <-c.writes:
+			if _, err := bw.Write(p); err != nil {
+				c.fatal(err)
+				return
+			}
+			if len(c.writes) == 0 {
+				if err := bw.Flush(); err != nil {
+					c.fatal(err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// readLoop reads replies from the socket and delivers them to the pending
+// requests.
+func (c *concurrentConn) readLoop(br *bufio.Reader) {
+	var h [16]byte
+	for {
+		if _, err := io.ReadFull(br, h[:]); err != nil {
+			c.fatal(err)
+			return
+		}
+		messageLen := int(wire.Uint32(h[0:4]))
+		responseTo := wire.Uint32(h[8:12])
+		if opCode := int32(wire.Uint32(h[12:16])); opCode != opMsg {
+			c.fatal(errors.New("mongo: unknown response opcode " + strconv.Itoa(int(opCode))))
+			return
+		}
+		if messageLen < 21 {
+			c.fatal(errors.New("mongo: OP_MSG message too short"))
+			return
+		}
+		m := make([]byte, messageLen)
+		copy(m, h[:])
+		if _, err := io.ReadFull(br, m[16:]); err != nil {
+			c.fatal(err)
+			return
+		}
+		msg, err := parseMsg(m)
+		if err != nil {
+			c.fatal(err)
+			return
+		}
+
+		c.mu.Lock()
+		ch := c.pending[responseTo]
+		delete(c.pending, responseTo)
+		c.mu.Unlock()
+
+		if c.replyRead != nil {
+			c.replyRead()
+		}
+		if ch != nil {
+			ch <- concurrentReply{body: msg.body}
+		} else {
+			// The request was abandoned.
+			go c.killReplyCursor(msg.body)
+		}
+	}
+}
+
+// send queues the message p for writing.
+func (c *concurrentConn) send(ctx context.Context, p []byte) error {
+	wire.PutUint32(p[0:4], uint32(len(p)))
+	select {
+	case c.writes <- p:
+		return nil
+	case <-c.closed:
+		return c.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// roundTrip runs command cmd on database dbname and returns the body of the
+// reply.
+func (c *concurrentConn) roundTrip(ctx context.Context, dbname string, cmd interface{}, sequences ...msgSequence) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan concurrentReply, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.requestId += 1
+	requestId := c.requestId
+	c.pending[requestId] = ch
+	c.mu.Unlock()
+
+	p, err := appendMsg(nil, requestId, 0, dbname, cmd, sequences...)
+	if err == nil {
+		err = c.send(ctx, p)
+	}
+	if err != nil {
+		c.abandon(requestId, ch)
+		return nil, err
+	}
+
+	select {
+	case reply := <-ch:
+		return reply.body, reply.err
+	case <-ctx.Done():
+		c.abandon(requestId, ch)
+		return nil, ctx.Err()
+	}
+}
+
+// abandon removes a pending request. If the request was already removed by
+// the reader goroutine or by fatal, then abandon waits for the reply to be
+// delivered and kills the cursor in the reply.
+func (c *concurrentConn) abandon(requestId uint32, ch chan concurrentReply) {
+	c.mu.Lock()
+	_, removed := c.pending[requestId]
+	delete(c.pending, requestId)
+	c.mu.Unlock()
+	if removed {
+		return
+	}
+	reply := <-ch
+	if reply.err == nil {
+		go c.killReplyCursor(reply.body)
+	}
+}
+
+func (c *concurrentConn) killReplyCursor(body []byte) {
+	var reply cursorReply
+	if Decode(body, &reply) == nil && reply.Cursor.Id != 0 {
+		c.killCursors(reply.Cursor.Namespace, uint64(reply.Cursor.Id))
+	}
+}
+
+func (c *concurrentConn) killCursors(namespace string, cursorIds ...uint64) error {
+	dbname, cmd := killCursorsCommand(namespace, cursorIds)
+	c.mu.Lock()
+	c.requestId += 1
+	requestId := c.requestId
+	c.mu.Unlock()
+	p, err := appendMsg(nil, requestId, msgMoreToCome, dbname, cmd)
+	if err != nil {
+		return err
+	}
+	return c.send(context.Background(), p)
+}
+
+func (c *concurrentConn) write(ctx context.Context, dbname string, cmd D, documents msgSequence) error {
+	body, err := c.roundTrip(ctx, dbname, cmd, documents)
+	if err != nil {
+		return err
+	}
+	var reply writeReply
+	if err := Decode(body, &reply); err != nil {
+		return err
+	}
+	return reply.Err()
+}
+
+func (c *concurrentConn) Update(namespace string, selector, update interface{}, options *UpdateOptions) error {
+	return c.UpdateContext(context.Background(), namespace, selector, update, options)
+}
+
+func (c *concurrentConn) UpdateContext(ctx context.Context, namespace string, selector, update interface{}, options *UpdateOptions) error {
+	if selector == nil {
+		selector = emptyDoc
+	}
+	dbname, cmd, updates := updateCommand(namespace, selector, update, options)
+	return c.write(ctx, dbname, cmd, updates)
+}
+
+func (c *concurrentConn) Insert(namespace string, options *InsertOptions, documents ...interface{}) error {
+	return c.InsertContext(context.Background(), namespace, options, documents...)
+}
+
+func (c *concurrentConn) InsertContext(ctx context.Context, namespace string, options *InsertOptions, documents ...interface{}) error {
+	if len(documents) == 0 {
+		return errors.New("mongo: insert with no documents")
+	}
+	dbname, cmd, docs := insertCommand(namespace, options, documents)
+	return c.write(ctx, dbname, cmd, docs)
+}
+
+func (c *concurrentConn) Remove(namespace string, selector interface{}, options *RemoveOptions) error {
+	return c.RemoveContext(context.Background(), namespace, selector, options)
+}
+
+func (c *concurrentConn) RemoveContext(ctx context.Context, namespace string, selector interface{}, options *RemoveOptions) error {
+	if selector == nil {
+		selector = emptyDoc
+	}
+	dbname, cmd, deletes := removeCommand(namespace, selector, options)
+	return c.write(ctx, dbname, cmd, deletes)
+}
+
+func (c *concurrentConn) Find(namespace string, query interface{}, options *FindOptions) (Cursor, error) {
+	return c.FindContext(context.Background(), namespace, query, options)
+}
+
+func (c *concurrentConn) FindContext(ctx context.Context, namespace string, query interface{}, options *FindOptions) (Cursor, error) {
+	if query == nil {
+		query = emptyDoc
+	}
+	r := &concurrentCursor{conn: c, spec: cursor{namespace: namespace}}
+	fields, skip := r.spec.setOptions(options)
+	dbname, cname := SplitNamespace(namespace)
+	var cmd interface{}
+	if cname == "$cmd" {
+		r.spec.command = true
+		cmd = query
+	} else {
+		cmd = r.spec.findCommand(cname, query, fields, skip)
+	}
+	body, err := c.roundTrip(ctx, dbname, cmd)
+	if err != nil {
+		return nil, err
+	}
+	r.setReply(body)
+	return r, nil
+}
+
+// setReply adds the documents in reply body to the cursor.
+func (r *concurrentCursor) setReply(body []byte) {
+	if r.spec.command {
+		r.docs = append(r.docs, body)
+		return
+	}
+	var reply cursorReply
+	if err := Decode(body, &reply); err != nil {
+		r.fatal(err)
+		return
+	}
+	if err := reply.Err(); err != nil {
+		r.fatal(err)
+		return
+	}
+	r.spec.cursorId = uint64(reply.Cursor.Id)
+	for _, bd := range reply.batch() {
+		r.docs = append(r.docs, bd.Data)
+	}
+}
+
+func (r *concurrentCursor) Close() error {
+	if r.err != nil {
+		return nil
+	}
+	if r.spec.cursorId != 0 {
+		r.conn.killCursors(r.spec.namespace, r.spec.cursorId)
+		r.spec.cursorId = 0
+	}
+	r.docs = nil
+	r.err = errors.New("mongo: cursor closed")
+	return nil
+}
+
+func (r *concurrentCursor) fatal(err error) error {
+	if r.err == nil {
+		r.Close()
+		r.err = err
+	}
+	return err
+}
+
+func (r *concurrentCursor) Err() error {
+	return r.err
+}
+
+func (r *concurrentCursor) HasNext() bool {
+	return r.HasNextContext(context.Background())
+}
+
+func (r *concurrentCursor) HasNextContext(ctx context.Context) bool {
+	if r.err != nil {
+		return r.err != Done
+	}
+	if len(r.docs) > 0 || ctx.Err() != nil {
+		return true
+	}
+	if r.spec.cursorId == 0 {
+		r.fatal(Done)
+		return false
+	}
+
+	dbname, cmd := r.spec.getMoreCommand()
+	body, err := r.conn.roundTrip(ctx, dbname, cmd)
+	if err != nil {
+		// The position of the cursor on the server is not known after an
+		// abandoned getMore.
+		r.fatal(err)
+		return true
+	}
+	r.setReply(body)
+
+	switch {
+	case r.err != nil:
+		return r.err != Done
+	case len(r.docs) > 0:
+		return true
+	case r.spec.cursorId == 0:
+		r.fatal(Done)
+		return false
+	}
+
+	// Tailable cursor case
+	return false
+}
+
+func (r *concurrentCursor) Next(value interface{}) error {
+	return r.NextContext(context.Background(), value)
+}
+
+func (r *concurrentCursor) NextContext(ctx context.Context, value interface{}) error {
+	if !r.HasNextContext(ctx) {
+		return Done
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.docs) == 0 {
+		return ctx.Err()
+	}
+
+	p := r.docs[0]
+	r.docs[0] = nil
+	r.docs = r.docs[1:]
+
+	err := Decode(p, value)
+
+	r.spec.count += 1
+	if r.spec.limit > 0 && r.spec.count >= r.spec.limit {
+		r.fatal(Done)
+	}
+
+	return err
+}
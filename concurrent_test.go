@@ -0,0 +1,175 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newConcurrentTestConn returns a concurrent connection to a fake server
+// implemented by handler.
+func newConcurrentTestConn(handler msgHandler) *concurrentConn {
+	client, server := net.Pipe()
+	go serveMsg(server, handler)
+	return newConcurrentConn(client, bufio.NewReader(client), &ServerInfo{}, 0)
+}
+
+func TestConcurrentConn(t *testing.T) {
+	var mu sync.Mutex
+	inserted := 0
+	c := newConcurrentTestConn(func(cmd M, sequences map[string][][]byte) interface{} {
+		switch {
+		case cmd["find"] != nil:
+			return M{"ok": 1, "cursor": M{"id": int64(7), "ns": "db.coll", "firstBatch": A{M{"x": 0}}}}
+		case cmd["getMore"] != nil:
+			return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "db.coll", "nextBatch": A{M{"x": 1}}}}
+		case cmd["insert"] != nil:
+			mu.Lock()
+			inserted += len(sequences["documents"])
+			mu.Unlock()
+			return M{"ok": 1, "n": len(sequences["documents"])}
+		}
+		return M{"ok": 0, "errmsg": "unexpected command"}
+	})
+	defer c.Close()
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- c.Insert("db.coll", nil, M{"x": 0}, M{"x": 1})
+		}()
+		go func() {
+			defer wg.Done()
+			r, err := c.Find("db.coll", nil, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer r.Close()
+			count := 0
+			for r.HasNext() {
+				var m M
+				if err := r.Next(&m); err != nil {
+					errs <- err
+					return
+				}
+				if m["x"] != count {
+					t.Errorf("x=%v, want %d", m["x"], count)
+				}
+				count += 1
+			}
+			if count != 2 {
+				t.Errorf("count=%d, want 2", count)
+			}
+			errs <- r.Err()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil && err != Done {
+			t.Error(err)
+		}
+	}
+	if inserted != 2*n {
+		t.Errorf("inserted=%d, want %d", inserted, 2*n)
+	}
+}
+
+func TestConcurrentConnCancel(t *testing.T) {
+	block := make(chan struct{})
+	killed := make(chan struct{})
+	c := newConcurrentTestConn(func(cmd M, sequences map[string][][]byte) interface{} {
+		switch {
+		case cmd["find"] != nil && cmd["find"] == "slow":
+			<-block
+			return M{"ok": 1, "cursor": M{"id": int64(5), "ns": "db.slow", "firstBatch": A{}}}
+		case cmd["find"] != nil:
+			return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "db.coll", "firstBatch": A{M{"x": 0}}}}
+		case cmd["killCursors"] != nil:
+			close(killed)
+		}
+		return M{"ok": 0, "errmsg": "unexpected command"}
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.FindContext(ctx, "db.slow", nil, nil); err != context.DeadlineExceeded {
+		t.Fatalf("find returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(block)
+
+	select {
+	case <-killed:
+	case <-time.After(time.Second):
+		t.Error("cursor from abandoned request not killed")
+	}
+
+	r, err := c.Find("db.coll", nil, nil)
+	if err != nil {
+		t.Fatal("find", err)
+	}
+	var m M
+	if err := r.Next(&m); err != nil {
+		t.Fatal("next", err)
+	}
+	if err := c.Err(); err != nil {
+		t.Fatal("connection error", err)
+	}
+}
+
+func TestConcurrentConnCancelAfterRead(t *testing.T) {
+	killed := make(chan struct{})
+	c := newConcurrentTestConn(func(cmd M, sequences map[string][][]byte) interface{} {
+		switch {
+		case cmd["find"] != nil:
+			return M{"ok": 1, "cursor": M{"id": int64(5), "ns": "db.coll", "firstBatch": A{}}}
+		case cmd["killCursors"] != nil:
+			close(killed)
+		}
+		return M{"ok": 0, "errmsg": "unexpected command"}
+	})
+	defer c.Close()
+
+	// Cancel the request after the reader goroutine removes the request from
+	// the pending requests and before the reply is delivered.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.replyRead = func() {
+		c.replyRead = nil
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := c.FindContext(ctx, "db.coll", nil, nil); err != context.Canceled {
+		t.Fatalf("find returned %v, want %v", err, context.Canceled)
+	}
+
+	select {
+	case <-killed:
+	case <-time.After(time.Second):
+		t.Error("cursor from abandoned request not killed")
+	}
+}
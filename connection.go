@@ -16,9 +16,12 @@ package mongo
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"io"
 	"net"
+	"runtime"
 	"strconv"
 	"strings"
 )
@@ -41,6 +44,7 @@ const (
 type connection struct {
 	conn          net.Conn
 	addr          string
+	options       DialOptions
 	requestId     uint32
 	cursors       map[uint32]*cursor
 	err           error
@@ -49,6 +53,9 @@ type connection struct {
 	responseCount int
 	cursor        *cursor
 	br            *bufio.Reader
+	msg           bool // send requests with OP_MSG
+	info          *ServerInfo
+	ctx           context.Context // context for current operation
 }
 
 type cursor struct {
@@ -61,23 +68,90 @@ type cursor struct {
 	count     int
 	docs      [][]byte
 	flags     int
+	command   bool // reply body is the result (OP_MSG only)
+	readPref  *ReadPreference
 	err       error
 }
 
-// Dial connects to server at addr.
+// Dial connects to server at addr. After connecting, Dial asks the server for
+// its capabilities. Use the ServerInfo method on the returned connection to
+// get the capabilities. Requests are sent using OP_MSG if the server supports it
+// (MongoDB 3.6 and later) and the legacy opcodes otherwise. When using OP_MSG,
+// the Update, Insert and Remove methods wait for the server to acknowledge the
+// write and return the first write error reported by the server.
 func Dial(addr string) (Conn, error) {
+	return dial(addr, nil)
+}
+
+// DialOptions specifies options for DialWithOptions.
+type DialOptions struct {
+	// Dialer opens the network connection to the server. Set fields in the
+	// dialer to specify timeouts, keep-alive and the local address. If nil,
+	// then the zero value of net.Dialer is used.
+	Dialer *net.Dialer
+
+	// TLS configuration. If not nil, the connection uses TLS. If ServerName
+	// is empty, then the host from the server address is used.
+	TLSConfig *tls.Config
+
+	// Client certificates presented to the server. If set, the connection
+	// uses TLS with these certificates added to a copy of TLSConfig.
+	Certificates []tls.Certificate
+
+	// If not nil, the authenticator is called after the connection is
+	// opened.
+	Authenticator Authenticator
+}
+
+// DialWithOptions connects to the server at addr using the specified options.
+// See Dial for more information. The options argument can be nil.
+func DialWithOptions(addr string, options *DialOptions) (Conn, error) {
+	return dial(addr, options)
+}
+
+// dial opens a network connection to the server at addr.
+func (o *DialOptions) dial(addr string) (net.Conn, error) {
+	dialer := o.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	config := o.TLSConfig
+	if len(o.Certificates) > 0 {
+		if config == nil {
+			config = &tls.Config{}
+		} else {
+			config = config.Clone()
+		}
+		config.Certificates = append(config.Certificates[:len(config.Certificates):len(config.Certificates)], o.Certificates...)
+	}
+	if config == nil {
+		return dialer.Dial("tcp", addr)
+	}
+	return (&tls.Dialer{NetDialer: dialer, Config: config}).Dial("tcp", addr)
+}
+
+// hostPort returns addr with the default port added if addr does not include a
+// port.
+func hostPort(addr string) string {
 	if strings.LastIndex(addr, ":") <= strings.LastIndex(addr, "]") {
 		addr = addr + ":27017"
 	}
+	return addr
+}
+
+func dial(addr string, options *DialOptions) (*connection, error) {
 	c := connection{
-		addr:    addr,
+		addr:    hostPort(addr),
 		cursors: make(map[uint32]*cursor),
 	}
+	if options != nil {
+		c.options = *options
+	}
 	return &c, c.connect()
 }
 
 func (c *connection) connect() error {
-	conn, err := net.Dial("tcp", c.addr)
+	conn, err := c.options.dial(c.addr)
 	if err != nil {
 		return err
 	}
@@ -86,6 +160,77 @@ func (c *connection) connect() error {
 	}
 	c.conn = conn
 	c.br = bufio.NewReader(conn)
+	c.msg = false
+	c.info = nil
+	if err := c.handshake(); err != nil {
+		return err
+	}
+	if c.options.Authenticator != nil {
+		if err := c.options.Authenticator.Authenticate(c); err != nil {
+			return c.fatal(err)
+		}
+	}
+	return nil
+}
+
+// Default limits for servers that do not report limits in the handshake.
+const (
+	defaultMaxBSONObjectSize   = 16 * 1024 * 1024
+	defaultMaxMessageSizeBytes = 48000000
+	defaultMaxWriteBatchSize   = 1000
+)
+
+// handshakeCmd is the command sent to the server when a connection is opened.
+var handshakeCmd = D{
+	{"isMaster", 1},
+	{"helloOk", true},
+	{"client", D{
+		{"driver", D{{"name", "go-mongo"}, {"version", "0"}}},
+		{"os", D{{"type", runtime.GOOS}, {"architecture", runtime.GOARCH}}},
+		{"platform", runtime.Version()}}},
+}
+
+// handshake asks the server for its capabilities and selects the protocol
+// used for subsequent requests. The handshake is always sent with OP_QUERY.
+func (c *connection) handshake() error {
+	var r struct {
+		CommandResponse
+		ServerInfo
+	}
+	if err := runInternal(c, "admin", handshakeCmd, runFindOptions, &r); err != nil {
+		return c.fatal(err)
+	}
+	if err := r.CommandResponse.Err(); err != nil {
+		return c.fatal(err)
+	}
+	info := &r.ServerInfo
+	info.IsMongos = info.Msg == "isdbgrid"
+	if info.MaxBSONObjectSize == 0 {
+		info.MaxBSONObjectSize = defaultMaxBSONObjectSize
+	}
+	if info.MaxMessageSizeBytes == 0 {
+		info.MaxMessageSizeBytes = defaultMaxMessageSizeBytes
+	}
+	if info.MaxWriteBatchSize == 0 {
+		info.MaxWriteBatchSize = defaultMaxWriteBatchSize
+	}
+	c.info = info
+	c.msg = info.MaxWireVersion >= msgWireVersion
+	return nil
+}
+
+// ServerInfo returns the information collected from the server when the
+// connection was opened. The application must not modify the returned value.
+func (c *connection) ServerInfo() *ServerInfo {
+	return c.info
+}
+
+// serverInfo returns information about the server at the other end of conn or
+// nil if the information is not available.
+func serverInfo(conn Conn) *ServerInfo {
+	if c, ok := conn.(ServerInfoConn); ok {
+		return c.ServerInfo()
+	}
 	return nil
 }
 
@@ -95,6 +240,9 @@ func (c *connection) nextId() uint32 {
 }
 
 func (c *connection) fatal(err error) error {
+	if c.ctx != nil {
+		err = c.contextError(err)
+	}
 	if c.err == nil {
 		c.Close()
 		c.err = err
@@ -120,6 +268,42 @@ func (c *connection) Err() error {
 	return c.err
 }
 
+// flags returns the OP_UPDATE flags for the options.
+func (options *UpdateOptions) flags() int {
+	flags := 0
+	if options != nil {
+		if options.Upsert {
+			flags |= updateUpsert
+		}
+		if options.Multi {
+			flags |= updateMulti
+		}
+	}
+	return flags
+}
+
+// flags returns the OP_INSERT flags for the options.
+func (options *InsertOptions) flags() int {
+	flags := 0
+	if options != nil {
+		if options.ContinueOnError {
+			flags |= insertContinueOnError
+		}
+	}
+	return flags
+}
+
+// flags returns the OP_DELETE flags for the options.
+func (options *RemoveOptions) flags() int {
+	flags := 0
+	if options != nil {
+		if options.Single {
+			flags |= removeSingle
+		}
+	}
+	return flags
+}
+
 // send sets the message length and writes the message to the socket.
 func (c *connection) send(msg []byte) error {
 	if c.err != nil {
@@ -137,14 +321,10 @@ func (c *connection) Update(namespace string, selector, update interface{}, opti
 	if selector == nil {
 		selector = emptyDoc
 	}
-	flags := 0
-	if options != nil {
-		if options.Upsert {
-			flags |= updateUpsert
-		}
-		if options.Multi {
-			flags |= updateMulti
-		}
+	flags := options.flags()
+
+	if c.msg {
+		return c.write(updateCommand(namespace, selector, update, options))
 	}
 
 	b := buffer(c.buf[:0])
@@ -170,11 +350,10 @@ func (c *connection) Insert(namespace string, options *InsertOptions, documents
 	if len(documents) == 0 {
 		return errors.New("mongo: insert with no documents")
 	}
-	flags := 0
-	if options != nil {
-		if options.ContinueOnError {
-			flags |= insertContinueOnError
-		}
+	flags := options.flags()
+
+	if c.msg {
+		return c.write(insertCommand(namespace, options, documents))
 	}
 	b := buffer(c.buf[:0])
 	b.Next(4)                    // placeholder for message length
@@ -196,11 +375,10 @@ func (c *connection) Remove(namespace string, selector interface{}, options *Rem
 	if selector == nil {
 		selector = emptyDoc
 	}
-	flags := 0
-	if options != nil {
-		if options.Single {
-			flags |= removeSingle
-		}
+	flags := options.flags()
+
+	if c.msg {
+		return c.write(removeCommand(namespace, selector, options))
 	}
 	b := buffer(c.buf[:0])
 	b.Next(4)                    // placeholder for message length
@@ -228,37 +406,26 @@ func (c *connection) Find(namespace string, query interface{}, options *FindOpti
 		query = emptyDoc
 	}
 
-	var fields interface{}
-	var skip int
-	if options != nil {
-		skip = options.Skip
-		fields = options.Fields
-		r.limit = options.Limit
-		r.batchSize = options.BatchSize
-		if r.batchSize == 1 {
-			// Server handles numberToReturn == 1 as hard limit. Change value
-			// to two to avoid having batch size set hard limit.
-			r.batchSize = 2
-		}
-		if options.Tailable {
-			r.flags |= queryTailable
-			r.limit = 0
-		}
-		if options.SlaveOk {
-			r.flags |= querySlaveOk
-		}
-		if options.NoCursorTimeout {
-			r.flags |= queryNoCursorTimeout
-		}
-		if options.AwaitData {
-			r.flags |= queryAwaitData
+	fields, skip := r.setOptions(options)
+
+	if c.msg {
+		dbname, cname := SplitNamespace(namespace)
+		var cmd interface{}
+		if cname == "$cmd" {
+			r.command = true
+			cmd = query
+		} else {
+			cmd = r.findCommand(cname, query, fields, skip)
 		}
-		if options.Exhaust {
-			r.flags |= queryExhaust
+		b, err := appendMsg(buffer(c.buf[:0]), r.requestId, 0, dbname, cmd)
+		if err != nil {
+			return nil, err
 		}
-		if options.PartialResults {
-			r.flags |= queryPartialResults
+		if err := c.send(b); err != nil {
+			return nil, err
 		}
+		c.cursors[r.requestId] = &r
+		return &r, nil
 	}
 
 	b := buffer(c.buf[:0])
@@ -289,8 +456,66 @@ func (c *connection) Find(namespace string, query interface{}, options *FindOpti
 	return &r, nil
 }
 
+// setOptions sets the cursor limit, batch size and flags from options. The
+// function returns the options that are not stored in the cursor.
+func (r *cursor) setOptions(options *FindOptions) (fields interface{}, skip int) {
+	if options == nil {
+		return nil, 0
+	}
+	skip = options.Skip
+	fields = options.Fields
+	r.limit = options.Limit
+	r.batchSize = options.BatchSize
+	if r.batchSize == 1 {
+		// Server handles numberToReturn == 1 as hard limit. Change value
+		// to two to avoid having batch size set hard limit.
+		r.batchSize = 2
+	}
+	if options.Tailable {
+		r.flags |= queryTailable
+		r.limit = 0
+	}
+	if options.SlaveOk {
+		r.flags |= querySlaveOk
+	}
+	if options.ReadPreference != nil && options.ReadPreference.Mode != ReadPrimary {
+		r.flags |= querySlaveOk
+		r.readPref = options.ReadPreference
+	}
+	if options.NoCursorTimeout {
+		r.flags |= queryNoCursorTimeout
+	}
+	if options.AwaitData {
+		r.flags |= queryAwaitData
+	}
+	if options.Exhaust {
+		r.flags |= queryExhaust
+	}
+	if options.PartialResults {
+		r.flags |= queryPartialResults
+	}
+	return fields, skip
+}
+
 func (c *connection) getMore(r *cursor) error {
 	requestId := c.nextId()
+	if c.msg {
+		dbname, cmd := r.getMoreCommand()
+		var flags uint32
+		if r.flags&queryExhaust != 0 {
+			flags |= msgExhaustAllowed
+		}
+		b, err := appendMsg(buffer(c.buf[:0]), requestId, flags, dbname, cmd)
+		if err != nil {
+			return err
+		}
+		if err := c.send(b); err != nil {
+			return err
+		}
+		r.requestId = requestId
+		c.cursors[requestId] = r
+		return nil
+	}
 	b := buffer(c.buf[:0])
 	b.Next(4)                   // placeholder for message length
 	b.WriteUint32(requestId)    // requestId
@@ -308,7 +533,15 @@ func (c *connection) getMore(r *cursor) error {
 	return nil
 }
 
-func (c *connection) killCursors(cursorIds ...uint64) error {
+func (c *connection) killCursors(namespace string, cursorIds ...uint64) error {
+	if c.msg {
+		dbname, cmd := killCursorsCommand(namespace, cursorIds)
+		b, err := appendMsg(buffer(c.buf[:0]), c.nextId(), msgMoreToCome, dbname, cmd)
+		if err != nil {
+			return err
+		}
+		return c.send(b)
+	}
 	b := buffer(c.buf[:0])
 	b.Next(4)                             // placeholder for message length
 	b.WriteUint32(c.nextId())             // requestId
@@ -322,6 +555,31 @@ func (c *connection) killCursors(cursorIds ...uint64) error {
 	return c.send(b)
 }
 
+// write runs the write command cmd on database dbname and waits for the
+// server to acknowledge the write.
+func (c *connection) write(dbname string, cmd D, documents msgSequence) error {
+	r := cursor{
+		conn:      c,
+		namespace: dbname + ".$cmd",
+		requestId: c.nextId(),
+		command:   true,
+	}
+	b, err := appendMsg(buffer(c.buf[:0]), r.requestId, 0, dbname, cmd, documents)
+	if err != nil {
+		return err
+	}
+	if err := c.send(b); err != nil {
+		return err
+	}
+	c.cursors[r.requestId] = &r
+	defer r.Close()
+	var reply writeReply
+	if err := r.Next(&reply); err != nil {
+		return err
+	}
+	return reply.Err()
+}
+
 // readDoc reads a single document from the connection.
 func (c *connection) readDoc(alloc bool) ([]byte, error) {
 	if c.responseLen < 4 {
@@ -394,29 +652,39 @@ func (c *connection) receive() error {
 		r.docs = append(r.docs, p)
 	}
 
-	// Read response message header.
-	if _, err := io.ReadFull(c.br, c.buf[:36]); err != nil {
+	// Read standard message header.
+	if _, err := io.ReadFull(c.br, c.buf[:16]); err != nil {
 		return c.fatal(err)
 	}
 
-	c.responseLen = int(wire.Uint32(c.buf[0:4]))
+	messageLen := int(wire.Uint32(c.buf[0:4]))
 	requestId := wire.Uint32(c.buf[4:8])
 	responseTo := wire.Uint32(c.buf[8:12])
 	opCode := int32(wire.Uint32(c.buf[12:16]))
+
+	switch opCode {
+	case opReply:
+	case opMsg:
+		return c.receiveMsg(messageLen, requestId, responseTo)
+	default:
+		return c.fatal(errors.New("mongo: unknown response opcode " + strconv.Itoa(int(opCode))))
+	}
+
+	// Read remainder of OP_REPLY header.
+	if _, err := io.ReadFull(c.br, c.buf[16:36]); err != nil {
+		return c.fatal(err)
+	}
+
 	flags := wire.Uint32(c.buf[16:20])
 	cursorId := wire.Uint64(c.buf[20:28])
 	//startingFrom := int32(wire.Uint32(c.buf[28:32]))
 	c.responseCount = int(wire.Uint32(c.buf[32:36]))
-	c.responseLen -= 36
-
-	if opCode != 1 {
-		return c.fatal(errors.New("mongo: unknown response opcode " + strconv.Itoa(int(opCode))))
-	}
+	c.responseLen = messageLen - 36
 
 	r := c.cursors[responseTo]
 	if r == nil {
 		if cursorId != 0 {
-			if err := c.killCursors(cursorId); err != nil {
+			if err := c.killCursors("", cursorId); err != nil {
 				return err
 			}
 		}
@@ -468,6 +736,61 @@ func (c *connection) receive() error {
 	return c.err
 }
 
+// receiveMsg reads the remainder of an OP_MSG message and delivers the
+// documents in the message to the appropriate cursor.
+func (c *connection) receiveMsg(messageLen int, requestId, responseTo uint32) error {
+	if messageLen < 21 {
+		return c.fatal(errors.New("mongo: OP_MSG message too short"))
+	}
+	m := make([]byte, messageLen)
+	copy(m, c.buf[:16])
+	if _, err := io.ReadFull(c.br, m[16:]); err != nil {
+		return c.fatal(err)
+	}
+	msg, err := parseMsg(m)
+	if err != nil {
+		return c.fatal(err)
+	}
+
+	r := c.cursors[responseTo]
+	if r == nil {
+		var reply cursorReply
+		if Decode(msg.body, &reply) == nil && reply.Cursor.Id != 0 {
+			if err := c.killCursors(reply.Cursor.Namespace, uint64(reply.Cursor.Id)); err != nil {
+				return err
+			}
+		}
+		return c.err
+	}
+
+	delete(c.cursors, responseTo)
+	r.requestId = 0
+	if msg.flags&msgMoreToCome != 0 {
+		r.requestId = requestId
+		c.cursors[requestId] = r
+	}
+
+	if r.command {
+		r.docs = append(r.docs, msg.body)
+		return c.err
+	}
+
+	var reply cursorReply
+	if err := Decode(msg.body, &reply); err != nil {
+		r.fatal(err)
+		return c.err
+	}
+	if err := reply.Err(); err != nil {
+		r.fatal(err)
+		return c.err
+	}
+	r.cursorId = uint64(reply.Cursor.Id)
+	for _, bd := range reply.batch() {
+		r.docs = append(r.docs, bd.Data)
+	}
+	return c.err
+}
+
 func (r *cursor) numberToReturn() uint32 {
 	batchSize := r.batchSize
 	if batchSize < 0 {
@@ -506,7 +829,7 @@ func (r *cursor) Close() error {
 		return nil
 	}
 	if r.cursorId != 0 {
-		r.conn.killCursors(r.cursorId)
+		r.conn.killCursors(r.namespace, r.cursorId)
 	}
 	if r.conn.cursor == r {
 		r.conn.skipDocs()
@@ -566,7 +889,7 @@ func (r *cursor) HasNext() bool {
 	switch {
 	case r.err != nil:
 		return r.err != Done
-	case r.conn.cursor == r:
+	case len(r.docs) > 0 || r.conn.cursor == r:
 		return true
 	case r.cursorId == 0:
 		r.fatal(Done)
@@ -21,13 +21,13 @@ func dialAndDrop(t *testing.T, dbname, collectionName string) Collection {
 	if err != nil {
 		t.Fatal("dial", err)
 	}
-	db := Database{c, dbname, DefaultLastErrorCmd}
-	err = db.Run(D{{"drop", collectionName}}, nil)
+	coll := Database{Conn: c, Name: dbname}.C(collectionName)
+	err = coll.Drop()
 	if err != nil && err.Error() != "ns not found" {
-		db.Conn.Close()
+		c.Close()
 		t.Fatal("drop", err)
 	}
-	return db.C(collectionName)
+	return coll
 }
 
 var findOptionsTests = []struct {
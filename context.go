@@ -0,0 +1,186 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// aLongTimeAgo is a deadline used to abort pending network operations.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// withContext runs fn with the connection deadline set from ctx. If ctx is
+// done while fn is running, then the pending network operation is aborted.
+// Network errors reported by fn while ctx is active are converted to the
+// context's error by the fatal method.
+func (c *connection) withContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	conn := c.conn
+	if conn == nil {
+		return fn()
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	aborted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(aLongTimeAgo)
+		close(aborted)
+	})
+	c.ctx = ctx
+	err := fn()
+	c.ctx = nil
+	if !stop() {
+		<-aborted
+	}
+	if c.err == nil {
+		conn.SetDeadline(time.Time{})
+	}
+	return err
+}
+
+// contextError returns the error to record for network error err when the
+// current operation is bound to a context.
+func (c *connection) contextError(err error) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		if _, ok := c.ctx.Deadline(); ok {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
+
+func (c *connection) UpdateContext(ctx context.Context, namespace string, selector, update interface{}, options *UpdateOptions) error {
+	return c.withContext(ctx, func() error {
+		return c.Update(namespace, selector, update, options)
+	})
+}
+
+func (c *connection) InsertContext(ctx context.Context, namespace string, options *InsertOptions, documents ...interface{}) error {
+	return c.withContext(ctx, func() error {
+		return c.Insert(namespace, options, documents...)
+	})
+}
+
+func (c *connection) RemoveContext(ctx context.Context, namespace string, selector interface{}, options *RemoveOptions) error {
+	return c.withContext(ctx, func() error {
+		return c.Remove(namespace, selector, options)
+	})
+}
+
+func (c *connection) FindContext(ctx context.Context, namespace string, query interface{}, options *FindOptions) (Cursor, error) {
+	var r Cursor
+	err := c.withContext(ctx, func() (err error) {
+		r, err = c.Find(namespace, query, options)
+		return err
+	})
+	return r, err
+}
+
+func (r *cursor) HasNextContext(ctx context.Context) bool {
+	if r.conn == nil {
+		return r.HasNext()
+	}
+	more := true
+	r.conn.withContext(ctx, func() error {
+		more = r.HasNext()
+		return nil
+	})
+	return more
+}
+
+func (r *cursor) NextContext(ctx context.Context, value interface{}) error {
+	if r.conn == nil {
+		return r.Next(value)
+	}
+	var err error
+	if cerr := r.conn.withContext(ctx, func() error {
+		err = r.Next(value)
+		return nil
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// The following functions call the context method on a connection or cursor
+// if the method is available. Otherwise, the functions check the context
+// before calling the plain method.
+
+func updateContext(ctx context.Context, conn Conn, namespace string, selector, update interface{}, options *UpdateOptions) error {
+	if c, ok := conn.(ContextConn); ok {
+		return c.UpdateContext(ctx, namespace, selector, update, options)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return conn.Update(namespace, selector, update, options)
+}
+
+func insertContext(ctx context.Context, conn Conn, namespace string, options *InsertOptions, documents ...interface{}) error {
+	if c, ok := conn.(ContextConn); ok {
+		return c.InsertContext(ctx, namespace, options, documents...)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return conn.Insert(namespace, options, documents...)
+}
+
+func removeContext(ctx context.Context, conn Conn, namespace string, selector interface{}, options *RemoveOptions) error {
+	if c, ok := conn.(ContextConn); ok {
+		return c.RemoveContext(ctx, namespace, selector, options)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return conn.Remove(namespace, selector, options)
+}
+
+func findContext(ctx context.Context, conn Conn, namespace string, query interface{}, options *FindOptions) (Cursor, error) {
+	if c, ok := conn.(ContextConn); ok {
+		return c.FindContext(ctx, namespace, query, options)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return conn.Find(namespace, query, options)
+}
+
+func hasNextContext(ctx context.Context, r Cursor) bool {
+	if r, ok := r.(ContextCursor); ok {
+		return r.HasNextContext(ctx)
+	}
+	if ctx.Err() != nil {
+		return true
+	}
+	return r.HasNext()
+}
+
+func nextContext(ctx context.Context, r Cursor, value interface{}) error {
+	if r, ok := r.(ContextCursor); ok {
+		return r.NextContext(ctx, value)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Next(value)
+}
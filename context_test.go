@@ -0,0 +1,101 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var contextTests = []struct {
+	cancel   bool
+	timeout  time.Duration
+	expected error
+}{
+	{true, 0, context.Canceled},
+	{false, 10 * time.Millisecond, context.DeadlineExceeded},
+}
+
+func TestCursorContext(t *testing.T) {
+	for _, tt := range contextTests {
+		block := make(chan struct{})
+		c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+			if cmd["find"] != nil {
+				<-block
+			}
+			return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "db.coll", "firstBatch": A{M{"x": 0}}}}
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.timeout != 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), tt.timeout)
+		}
+
+		r, err := c.FindContext(ctx, "db.coll", nil, nil)
+		if err != nil {
+			t.Fatal("find", err)
+		}
+		if tt.cancel {
+			time.AfterFunc(10*time.Millisecond, cancel)
+		}
+		rc := r.(ContextCursor)
+		if !rc.HasNextContext(ctx) {
+			t.Errorf("%+v, HasNextContext returned false", tt)
+		}
+		var m M
+		if err := rc.NextContext(ctx, &m); err != tt.expected {
+			t.Errorf("%+v, NextContext returned %v", tt, err)
+		}
+		if err := c.Err(); err != tt.expected {
+			t.Errorf("%+v, connection error %v", tt, err)
+		}
+		cancel()
+		close(block)
+	}
+}
+
+func TestContextNoCancel(t *testing.T) {
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "db.coll", "firstBatch": A{M{"x": 0}}}}
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		r, err := c.FindContext(ctx, "db.coll", nil, nil)
+		if err != nil {
+			t.Fatal("find", err)
+		}
+		var m M
+		if err := r.(ContextCursor).NextContext(ctx, &m); err != nil {
+			t.Fatal("next", err)
+		}
+		r.Close()
+	}
+	if err := c.Err(); err != nil {
+		t.Fatal("connection error", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.FindContext(ctx, "db.coll", nil, nil); err != context.Canceled {
+		t.Fatalf("find with done context returned %v", err)
+	}
+	if err := c.Err(); err != nil {
+		t.Fatal("connection error after done context", err)
+	}
+}
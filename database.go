@@ -15,6 +15,7 @@
 package mongo
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
@@ -76,9 +77,9 @@ type Database struct {
 	// Database name.
 	Name string
 
-	// Command used to check for errors after on insert, update or remove
-	// operation on the collection. If nil, then errors are not checked.
-	LastErrorCmd interface{}
+	// Write concern for insert, update and remove operations on the
+	// database's collections. If nil, the server default is used.
+	WriteConcern *WriteConcern
 }
 
 // C returns the collection with name. This is a lightweight operation. The
@@ -87,17 +88,21 @@ func (db Database) C(name string) Collection {
 	return Collection{
 		Conn:         db.Conn,
 		Namespace:    db.Name + "." + name,
-		LastErrorCmd: db.LastErrorCmd,
+		WriteConcern: db.WriteConcern,
 	}
 }
 
 func runInternal(conn Conn, dbname string, cmd interface{}, options *FindOptions, result interface{}) error {
-	cursor, err := conn.Find(dbname+".$cmd", cmd, options)
+	return runInternalContext(context.Background(), conn, dbname, cmd, options, result)
+}
+
+func runInternalContext(ctx context.Context, conn Conn, dbname string, cmd interface{}, options *FindOptions, result interface{}) error {
+	cursor, err := findContext(ctx, conn, dbname+".$cmd", cmd, options)
 	if err != nil {
 		return err
 	}
 	defer cursor.Close()
-	return cursor.Next(result)
+	return nextContext(ctx, cursor, result)
 }
 
 // Run runs the command cmd on the database.
@@ -118,7 +123,7 @@ func (db Database) Run(cmd interface{}, result interface{}) error {
 	}
 
 	if result != nil {
-		if err := d.Decode(result); err != nil {
+		if err := DecodeWithRegistry(d.Data, result, connRegistry(db.Conn)); err != nil {
 			return err
 		}
 	}
@@ -126,7 +131,8 @@ func (db Database) Run(cmd interface{}, result interface{}) error {
 }
 
 // LastError returns the last error for the database using cmd. If cmd is nil,
-// then the command {"getLasetError": 1} is used to get the error.
+// then the command {"getLasetError": 1} is used to get the error. If the write
+// concern in cmd timed out, then the error is a *WriteConcernError.
 //
 // More information: http://www.mongodb.org/display/DOCS/Last+Error+Commands
 func (db Database) LastError(cmd interface{}) (*MongoError, error) {
@@ -136,12 +142,17 @@ func (db Database) LastError(cmd interface{}) (*MongoError, error) {
 	var r struct {
 		CommandResponse
 		MongoError
+		WTimeout bool `bson:"wtimeout"`
 	}
 	err := runInternal(db.Conn, db.Name, cmd, runFindOptions, &r)
 	if err == nil {
 		err = r.CommandResponse.Err()
 		if err == nil && r.MongoError.Err != "" {
-			err = &r.MongoError
+			if r.WTimeout {
+				err = &WriteConcernError{Code: r.Code, Errmsg: r.MongoError.Err}
+			} else {
+				err = &r.MongoError
+			}
 		}
 	}
 	return &r.MongoError, err
@@ -178,6 +189,9 @@ func (db Database) Dereference(ref DBRef, slaveOk bool, result interface{}) erro
 
 // AddUser creates a user with name and password. If the user already exists,
 // then the password is updated.
+//
+// Deprecated: AddUser writes to the system.users collection, which is not
+// supported by MongoDB 2.6 and later. Use CreateUser or UpdateUser.
 func (db Database) AddUser(name, password string, readOnly bool) error {
 	users := db.C("system.users")
 	return users.Upsert(
@@ -189,18 +203,28 @@ func (db Database) AddUser(name, password string, readOnly bool) error {
 }
 
 // RemoveUser removes user with name from the database.
+//
+// Deprecated: Use DropUser.
 func (db Database) RemoveUser(name string) error {
 	users := db.C("system.users")
 	return users.Remove(M{"user": name})
 }
 
 // Authenticate authenticates user with name and password to this database.
+// Authenticate uses SCRAM-SHA-256 or SCRAM-SHA-1 as reported by the server in
+// the saslSupportedMechs field of the isMaster command. Authenticate uses
+// MONGODB-CR with servers that do not support SCRAM.
 func (db Database) Authenticate(name, password string) error {
+	return authenticate(db.Conn, db.Name, "", name, password)
+}
+
+// authenticateMongoDBCR authenticates using the MONGODB-CR mechanism.
+func authenticateMongoDBCR(conn Conn, dbname, name, password string) error {
 	var r struct {
 		CommandResponse
 		Nonce string `bson:"nonce"`
 	}
-	if err := runInternal(db.Conn, db.Name, M{"getnonce": 1}, runFindOptions, &r); err != nil {
+	if err := runInternal(conn, dbname, M{"getnonce": 1}, runFindOptions, &r); err != nil {
 		return err
 	}
 	if err := r.Err(); err != nil {
@@ -214,7 +238,7 @@ func (db Database) Authenticate(name, password string) error {
 	cmd := D{{"authenticate", 1}, {"user", name}, {"nonce", r.Nonce}, {"key", key}}
 
 	var s CommandResponse
-	if err := runInternal(db.Conn, db.Name, cmd, runFindOptions, &s); err != nil {
+	if err := runInternal(conn, dbname, cmd, runFindOptions, &s); err != nil {
 		return err
 	}
 	return s.Err()
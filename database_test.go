@@ -21,7 +21,7 @@ import (
 func TestLastError(t *testing.T) {
 	c := dialAndDrop(t, "go-mongo-test", "test")
 	defer c.Conn.Close()
-	c.LastErrorCmd = nil
+	c.WriteConcern = &WriteConcern{Unacknowledged: true}
 
 	// Insert duplicate id to create an error.
 	id := NewObjectId()
@@ -0,0 +1,469 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal128 represents a BSON IEEE 754-2008 128-bit decimal floating point
+// value. A finite value is the product of a coefficient with up to 34 decimal
+// digits and a power of ten between 10^-6176 and 10^6111. The zero value is
+// 0E-6176.
+//
+// More information: https://github.com/mongodb/specifications/blob/master/source/bson-decimal128/decimal128.md
+type Decimal128 struct {
+	h, l uint64
+}
+
+var (
+	// ErrDecimal128Inexact is returned with the rounded result when a
+	// conversion to or from Decimal128 cannot be done exactly.
+	ErrDecimal128Inexact = errors.New("mongo: inexact decimal128 conversion")
+
+	// ErrDecimal128Range is returned when a value is too large to convert
+	// to or from Decimal128.
+	ErrDecimal128Range = errors.New("mongo: decimal128 value out of range")
+
+	// ErrDecimal128NaN is returned when converting NaN to a type that
+	// cannot represent NaN.
+	ErrDecimal128NaN = errors.New("mongo: decimal128 value is NaN")
+)
+
+const (
+	decimal128MaxDigits = 34
+	decimal128Bias      = 6176
+	decimal128MinExp    = -6176
+	decimal128MaxExp    = 6111
+	decimal128Sign      = 1 << 63
+	decimal128Inf       = 0x7800000000000000
+	decimal128NaN       = 0x7c00000000000000
+
+	// Binary exponents e such that a value |x| < 2^e rounds to zero and a
+	// value |x| >= 2^(e-1) overflows. 2^-20520 < 10^-6177 and 2^20414 >
+	// 10^6145.
+	decimal128MinBinaryExp = -20520
+	decimal128MaxBinaryExp = 20415
+)
+
+var (
+	bigTen            = big.NewInt(10)
+	decimal128MaxCoef = new(big.Int).Sub(new(big.Int).Exp(bigTen, big.NewInt(decimal128MaxDigits), nil), big.NewInt(1))
+)
+
+// pow10 returns 10^n.
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+// NewDecimal128 returns the decimal with the given high and low 64 bits of the
+// IEEE 754-2008 binary integer decimal encoding.
+func NewDecimal128(high, low uint64) Decimal128 {
+	return Decimal128{high, low}
+}
+
+// Bits returns the high and low 64 bits of the IEEE 754-2008 binary integer
+// decimal encoding of d.
+func (d Decimal128) Bits() (high, low uint64) {
+	return d.h, d.l
+}
+
+// Decimal128NaN returns a NaN decimal.
+func Decimal128NaN() Decimal128 {
+	return Decimal128{h: decimal128NaN}
+}
+
+// Decimal128Inf returns positive infinity if sign >= 0, negative infinity if
+// sign < 0.
+func Decimal128Inf(sign int) Decimal128 {
+	if sign < 0 {
+		return Decimal128{h: decimal128Sign | decimal128Inf}
+	}
+	return Decimal128{h: decimal128Inf}
+}
+
+// IsNaN returns true if d is NaN.
+func (d Decimal128) IsNaN() bool {
+	return d.h&decimal128NaN == decimal128NaN
+}
+
+// IsInf returns true if d is an infinity, according to sign. If sign > 0,
+// IsInf returns true if d is positive infinity. If sign < 0, IsInf returns
+// true if d is negative infinity. If sign == 0, IsInf returns true if d is
+// either infinity.
+func (d Decimal128) IsInf(sign int) bool {
+	if d.h&decimal128NaN != decimal128Inf {
+		return false
+	}
+	neg := d.h&decimal128Sign != 0
+	return sign == 0 || (sign > 0 && !neg) || (sign < 0 && neg)
+}
+
+// decode returns the sign, coefficient and exponent of finite d.
+func (d Decimal128) decode() (neg bool, coef *big.Int, exp int) {
+	neg = d.h&decimal128Sign != 0
+	if d.h>>61&3 == 3 {
+		// The coefficient in this form is larger than the maximum
+		// coefficient. Such values are non-canonical encodings of zero.
+		exp = int(d.h>>47&(1<<14-1)) - decimal128Bias
+		return neg, new(big.Int), exp
+	}
+	exp = int(d.h>>49&(1<<14-1)) - decimal128Bias
+	coef = new(big.Int).SetUint64(d.h & (1<<49 - 1))
+	coef.Lsh(coef, 64)
+	coef.Or(coef, new(big.Int).SetUint64(d.l))
+	if coef.Cmp(decimal128MaxCoef) > 0 {
+		coef.SetInt64(0)
+	}
+	return neg, coef, exp
+}
+
+// newDecimal128 returns the decimal with the value coef * 10^exp and the
+// given sign. The coefficient is rounded to 34 digits using round half to
+// even. The coefficient must not be negative.
+func newDecimal128(neg bool, coef *big.Int, exp int) (Decimal128, error) {
+	var err error
+	coef = new(big.Int).Set(coef)
+	digits := 0
+	if coef.Sign() != 0 {
+		digits = len(coef.String())
+	}
+
+	// Drop digits that do not fit in the coefficient or that are below the
+	// minimum exponent.
+	drop := digits - decimal128MaxDigits
+	if d := decimal128MinExp - exp; d > drop {
+		drop = d
+	}
+	switch {
+	case drop > digits:
+		if coef.Sign() != 0 {
+			err = ErrDecimal128Inexact
+		}
+		coef.SetInt64(0)
+		exp += drop
+	case drop > 0:
+		m := pow10(drop)
+		r := new(big.Int)
+		coef.QuoRem(coef, m, r)
+		if r.Sign() != 0 {
+			err = ErrDecimal128Inexact
+			switch r.Lsh(r, 1).Cmp(m) {
+			case 1:
+				coef.Add(coef, big.NewInt(1))
+			case 0:
+				if coef.Bit(0) != 0 {
+					coef.Add(coef, big.NewInt(1))
+				}
+			}
+			if coef.Cmp(decimal128MaxCoef) > 0 {
+				coef.Quo(coef, bigTen)
+				exp += 1
+			}
+		}
+		exp += drop
+	}
+
+	// Clamp large exponents by adding zeros to the coefficient.
+	if exp > decimal128MaxExp {
+		if coef.Sign() == 0 {
+			exp = decimal128MaxExp
+		} else {
+			pad := exp - decimal128MaxExp
+			if len(coef.String())+pad > decimal128MaxDigits {
+				if neg {
+					return Decimal128Inf(-1), ErrDecimal128Range
+				}
+				return Decimal128Inf(1), ErrDecimal128Range
+			}
+			coef.Mul(coef, pow10(pad))
+			exp = decimal128MaxExp
+		}
+	}
+
+	var d Decimal128
+	if neg {
+		d.h = decimal128Sign
+	}
+	d.h |= uint64(exp+decimal128Bias) << 49
+	d.h |= new(big.Int).Rsh(coef, 64).Uint64()
+	d.l = new(big.Int).And(coef, new(big.Int).SetUint64(math.MaxUint64)).Uint64()
+	return d, err
+}
+
+// ParseDecimal128 parses a decimal string. The string has an optional sign
+// followed by digits with an optional decimal point and an optional exponent,
+// or one of "Inf", "Infinity" and "NaN" in any case. If the value has more
+// than 34 significant digits, then the value is rounded and returned with the
+// error ErrDecimal128Inexact.
+func ParseDecimal128(s string) (Decimal128, error) {
+	orig := s
+	neg := false
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	switch strings.ToLower(s) {
+	case "inf", "infinity":
+		if neg {
+			return Decimal128Inf(-1), nil
+		}
+		return Decimal128Inf(1), nil
+	case "nan":
+		return Decimal128NaN(), nil
+	}
+
+	var digits []byte
+	exp := 0
+	dot := false
+	i := 0
+scan:
+	for ; i < len(s); i++ {
+		switch c := s[i]; {
+		case '0' <= c && c <= '9':
+			digits = append(digits, c)
+			if dot {
+				exp -= 1
+			}
+		case c == '.' && !dot:
+			dot = true
+		default:
+			break scan
+		}
+	}
+	if len(digits) == 0 {
+		return Decimal128{}, errors.New("mongo: invalid decimal128 string " + strconv.Quote(orig))
+	}
+	if i < len(s) {
+		if s[i] != 'e' && s[i] != 'E' {
+			return Decimal128{}, errors.New("mongo: invalid decimal128 string " + strconv.Quote(orig))
+		}
+		n, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return Decimal128{}, errors.New("mongo: invalid decimal128 string " + strconv.Quote(orig))
+		}
+		// Limit the exponent to prevent overflow. A value with an exponent
+		// beyond the limit is zero or out of range. Limiting the exponent
+		// does not change the result.
+		limit := decimal128Bias + decimal128MaxDigits + len(digits)
+		switch {
+		case n > limit:
+			n = limit
+		case n < -limit:
+			n = -limit
+		}
+		exp += n
+	}
+	coef, _ := new(big.Int).SetString(string(digits), 10)
+	return newDecimal128(neg, coef, exp)
+}
+
+// String returns the string representation of d as specified by the BSON
+// decimal128 specification. Scientific notation is used when the exponent is
+// positive or when the value is less than 1E-6.
+func (d Decimal128) String() string {
+	if d.IsNaN() {
+		return "NaN"
+	}
+	if d.IsInf(1) {
+		return "Infinity"
+	}
+	if d.IsInf(-1) {
+		return "-Infinity"
+	}
+	neg, coef, exp := d.decode()
+	digits := coef.String()
+	adjusted := exp + len(digits) - 1
+
+	var buf []byte
+	if neg {
+		buf = append(buf, '-')
+	}
+	switch {
+	case exp == 0:
+		buf = append(buf, digits...)
+	case exp < 0 && adjusted >= -6:
+		n := len(digits) + exp
+		if n > 0 {
+			buf = append(buf, digits[:n]...)
+			buf = append(buf, '.')
+			buf = append(buf, digits[n:]...)
+		} else {
+			buf = append(buf, "0."...)
+			buf = append(buf, strings.Repeat("0", -n)...)
+			buf = append(buf, digits...)
+		}
+	default:
+		buf = append(buf, digits[0])
+		if len(digits) > 1 {
+			buf = append(buf, '.')
+			buf = append(buf, digits[1:]...)
+		}
+		buf = append(buf, 'E')
+		if adjusted >= 0 {
+			buf = append(buf, '+')
+		}
+		buf = strconv.AppendInt(buf, int64(adjusted), 10)
+	}
+	return string(buf)
+}
+
+// NewDecimal128FromBigInt returns the decimal with value coef * 10^exp. If
+// coef has more than 34 significant digits, then the value is rounded and
+// returned with the error ErrDecimal128Inexact.
+func NewDecimal128FromBigInt(coef *big.Int, exp int) (Decimal128, error) {
+	return newDecimal128(coef.Sign() < 0, new(big.Int).Abs(coef), exp)
+}
+
+// BigInt returns the coefficient and exponent of finite d such that the value
+// of d is coef * 10^exp. The error ErrDecimal128NaN or ErrDecimal128Range is
+// returned for NaN and infinities.
+func (d Decimal128) BigInt() (coef *big.Int, exp int, err error) {
+	switch {
+	case d.IsNaN():
+		return nil, 0, ErrDecimal128NaN
+	case d.IsInf(0):
+		return nil, 0, ErrDecimal128Range
+	}
+	neg, coef, exp := d.decode()
+	if neg {
+		coef.Neg(coef)
+	}
+	return coef, exp, nil
+}
+
+// rat returns the value of finite d as a rational number.
+func (d Decimal128) rat() (neg bool, r *big.Rat) {
+	neg, coef, exp := d.decode()
+	if neg {
+		coef.Neg(coef)
+	}
+	r = new(big.Rat).SetInt(coef)
+	switch {
+	case exp > 0:
+		r.Mul(r, new(big.Rat).SetInt(pow10(exp)))
+	case exp < 0:
+		r.Quo(r, new(big.Rat).SetInt(pow10(-exp)))
+	}
+	return neg, r
+}
+
+// NewDecimal128FromBigFloat returns the decimal with the value of x. If the
+// value of x cannot be represented exactly, then the value is rounded to 34
+// significant digits and returned with the error ErrDecimal128Inexact.
+func NewDecimal128FromBigFloat(x *big.Float) (Decimal128, error) {
+	if x.IsInf() {
+		if x.Signbit() {
+			return Decimal128Inf(-1), nil
+		}
+		return Decimal128Inf(1), nil
+	}
+	// Avoid the exact conversion below for values far outside the range of
+	// Decimal128. The cost of the conversion grows with the exponent of x.
+	if x.Sign() != 0 {
+		switch e := x.MantExp(nil); {
+		case e <= decimal128MinBinaryExp:
+			d, _ := newDecimal128(x.Signbit(), new(big.Int), decimal128MinExp)
+			return d, ErrDecimal128Inexact
+		case e >= decimal128MaxBinaryExp:
+			if x.Signbit() {
+				return Decimal128Inf(-1), ErrDecimal128Range
+			}
+			return Decimal128Inf(1), ErrDecimal128Range
+		}
+	}
+	r, _ := x.Rat(nil)
+	// The denominator is a power of two: n / 2^k = n * 5^k / 10^k.
+	k := r.Denom().BitLen() - 1
+	coef := new(big.Int).Abs(r.Num())
+	coef.Mul(coef, new(big.Int).Exp(big.NewInt(5), big.NewInt(int64(k)), nil))
+	return newDecimal128(x.Signbit(), coef, -k)
+}
+
+// BigFloat sets z to the value of d rounded to the precision and rounding mode
+// of z and returns z. If z is nil, then a new big.Float is allocated. If z's
+// precision is 0, then it is changed to 64 or more bits before rounding. The
+// error ErrDecimal128Inexact is returned with the result if rounding was
+// required. The error ErrDecimal128NaN is returned if d is NaN.
+func (d Decimal128) BigFloat(z *big.Float) (*big.Float, error) {
+	if z == nil {
+		z = new(big.Float)
+	}
+	switch {
+	case d.IsNaN():
+		return nil, ErrDecimal128NaN
+	case d.IsInf(1):
+		return z.SetInf(false), nil
+	case d.IsInf(-1):
+		return z.SetInf(true), nil
+	}
+	neg, r := d.rat()
+	z.SetRat(r)
+	if neg && r.Sign() == 0 {
+		z.Neg(z)
+	}
+	if z.Acc() != big.Exact {
+		return z, ErrDecimal128Inexact
+	}
+	return z, nil
+}
+
+// NewDecimal128FromFloat64 returns the shortest decimal that converts back to
+// f. NaN and infinities are converted to the decimal NaN and infinities.
+func NewDecimal128FromFloat64(f float64) Decimal128 {
+	switch {
+	case math.IsNaN(f):
+		return Decimal128NaN()
+	case math.IsInf(f, 1):
+		return Decimal128Inf(1)
+	case math.IsInf(f, -1):
+		return Decimal128Inf(-1)
+	}
+	// The shortest representation has at most 17 digits and an exponent
+	// within the decimal range, so the conversion is exact.
+	d, _ := ParseDecimal128(strconv.FormatFloat(f, 'e', -1, 64))
+	return d
+}
+
+// Float64 returns the float64 value nearest to d. The error
+// ErrDecimal128Inexact is returned with the result if d cannot be represented
+// exactly. The error ErrDecimal128Range is returned with an infinity if d is
+// finite and too large for a float64.
+func (d Decimal128) Float64() (float64, error) {
+	switch {
+	case d.IsNaN():
+		return math.NaN(), nil
+	case d.IsInf(1):
+		return math.Inf(1), nil
+	case d.IsInf(-1):
+		return math.Inf(-1), nil
+	}
+	neg, r := d.rat()
+	f, exact := r.Float64()
+	switch {
+	case math.IsInf(f, 0):
+		return f, ErrDecimal128Range
+	case neg && f == 0:
+		f = math.Copysign(0, -1)
+	}
+	if !exact {
+		return f, ErrDecimal128Inexact
+	}
+	return f, nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+var decimal128Tests = []struct {
+	s        string
+	high     uint64
+	low      uint64
+	expected string // result of String(), if different from s
+	err      error
+}{
+	{"0", 0x3040000000000000, 0, "", nil},
+	{"-0", 0xb040000000000000, 0, "", nil},
+	{"1", 0x3040000000000000, 1, "", nil},
+	{"-1", 0xb040000000000000, 1, "", nil},
+	{"0.1", 0x303e000000000000, 1, "", nil},
+	{"0.001234", 0x3034000000000000, 1234, "", nil},
+	{"123456789012", 0x3040000000000000, 123456789012, "", nil},
+	{"1E+3", 0x3046000000000000, 1, "", nil},
+	{"1.00E-8", 0x302c000000000000, 100, "", nil},
+	{"-1.00E-8", 0xb02c000000000000, 100, "", nil},
+	{"0.0000001", 0x3032000000000000, 1, "1E-7", nil},
+	{"1000", 0x3040000000000000, 1000, "", nil},
+	{"0E+3", 0x3046000000000000, 0, "", nil},
+	{"9.999999999999999999999999999999999E+6144", 0x5fffed09bead87c0, 0x378d8e63ffffffff, "", nil},
+	{"1E-6176", 0x0000000000000000, 1, "", nil},
+	{"1E+6112", 0x5ffe000000000000, 10, "1.0E+6112", nil},
+	{"0E-6177", 0x0000000000000000, 0, "0E-6176", nil},
+	{"1E-6177", 0x0000000000000000, 0, "0E-6176", ErrDecimal128Inexact},
+	{"12345678901234567890123456789012345", 0x30423cde6fff9732, 0xde825cd07e96aff2, "1.234567890123456789012345678901234E+34", ErrDecimal128Inexact},
+	{"1000000000000000000000000000000000000", 0x3046314dc6448d93, 0x38c15b0a00000000, "1.000000000000000000000000000000000E+36", nil},
+	{"1E+7000", 0x7800000000000000, 0, "Infinity", ErrDecimal128Range},
+	{"Infinity", 0x7800000000000000, 0, "", nil},
+	{"-inf", 0xf800000000000000, 0, "-Infinity", nil},
+	{"NaN", 0x7c00000000000000, 0, "", nil},
+	{"+1.5e2", 0x3042000000000000, 15, "1.5E+2", nil},
+	{".5", 0x303e000000000000, 5, "0.5", nil},
+	{"0.1e-9223372036854775808", 0x0000000000000000, 0, "0E-6176", ErrDecimal128Inexact},
+	{"1E+9223372036854775807", 0x7800000000000000, 0, "Infinity", ErrDecimal128Range},
+	{"-0E+9223372036854775807", 0xdffe000000000000, 0, "-0E+6111", nil},
+}
+
+func TestDecimal128(t *testing.T) {
+	for _, tt := range decimal128Tests {
+		d, err := ParseDecimal128(tt.s)
+		if err != tt.err {
+			t.Errorf("ParseDecimal128(%q) returned error %v, want %v", tt.s, err, tt.err)
+		}
+		if h, l := d.Bits(); h != tt.high || l != tt.low {
+			t.Errorf("ParseDecimal128(%q) = %#x %#x, want %#x %#x", tt.s, h, l, tt.high, tt.low)
+		}
+		expected := tt.expected
+		if expected == "" {
+			expected = tt.s
+		}
+		if s := NewDecimal128(tt.high, tt.low).String(); s != expected {
+			t.Errorf("String() = %q, want %q", s, expected)
+		}
+	}
+}
+
+func TestParseDecimal128Errors(t *testing.T) {
+	for _, s := range []string{"", "-", ".", "1.2.3", "1e", "1e+", "E3", "1x", "Infinit", "1e99999999999999999999"} {
+		if _, err := ParseDecimal128(s); err == nil {
+			t.Errorf("ParseDecimal128(%q) did not return an error", s)
+		}
+	}
+}
+
+func TestDecimal128NonCanonical(t *testing.T) {
+	// Coefficient larger than the maximum.
+	if s := NewDecimal128(0x3041ed09bead87c0, 0x378d8e6400000000).String(); s != "0" {
+		t.Errorf("String() = %q, want 0", s)
+	}
+	// Combination field 11 with a finite exponent.
+	if s := NewDecimal128(0x6c10000000000000, 0).String(); s != "0" {
+		t.Errorf("String() = %q, want 0", s)
+	}
+}
+
+func TestDecimal128Float64(t *testing.T) {
+	for _, tt := range []struct {
+		s   string
+		f   float64
+		err error
+	}{
+		{"0.5", 0.5, nil},
+		{"-1.25E+3", -1250, nil},
+		{"0.1", 0.1, ErrDecimal128Inexact},
+		{"-0", math.Copysign(0, -1), nil},
+		{"1E+400", math.Inf(1), ErrDecimal128Range},
+		{"1E-400", 0, ErrDecimal128Inexact},
+	} {
+		d, _ := ParseDecimal128(tt.s)
+		f, err := d.Float64()
+		if f != tt.f || math.Signbit(f) != math.Signbit(tt.f) || err != tt.err {
+			t.Errorf("%s.Float64() = %v, %v, want %v, %v", tt.s, f, err, tt.f, tt.err)
+		}
+	}
+
+	for _, f := range []float64{0, 0.1, -2.5e-300, 1.7976931348623157e308, 123456789} {
+		d := NewDecimal128FromFloat64(f)
+		if actual, _ := d.Float64(); actual != f {
+			t.Errorf("NewDecimal128FromFloat64(%v).Float64() = %v", f, actual)
+		}
+	}
+	if s := NewDecimal128FromFloat64(0.1).String(); s != "0.1" {
+		t.Errorf("NewDecimal128FromFloat64(0.1) = %s, want 0.1", s)
+	}
+	if !NewDecimal128FromFloat64(math.NaN()).IsNaN() || !NewDecimal128FromFloat64(math.Inf(-1)).IsInf(-1) {
+		t.Error("NaN or infinity not converted")
+	}
+	if _, err := Decimal128NaN().BigFloat(nil); err != ErrDecimal128NaN {
+		t.Errorf("NaN.BigFloat() returned %v, want ErrDecimal128NaN", err)
+	}
+}
+
+func TestDecimal128Big(t *testing.T) {
+	d, err := NewDecimal128FromBigInt(big.NewInt(-12345), -2)
+	if err != nil || d.String() != "-123.45" {
+		t.Errorf("NewDecimal128FromBigInt(-12345, -2) = %v, %v", d, err)
+	}
+	coef, exp, err := d.BigInt()
+	if err != nil || coef.Int64() != -12345 || exp != -2 {
+		t.Errorf("BigInt() = %v, %d, %v", coef, exp, err)
+	}
+	if _, _, err := Decimal128Inf(1).BigInt(); err != ErrDecimal128Range {
+		t.Errorf("Inf.BigInt() returned %v", err)
+	}
+
+	n, _ := new(big.Int).SetString("123456789012345678901234567890123456", 10)
+	d, err = NewDecimal128FromBigInt(n, 0)
+	if err != ErrDecimal128Inexact || d.String() != "1.234567890123456789012345678901235E+35" {
+		t.Errorf("NewDecimal128FromBigInt(%v, 0) = %v, %v", n, d, err)
+	}
+
+	f := new(big.Float).SetFloat64(0.375)
+	d, err = NewDecimal128FromBigFloat(f)
+	if err != nil || d.String() != "0.375" {
+		t.Errorf("NewDecimal128FromBigFloat(0.375) = %v, %v", d, err)
+	}
+	f, err = d.BigFloat(nil)
+	if err != nil || f.String() != "0.375" {
+		t.Errorf("BigFloat() = %v, %v", f, err)
+	}
+
+	f = new(big.Float).SetPrec(200).Quo(big.NewFloat(1), big.NewFloat(3))
+	d, err = NewDecimal128FromBigFloat(f)
+	if err != ErrDecimal128Inexact || d.String() != "0.3333333333333333333333333333333333" {
+		t.Errorf("NewDecimal128FromBigFloat(1/3) = %v, %v", d, err)
+	}
+	f = new(big.Float).SetMantExp(big.NewFloat(1), -4194304)
+	if d, err := NewDecimal128FromBigFloat(f); err != ErrDecimal128Inexact || d.String() != "0E-6176" {
+		t.Errorf("NewDecimal128FromBigFloat(2^-4194304) = %v, %v", d, err)
+	}
+	f = new(big.Float).SetMantExp(big.NewFloat(-1), 1<<30)
+	if d, err := NewDecimal128FromBigFloat(f); err != ErrDecimal128Range || !d.IsInf(-1) {
+		t.Errorf("NewDecimal128FromBigFloat(-2^(1<<30)) = %v, %v", d, err)
+	}
+	d, _ = ParseDecimal128("0.1")
+	if _, err := d.BigFloat(new(big.Float).SetPrec(53)); err != ErrDecimal128Inexact {
+		t.Errorf("0.1.BigFloat() returned %v, want ErrDecimal128Inexact", err)
+	}
+}
+
+func TestDecimal128BSON(t *testing.T) {
+	d, _ := ParseDecimal128("-12.50")
+	p, err := Encode(nil, M{"d": d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "\x18\x00\x00\x00\x13d\x00\xe2\x04\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x3c\xb0\x00"
+	if string(p) != expected {
+		t.Errorf("Encode() = %q, want %q", p, expected)
+	}
+	var s struct {
+		D Decimal128 `bson:"d"`
+	}
+	if err := Decode(p, &s); err != nil || s.D != d {
+		t.Errorf("Decode() = %v, %v, want %v", s.D, err, d)
+	}
+	var m M
+	if err := Decode(p, &m); err != nil || !reflect.DeepEqual(m, M{"d": d}) {
+		t.Errorf("Decode() = %v, %v, want %v", m, err, d)
+	}
+}
@@ -35,7 +35,7 @@ func Example() {
 	}
 	defer conn.Close()
 
-	c := mongo.Collection{conn, "example-db.example-collection", mongo.DefaultLastErrorCmd}
+	c := mongo.Collection{Conn: conn, Namespace: "example-db.example-collection"}
 
 	// Insert a document.
 
@@ -0,0 +1,5 @@
+module github.com/Codefor/go-mongo
+
+go 1.21
+
+require golang.org/x/text v0.14.0
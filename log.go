@@ -16,6 +16,7 @@ package mongo
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 )
@@ -41,8 +42,16 @@ func (c *loggingConn) Close() error {
 	return err
 }
 
+func (c *loggingConn) ServerInfo() *ServerInfo {
+	return serverInfo(c.Conn)
+}
+
 func (c *loggingConn) Update(namespace string, selector, update interface{}, options *UpdateOptions) error {
-	err := c.Conn.Update(namespace, selector, update, options)
+	return c.UpdateContext(context.Background(), namespace, selector, update, options)
+}
+
+func (c *loggingConn) UpdateContext(ctx context.Context, namespace string, selector, update interface{}, options *UpdateOptions) error {
+	err := updateContext(ctx, c.Conn, namespace, selector, update, options)
 	var buf bytes.Buffer
 	if options != nil {
 		if options.Upsert {
@@ -57,7 +66,11 @@ func (c *loggingConn) Update(namespace string, selector, update interface{}, opt
 }
 
 func (c *loggingConn) Insert(namespace string, options *InsertOptions, documents ...interface{}) error {
-	err := c.Conn.Insert(namespace, options, documents...)
+	return c.InsertContext(context.Background(), namespace, options, documents...)
+}
+
+func (c *loggingConn) InsertContext(ctx context.Context, namespace string, options *InsertOptions, documents ...interface{}) error {
+	err := insertContext(ctx, c.Conn, namespace, options, documents...)
 	var buf bytes.Buffer
 	if options != nil {
 		if options.ContinueOnError {
@@ -69,7 +82,11 @@ func (c *loggingConn) Insert(namespace string, options *InsertOptions, documents
 }
 
 func (c *loggingConn) Remove(namespace string, selector interface{}, options *RemoveOptions) error {
-	err := c.Conn.Remove(namespace, selector, options)
+	return c.RemoveContext(context.Background(), namespace, selector, options)
+}
+
+func (c *loggingConn) RemoveContext(ctx context.Context, namespace string, selector interface{}, options *RemoveOptions) error {
+	err := removeContext(ctx, c.Conn, namespace, selector, options)
 	var buf bytes.Buffer
 	if options != nil {
 		if options.Single {
@@ -81,7 +98,11 @@ func (c *loggingConn) Remove(namespace string, selector interface{}, options *Re
 }
 
 func (c *loggingConn) Find(namespace string, query interface{}, options *FindOptions) (Cursor, error) {
-	r, err := c.Conn.Find(namespace, query, options)
+	return c.FindContext(context.Background(), namespace, query, options)
+}
+
+func (c *loggingConn) FindContext(ctx context.Context, namespace string, query interface{}, options *FindOptions) (Cursor, error) {
+	r, err := findContext(ctx, c.Conn, namespace, query, options)
 	prefix := ""
 	if r != nil {
 		c.cursorId += 1
@@ -100,6 +121,9 @@ func (c *loggingConn) Find(namespace string, query interface{}, options *FindOpt
 		if options.SlaveOk {
 			buf.WriteString(", slaveOK:true")
 		}
+		if options.ReadPreference != nil {
+			fmt.Fprintf(&buf, ", readPreference:%+v", *options.ReadPreference)
+		}
 		if options.NoCursorTimeout {
 			buf.WriteString(", noCursorTimeout:true")
 		}
@@ -138,9 +162,17 @@ func (r *logCursor) Close() error {
 	return err
 }
 
+func (r *logCursor) HasNextContext(ctx context.Context) bool {
+	return hasNextContext(ctx, r.Cursor)
+}
+
 func (r *logCursor) Next(value interface{}) error {
+	return r.NextContext(context.Background(), value)
+}
+
+func (r *logCursor) NextContext(ctx context.Context, value interface{}) error {
 	var bd BSONData
-	err := r.Cursor.Next(&bd)
+	err := nextContext(ctx, r.Cursor, &bd)
 	var m M
 	if err == nil {
 		err = Decode(bd.Data, value)
@@ -27,7 +27,7 @@ func chapter1(conn Conn) {
 	log.Println("\n== CHAPTER 1 ==")
 
 	// Create a database object.
-	db := Database{conn, "learn", DefaultLastErrorCmd}
+	db := Database{Conn: conn, Name: "learn"}
 
 	// Create a collection object object for the "unicorns" collection.
 	unicorns := db.C("unicorns")
@@ -134,7 +134,7 @@ func chapter2(conn Conn) {
 
 	log.Println("\n== CHAPTER 2 ==")
 
-	db := Database{conn, "learn", DefaultLastErrorCmd}
+	db := Database{Conn: conn, Name: "learn"}
 	unicorns := db.C("unicorns")
 	hits := db.C("hits")
 
@@ -290,7 +290,7 @@ func chapter3(conn Conn) {
 
 	log.Println("\n== CHAPTER 3 ==")
 
-	db := Database{conn, "learn", DefaultLastErrorCmd}
+	db := Database{Conn: conn, Name: "learn"}
 	unicorns := db.C("unicorns")
 
 	log.Print("\n== Find names of all unicorns. ==\n\n")
@@ -341,7 +341,7 @@ func chapter7(conn Conn) {
 
 	log.Println("\n== CHAPTER 7 ==")
 
-	db := Database{conn, "learn", DefaultLastErrorCmd}
+	db := Database{Conn: conn, Name: "learn"}
 	unicorns := db.C("unicorns")
 
 	log.Print("\n== Create index on name. ==\n\n")
@@ -480,7 +480,7 @@ func dateTime(year, month, day, hour, minutes int) time.Time {
 // reset cleans up after previous runs of this applications.
 func reset(conn Conn) {
 	log.Print("\n== Clear documents and indexes created by previous run. ==\n\n")
-	db := Database{conn, "learn", DefaultLastErrorCmd}
+	db := Database{Conn: conn, Name: "learn"}
 	db.Run(D{{"profile", 0}}, nil)
 	db.C("unicorns").Remove(nil)
 	db.C("hits").Remove(nil)
@@ -21,11 +21,17 @@
 // The Database, Collection and Query types provide a number of convenience
 // methods for working with Conn objects.
 //
-// Conn objects are not thread-safe. Multi-threaded applications are
-// responsible for serializing access to Conn objects.
+// Conn objects returned by Dial are not thread-safe. Multi-threaded
+// applications are responsible for serializing access to these Conn objects.
+// The Conn objects returned by DialConcurrent are safe for concurrent use by
+// multiple goroutines.
 package mongo
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
 // Cursor has no more results.
 var Done = errors.New("mongo: cursor has no more results")
@@ -34,6 +40,9 @@ var Done = errors.New("mongo: cursor has no more results")
 type InsertOptions struct {
 	// If true, the server will not stop processing a bulk insert if one insert fails.
 	ContinueOnError bool
+
+	// Write concern for the write. If nil, the server default is used.
+	WriteConcern *WriteConcern
 }
 
 // RemoveOptions specifies options for the Conn.Remove method.
@@ -41,6 +50,9 @@ type RemoveOptions struct {
 	// If true, then the database removes the first matching document in the
 	// collection. Otherwise all matching documents are removed.
 	Single bool
+
+	// Write concern for the write. If nil, the server default is used.
+	WriteConcern *WriteConcern
 }
 
 // UpdateOptions specifies options for the Conn.Update method.
@@ -51,6 +63,9 @@ type UpdateOptions struct {
 
 	// If true, then the database updates all objects matching the query.
 	Multi bool
+
+	// Write concern for the write. If nil, the server default is used.
+	WriteConcern *WriteConcern
 }
 
 // FindOptions specifies options for the Conn.Find method.
@@ -66,6 +81,12 @@ type FindOptions struct {
 	// Allow query of replica slave.
 	SlaveOk bool
 
+	// Read preference for the query. Connections returned from
+	// DialReplicaSet use the read preference to select the replica set
+	// member for the query. Other connections forward the read preference
+	// to the server.
+	ReadPreference *ReadPreference
+
 	// Do not close the cursor on the server after a period of inactivity (10
 	// minutes).
 	NoCursorTimeout bool
@@ -95,6 +116,74 @@ type FindOptions struct {
 	BatchSize int
 }
 
+// Collation specifies language-specific rules for string comparison.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/collation/
+type Collation struct {
+	Locale          string `bson:"locale"`
+	CaseLevel       bool   `bson:"caseLevel,omitempty"`
+	CaseFirst       string `bson:"caseFirst,omitempty"`
+	Strength        int    `bson:"strength,omitempty"`
+	NumericOrdering bool   `bson:"numericOrdering,omitempty"`
+	Alternate       string `bson:"alternate,omitempty"`
+	MaxVariable     string `bson:"maxVariable,omitempty"`
+	Normalization   bool   `bson:"normalization,omitempty"`
+	Backwards       bool   `bson:"backwards,omitempty"`
+}
+
+// ServerInfo describes a MongoDB server. Dial collects the information with the
+// isMaster handshake when the connection is opened. Use the ServerInfo method
+// on connections returned from Dial or a Pool to get the information.
+type ServerInfo struct {
+	// Range of wire protocol versions supported by the server.
+	MinWireVersion int `bson:"minWireVersion"`
+	MaxWireVersion int `bson:"maxWireVersion"`
+
+	// Maximum size of a BSON document.
+	MaxBSONObjectSize int `bson:"maxBsonObjectSize"`
+
+	// Maximum size of a wire protocol message.
+	MaxMessageSizeBytes int `bson:"maxMessageSizeBytes"`
+
+	// Maximum number of write operations in a write command batch.
+	MaxWriteBatchSize int `bson:"maxWriteBatchSize"`
+
+	// Name of the replica set. The name is empty if the server is not a member
+	// of a replica set.
+	SetName string `bson:"setName"`
+
+	// True if the server is a mongos query router.
+	IsMongos bool `bson:"-"`
+
+	// Message returned by mongos to identify itself.
+	Msg string `bson:"msg"`
+
+	// True if the server supports the hello command.
+	HelloOk bool `bson:"helloOk"`
+
+	// True if the server is the primary member of a replica set.
+	IsMaster bool `bson:"ismaster"`
+
+	// True if the server is a secondary member of a replica set.
+	Secondary bool `bson:"secondary"`
+
+	// Members of the replica set as reported by the server.
+	Hosts    []string `bson:"hosts"`
+	Passives []string `bson:"passives"`
+
+	// Address of the primary and of the server as reported by the server.
+	Primary string `bson:"primary"`
+	Me      string `bson:"me"`
+
+	// Replica set member tags.
+	Tags map[string]string `bson:"tags"`
+
+	// Time of the server's most recent write.
+	LastWrite struct {
+		LastWriteDate time.Time `bson:"lastWriteDate"`
+	} `bson:"lastWrite"`
+}
+
 // A Conn represents a connection to a MongoDB server.
 //
 // When the application is done using the connection, the application must call
@@ -105,6 +194,9 @@ type FindOptions struct {
 // and collection. A namespace string has the format "<database>.<collection>"
 // where <database> is the name of the database and <collection> is the name of
 // the collection.
+//
+// Connections that know the server at the other end of the connection also
+// implement ServerInfoConn.
 type Conn interface {
 	// Close releases the resources used by this connection.
 	Close() error
@@ -125,6 +217,48 @@ type Conn interface {
 	Find(namespace string, query interface{}, options *FindOptions) (Cursor, error)
 }
 
+// ContextConn is implemented by connections that accept a context for each
+// operation. The deadline of the context is applied to the network operations
+// performed by the method. If the context is cancelled while the method is
+// waiting on the network, then the method returns the context's error.
+//
+// Cancelling a context in the middle of a server reply leaves the connection
+// in an unknown state. The connection is closed and Err returns the context's
+// error. A Pool discards such connections.
+//
+// The connections returned by Dial and Pool.Get implement ContextConn. The
+// cursors returned by these connections implement ContextCursor.
+type ContextConn interface {
+	Conn
+
+	// UpdateContext is like Update with a context.
+	UpdateContext(ctx context.Context, namespace string, selector, update interface{}, options *UpdateOptions) error
+
+	// InsertContext is like Insert with a context.
+	InsertContext(ctx context.Context, namespace string, options *InsertOptions, documents ...interface{}) error
+
+	// RemoveContext is like Remove with a context.
+	RemoveContext(ctx context.Context, namespace string, selector interface{}, options *RemoveOptions) error
+
+	// FindContext is like Find with a context. The context only applies to
+	// FindContext; use the ContextCursor methods to apply a context to
+	// fetching results.
+	FindContext(ctx context.Context, namespace string, query interface{}, options *FindOptions) (Cursor, error)
+}
+
+// ServerInfoConn is implemented by connections that report information about
+// the server at the other end of the connection. The connections returned by
+// Dial, DialURI and Pool.Get implement ServerInfoConn, as do the wrappers in
+// this package when the wrapped connection does.
+type ServerInfoConn interface {
+	Conn
+
+	// ServerInfo returns the information collected from the server when the
+	// connection was opened or nil if the information is not available. The
+	// application must not modify the returned value.
+	ServerInfo() *ServerInfo
+}
+
 // Cursor iterates over the results from a Find operation.
 //
 // When the application is done using a cursor, the application must call the
@@ -165,3 +299,17 @@ type Cursor interface {
 	// a non-nil pointer to struct or map.
 	Next(value interface{}) error
 }
+
+// ContextCursor is implemented by cursors that accept a context when fetching
+// results. See ContextConn for a discussion of the context.
+type ContextCursor interface {
+	Cursor
+
+	// HasNextContext is like HasNext with a context. If the context is done,
+	// then HasNextContext returns true and the error is returned from a
+	// subsequent call to NextContext.
+	HasNextContext(ctx context.Context) bool
+
+	// NextContext is like Next with a context.
+	NextContext(ctx context.Context, value interface{}) error
+}
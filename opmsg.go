@@ -0,0 +1,373 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"errors"
+	"hash/crc32"
+)
+
+const (
+	opReply = 1
+	opMsg   = 2013
+
+	msgChecksumPresent = 1 << 0
+	msgMoreToCome      = 1 << 1
+	msgExhaustAllowed  = 1 << 16
+
+	// Minimum wire version for OP_MSG (MongoDB 3.6).
+	msgWireVersion = 6
+
+	// Minimum wire version for the insert, update and delete commands
+	// (MongoDB 2.6).
+	writeCommandsWireVersion = 2
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// msgSequence is a document sequence section in an OP_MSG message.
+type msgSequence struct {
+	identifier string
+	documents  []interface{}
+}
+
+// appendMsg appends an OP_MSG message to b. The message contains a body
+// section with the encoding of body followed by a "$db" element with value
+// dbname, and a document sequence section for each element of sequences. The
+// caller sets the message length before sending the message.
+func appendMsg(b buffer, requestId uint32, flags uint32, dbname string, body interface{}, sequences ...msgSequence) (buffer, error) {
+	start := len(b)
+	b.Next(4)                // placeholder for message length
+	b.WriteUint32(requestId) // requestId
+	b.WriteUint32(0)         // responseTo
+	b.WriteUint32(opMsg)     // opCode
+	b.WriteUint32(flags)     // flagBits
+	b.WriteByte(0)           // section kind: body
+
+	offset := len(b)
+	p, err := Encode(b, body)
+	if err != nil {
+		return b, err
+	}
+	b = buffer(p[:len(p)-1]) // remove document terminator
+	b.WriteByte(kindString)
+	b.WriteCString("$db")
+	b.WriteUint32(uint32(len(dbname) + 1))
+	b.WriteCString(dbname)
+	b.WriteByte(0)
+	wire.PutUint32(b[offset:offset+4], uint32(len(b)-offset))
+
+	for _, s := range sequences {
+		b.WriteByte(1) // section kind: document sequence
+		offset := len(b)
+		b.Next(4) // placeholder for section size
+		b.WriteCString(s.identifier)
+		for _, doc := range s.documents {
+			p, err := Encode(b, doc)
+			if err != nil {
+				return b, err
+			}
+			b = buffer(p)
+		}
+		wire.PutUint32(b[offset:offset+4], uint32(len(b)-offset))
+	}
+
+	if flags&msgChecksumPresent != 0 {
+		wire.PutUint32(b[start:start+4], uint32(len(b)-start+4))
+		b.WriteUint32(crc32.Checksum(b[start:], castagnoli))
+	}
+	return b, nil
+}
+
+// msgReply is a parsed OP_MSG message.
+type msgReply struct {
+	flags     uint32
+	body      []byte
+	sequences map[string][][]byte
+}
+
+var errBadMsg = errors.New("mongo: malformed OP_MSG message")
+
+// parseMsg parses the OP_MSG message m. The slice m includes the standard
+// message header. The returned documents are slices of m.
+func parseMsg(m []byte) (*msgReply, error) {
+	if len(m) < 21 {
+		return nil, errBadMsg
+	}
+	r := &msgReply{flags: wire.Uint32(m[16:20])}
+	end := len(m)
+	if r.flags&msgChecksumPresent != 0 {
+		end -= 4
+		if end < 21 || crc32.Checksum(m[:end], castagnoli) != wire.Uint32(m[end:]) {
+			return nil, errors.New("mongo: OP_MSG checksum mismatch")
+		}
+	}
+	p := m[20:end]
+	for len(p) > 0 {
+		kind := p[0]
+		p = p[1:]
+		if len(p) < 4 {
+			return nil, errBadMsg
+		}
+		n := int(wire.Uint32(p))
+		if n < 5 || n > len(p) {
+			return nil, errBadMsg
+		}
+		switch kind {
+		case 0:
+			if r.body != nil {
+				return nil, errors.New("mongo: OP_MSG message with multiple body sections")
+			}
+			r.body = p[:n]
+		case 1:
+			s := p[4:n]
+			i := 0
+			for i < len(s) && s[i] != 0 {
+				i += 1
+			}
+			if i >= len(s) {
+				return nil, errBadMsg
+			}
+			identifier := string(s[:i])
+			s = s[i+1:]
+			var docs [][]byte
+			for len(s) > 0 {
+				if len(s) < 5 {
+					return nil, errBadMsg
+				}
+				dn := int(wire.Uint32(s))
+				if dn < 5 || dn > len(s) {
+					return nil, errBadMsg
+				}
+				docs = append(docs, s[:dn])
+				s = s[dn:]
+			}
+			if r.sequences == nil {
+				r.sequences = make(map[string][][]byte)
+			}
+			r.sequences[identifier] = append(r.sequences[identifier], docs...)
+		default:
+			return nil, errors.New("mongo: unknown OP_MSG section kind")
+		}
+		p = p[n:]
+	}
+	if r.body == nil {
+		return nil, errors.New("mongo: OP_MSG message with no body section")
+	}
+	return r, nil
+}
+
+// cursorReply is the response to commands that return a cursor.
+type cursorReply struct {
+	CommandResponse
+	Code   int `bson:"code"`
+	Cursor struct {
+		Id         int64      `bson:"id"`
+		Namespace  string     `bson:"ns"`
+		FirstBatch []BSONData `bson:"firstBatch"`
+		NextBatch  []BSONData `bson:"nextBatch"`
+	} `bson:"cursor"`
+}
+
+// Err returns the error from the response or nil.
+func (r *cursorReply) Err() error {
+	if r.Code == 43 {
+		return errors.New("mongo: cursor not found")
+	}
+	return r.CommandResponse.Err()
+}
+
+// batch returns the documents in the reply.
+func (r *cursorReply) batch() []BSONData {
+	if r.Cursor.FirstBatch != nil {
+		return r.Cursor.FirstBatch
+	}
+	return r.Cursor.NextBatch
+}
+
+// writeReply is the response to the insert, update and delete commands.
+type writeReply struct {
+	CommandResponse
+	Code      int `bson:"code"`
+	N         int `bson:"n"`
+	NModified int `bson:"nModified"`
+	Upserted  []struct {
+		Index int         `bson:"index"`
+		Id    interface{} `bson:"_id"`
+	} `bson:"upserted"`
+	WriteErrors       []WriteError       `bson:"writeErrors"`
+	WriteConcernError *WriteConcernError `bson:"writeConcernError"`
+}
+
+// Err returns the error from the response or nil.
+func (r *writeReply) Err() error {
+	if err := r.CommandResponse.Err(); err != nil {
+		return err
+	}
+	if len(r.WriteErrors) > 0 {
+		return &MongoError{Err: r.WriteErrors[0].Errmsg, Code: r.WriteErrors[0].Code, N: r.N}
+	}
+	if r.WriteConcernError != nil {
+		return r.WriteConcernError
+	}
+	return nil
+}
+
+// withWriteConcern appends the write concern to the write command cmd.
+func withWriteConcern(cmd D, wc *WriteConcern) D {
+	if wc != nil {
+		cmd.Append("writeConcern", wc.document())
+	}
+	return cmd
+}
+
+// insertCommand returns the database name, command and document sequence for
+// an insert with the given options.
+func insertCommand(namespace string, options *InsertOptions, documents []interface{}) (string, D, msgSequence) {
+	dbname, cname := SplitNamespace(namespace)
+	cmd := D{{"insert", cname}, {"ordered", options.flags()&insertContinueOnError == 0}}
+	if options != nil {
+		cmd = withWriteConcern(cmd, options.WriteConcern)
+	}
+	return dbname, cmd, msgSequence{"documents", documents}
+}
+
+// updateCommand returns the database name, command and document sequence for
+// an update with the given options.
+func updateCommand(namespace string, selector, update interface{}, options *UpdateOptions) (string, D, msgSequence) {
+	dbname, cname := SplitNamespace(namespace)
+	flags := options.flags()
+	u := D{
+		{"q", selector},
+		{"u", update},
+		{"upsert", flags&updateUpsert != 0},
+		{"multi", flags&updateMulti != 0},
+	}
+	cmd := D{{"update", cname}}
+	if options != nil {
+		cmd = withWriteConcern(cmd, options.WriteConcern)
+	}
+	return dbname, cmd, msgSequence{"updates", []interface{}{u}}
+}
+
+// removeCommand returns the database name, command and document sequence for
+// a delete with the given options.
+func removeCommand(namespace string, selector interface{}, options *RemoveOptions) (string, D, msgSequence) {
+	dbname, cname := SplitNamespace(namespace)
+	limit := 0
+	if options.flags()&removeSingle != 0 {
+		limit = 1
+	}
+	d := D{{"q", selector}, {"limit", limit}}
+	cmd := D{{"delete", cname}}
+	if options != nil {
+		cmd = withWriteConcern(cmd, options.WriteConcern)
+	}
+	return dbname, cmd, msgSequence{"deletes", []interface{}{d}}
+}
+
+// findCommand returns the find command for a query on collection cname.
+func (r *cursor) findCommand(cname string, query, fields interface{}, skip int) interface{} {
+	cmd := D{{"find", cname}}
+	var spec *QuerySpec
+	switch q := query.(type) {
+	case *QuerySpec:
+		spec = q
+	case QuerySpec:
+		spec = &q
+	default:
+		cmd.Append("filter", query)
+	}
+	if spec != nil {
+		cmd.Append("filter", spec.Query)
+		if spec.Sort != nil {
+			cmd.Append("sort", spec.Sort)
+		}
+		if spec.Hint != nil {
+			cmd.Append("hint", spec.Hint)
+		}
+		if spec.Min != nil {
+			cmd.Append("min", spec.Min)
+		}
+		if spec.Max != nil {
+			cmd.Append("max", spec.Max)
+		}
+	}
+	if fields != nil {
+		cmd.Append("projection", fields)
+	}
+	if skip > 0 {
+		cmd.Append("skip", skip)
+	}
+	if r.limit > 0 {
+		cmd.Append("limit", r.limit)
+	}
+	if n := int32(r.numberToReturn()); n != 0 {
+		// A negative number to return requests a single batch.
+		if n < 0 {
+			cmd.Append("singleBatch", true)
+			n *= -1
+		}
+		cmd.Append("batchSize", n)
+	}
+	if r.flags&queryTailable != 0 {
+		cmd.Append("tailable", true)
+	}
+	if r.flags&queryAwaitData != 0 {
+		cmd.Append("awaitData", true)
+	}
+	if r.flags&queryNoCursorTimeout != 0 {
+		cmd.Append("noCursorTimeout", true)
+	}
+	if r.flags&queryPartialResults != 0 {
+		cmd.Append("allowPartialResults", true)
+	}
+	switch {
+	case r.readPref != nil:
+		cmd.Append("$readPreference", r.readPref.document())
+	case r.flags&querySlaveOk != 0:
+		cmd.Append("$readPreference", D{{"mode", "secondaryPreferred"}})
+	}
+	if spec != nil && spec.Explain {
+		r.command = true
+		return D{{"explain", cmd}}
+	}
+	return cmd
+}
+
+// getMoreCommand returns the database name and getMore command for the next
+// batch of results.
+func (r *cursor) getMoreCommand() (string, D) {
+	dbname, cname := SplitNamespace(r.namespace)
+	cmd := D{{"getMore", int64(r.cursorId)}, {"collection", cname}}
+	if n := int32(r.numberToReturn()); n != 0 {
+		if n < 0 {
+			n *= -1
+		}
+		cmd.Append("batchSize", n)
+	}
+	return dbname, cmd
+}
+
+// killCursorsCommand returns the database name and killCursors command for
+// cursors on namespace.
+func killCursorsCommand(namespace string, cursorIds []uint64) (string, D) {
+	dbname, cname := SplitNamespace(namespace)
+	ids := make([]int64, len(cursorIds))
+	for i, cursorId := range cursorIds {
+		ids[i] = int64(cursorId)
+	}
+	return dbname, D{{"killCursors", cname}, {"cursors", ids}}
+}
@@ -0,0 +1,232 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// msgHandler returns the reply to a command.
+type msgHandler func(cmd M, sequences map[string][][]byte) interface{}
+
+// serveMsg reads commands sent with OP_MSG or OP_QUERY from conn and writes
+// the replies returned by handler until conn is closed.
+func serveMsg(conn net.Conn, handler msgHandler) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	var requestId uint32
+	for {
+		var h [16]byte
+		if _, err := io.ReadFull(br, h[:]); err != nil {
+			return
+		}
+		m := make([]byte, wire.Uint32(h[0:4]))
+		copy(m, h[:])
+		if _, err := io.ReadFull(br, m[16:]); err != nil {
+			return
+		}
+		requestId += 1
+		b := buffer(nil)
+		b.Next(4)
+		b.WriteUint32(requestId)
+		b.WriteUint32(wire.Uint32(m[4:8]))
+
+		var cmd M
+		var sequences map[string][][]byte
+		if wire.Uint32(m[12:16]) == 2004 {
+			// OP_QUERY: skip flags, namespace, numberToSkip and numberToReturn.
+			i := 20
+			for m[i] != 0 {
+				i += 1
+			}
+			if err := Decode(m[i+9:], &cmd); err != nil {
+				return
+			}
+			b.WriteUint32(opReply)
+			b.WriteUint32(0) // flags
+			b.WriteUint64(0) // cursorId
+			b.WriteUint32(0) // startingFrom
+			b.WriteUint32(1) // numberReturned
+		} else {
+			msg, err := parseMsg(m)
+			if err != nil {
+				return
+			}
+			if err := Decode(msg.body, &cmd); err != nil {
+				return
+			}
+			if msg.flags&msgMoreToCome != 0 {
+				handler(cmd, msg.sequences)
+				continue
+			}
+			b.WriteUint32(opMsg)
+			b.WriteUint32(0)
+			b.WriteByte(0)
+			sequences = msg.sequences
+		}
+		p, err := Encode(b, handler(cmd, sequences))
+		if err != nil {
+			return
+		}
+		wire.PutUint32(p[0:4], uint32(len(p)))
+		if _, err := conn.Write(p); err != nil {
+			return
+		}
+	}
+}
+
+// newMsgConnection returns a connection that speaks OP_MSG to a fake server
+// implemented by handler.
+func newMsgConnection(handler msgHandler) *connection {
+	client, server := net.Pipe()
+	go serveMsg(server, handler)
+	return &connection{
+		conn:    client,
+		br:      bufio.NewReader(client),
+		cursors: make(map[uint32]*cursor),
+		msg:     true,
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	client, server := net.Pipe()
+	go serveMsg(server, func(cmd M, sequences map[string][][]byte) interface{} {
+		if cmd["isMaster"] == nil {
+			return M{"ok": 0, "errmsg": "unexpected command"}
+		}
+		return M{"ok": 1, "maxWireVersion": 17, "maxBsonObjectSize": 100, "setName": "rs", "msg": "isdbgrid"}
+	})
+	c := &connection{conn: client, br: bufio.NewReader(client), cursors: make(map[uint32]*cursor)}
+	defer c.Close()
+	if err := c.handshake(); err != nil {
+		t.Fatal("handshake", err)
+	}
+	if !c.msg {
+		t.Error("OP_MSG not selected")
+	}
+	expected := ServerInfo{
+		MaxWireVersion:      17,
+		MaxBSONObjectSize:   100,
+		MaxMessageSizeBytes: defaultMaxMessageSizeBytes,
+		MaxWriteBatchSize:   defaultMaxWriteBatchSize,
+		SetName:             "rs",
+		IsMongos:            true,
+		Msg:                 "isdbgrid",
+	}
+	var conn Conn = c
+	sc, ok := conn.(ServerInfoConn)
+	if !ok {
+		t.Fatal("connection does not implement ServerInfoConn")
+	}
+	if info := sc.ServerInfo(); info == nil || !reflect.DeepEqual(*info, expected) {
+		t.Errorf("info = %+v, want %+v", info, expected)
+	}
+}
+
+func TestMsgEncoding(t *testing.T) {
+	for _, flags := range []uint32{0, msgChecksumPresent, msgMoreToCome} {
+		b, err := appendMsg(nil, 7, flags, "db", D{{"insert", "coll"}},
+			msgSequence{"documents", []interface{}{M{"x": 1}, M{"x": 2}}})
+		if err != nil {
+			t.Fatal("appendMsg", err)
+		}
+		wire.PutUint32(b[0:4], uint32(len(b)))
+		msg, err := parseMsg(b)
+		if err != nil {
+			t.Fatalf("flags=%x, parseMsg returned %v", flags, err)
+		}
+		if msg.flags != flags {
+			t.Errorf("flags=%x, parsed flags %x", flags, msg.flags)
+		}
+		var body M
+		if err := Decode(msg.body, &body); err != nil {
+			t.Fatal("decode body", err)
+		}
+		if !reflect.DeepEqual(body, M{"insert": "coll", "$db": "db"}) {
+			t.Errorf("flags=%x, body=%v", flags, body)
+		}
+		if n := len(msg.sequences["documents"]); n != 2 {
+			t.Errorf("flags=%x, got %d documents, want 2", flags, n)
+		}
+	}
+
+	b, _ := appendMsg(nil, 7, msgChecksumPresent, "db", D{{"ping", 1}})
+	b[len(b)-5] ^= 0xff
+	if _, err := parseMsg(b); err == nil {
+		t.Error("parseMsg did not detect bad checksum")
+	}
+}
+
+func TestMsgFind(t *testing.T) {
+	var killed bool
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		switch {
+		case cmd["find"] != nil:
+			return M{"ok": 1, "cursor": M{"id": int64(99), "ns": "db.coll", "firstBatch": A{M{"x": 0}, M{"x": 1}}}}
+		case cmd["getMore"] != nil:
+			return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "db.coll", "nextBatch": A{M{"x": 2}}}}
+		case cmd["insert"] != nil:
+			return M{"ok": 1, "n": len(sequences["documents"])}
+		case cmd["delete"] != nil:
+			return M{"ok": 1, "n": 0, "writeErrors": A{M{"index": 0, "code": 2, "errmsg": "bad delete"}}}
+		case cmd["killCursors"] != nil:
+			killed = true
+		}
+		return M{"ok": 0, "errmsg": "unexpected command"}
+	})
+	defer c.Close()
+
+	if err := c.Insert("db.coll", nil, M{"x": 0}, M{"x": 1}); err != nil {
+		t.Fatal("insert", err)
+	}
+	if err := c.Remove("db.coll", nil, nil); err == nil || err.Error() != "bad delete" {
+		t.Fatal("remove returned", err)
+	}
+
+	r, err := c.Find("db.coll", nil, &FindOptions{BatchSize: 2})
+	if err != nil {
+		t.Fatal("find", err)
+	}
+	count := 0
+	for r.HasNext() {
+		var m M
+		if err := r.Next(&m); err != nil {
+			t.Fatal("next", err)
+		}
+		if m["x"] != count {
+			t.Errorf("x=%v, want %d", m["x"], count)
+		}
+		count += 1
+	}
+	if count != 3 {
+		t.Errorf("count=%d, want 3", count)
+	}
+	r.Close()
+
+	var m M
+	if err := runInternal(c, "db", D{{"thisIsNotACommand", 1}}, runFindOptions, &m); err != nil {
+		t.Fatal("run", err)
+	}
+	if m["errmsg"] != "unexpected command" {
+		t.Errorf("command reply %v", m)
+	}
+	if killed {
+		t.Error("exhausted cursor killed")
+	}
+}
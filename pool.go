@@ -14,6 +14,26 @@
 
 package mongo
 
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPoolExhausted is returned from a pool connection method (Get) when the
+// maximum number of active connections in the pool has been reached and the
+// pool is not configured to wait for a connection.
+var ErrPoolExhausted = errors.New("mongo: connection pool exhausted")
+
+var errPoolClosed = errors.New("mongo: connection pool closed")
+
+// DefaultTestIdleTime is the idle time after which the pool pings a connection
+// before returning the connection from Get when the pool's TestOnBorrow and
+// TestIdleTime fields are not set.
+const DefaultTestIdleTime = time.Minute
+
 // Pool maintains a pool of database connections.
 //
 // The following example shows how to use a pool in a web application. The
@@ -38,9 +58,9 @@ package mongo
 //            return
 //        }, 3)
 //
-// This pool has a maximum of three connections to the server specified by the
-// variable "server". Each connection is logged into the "admin" database using
-// the credentials specified by the variables "name" and "password".
+// This pool has a maximum of three idle connections to the server specified by
+// the variable "server". Each connection is logged into the "admin" database
+// using the credentials specified by the variables "name" and "password".
 //
 // A request handler gets a connection from the pool and closes the connection
 // when the handler is done:
@@ -55,14 +75,86 @@ package mongo
 // Close() returns the connection to the pool if there's room in the pool and
 // the connection does not have a permanent error. Otherwise, Close() releases
 // the resources used by the connection.
+//
+// The MaxActive, Wait, IdleTimeout and MaxLifetime fields limit the number and
+// age of the connections in the pool. Set these fields before calling Get for
+// the first time:
+//
+//  pool = mongo.NewDialPool(server, 3)
+//  pool.MaxActive = 20
+//  pool.Wait = true
+//  pool.IdleTimeout = 4 * time.Minute
+//
+// Before returning an idle connection from Get, the pool checks the health of
+// the connection. By default, the pool runs the ping command on connections
+// that have been idle longer than TestIdleTime. Set TestOnBorrow to replace the
+// default check.
 type Pool struct {
-	newFn func() (Conn, error)
-	conns chan Conn
+	// Dial is an application supplied function for creating new connections.
+	Dial func() (Conn, error)
+
+	// TestOnBorrow is an optional application supplied function for checking
+	// the health of an idle connection before the connection is used again by
+	// the application. Argument t is the time that the connection was returned
+	// to the pool. If the function returns an error, then the connection is
+	// closed.
+	TestOnBorrow func(c Conn, t time.Time) error
+
+	// When TestOnBorrow is nil, the pool pings connections that have been idle
+	// longer than this duration. If the value is zero, then
+	// DefaultTestIdleTime is used. If the value is negative, then idle
+	// connections are not tested.
+	TestIdleTime time.Duration
+
+	// Maximum number of idle connections in the pool.
+	MaxIdle int
+
+	// Maximum number of connections allocated by the pool at a given time.
+	// When zero, there is no limit on the number of connections in the pool.
+	MaxActive int
+
+	// Close connections after remaining idle for this duration. If the value
+	// is zero, then idle connections are not closed. Applications should set
+	// the timeout to a value less than the server's timeout.
+	IdleTimeout time.Duration
+
+	// If Wait is true and the pool is at the MaxActive limit, then Get() waits
+	// for a connection to be returned to the pool before returning.
+	Wait bool
+
+	// Close connections older than this duration. If the value is zero, then
+	// the pool does not close connections based on age.
+	MaxLifetime time.Duration
+
+	mu     sync.Mutex
+	closed bool
+	active int
+
+	// Counters reported by Stats.
+	created      int64
+	closedCount  int64
+	waitCount    int64
+	waitDuration time.Duration
+
+	// ch holds a token for each connection that can be allocated when Wait
+	// is true and MaxActive is greater than zero.
+	chInitialized bool
+	ch            chan struct{}
+
+	// Stack of idleConn with most recently used at the front.
+	idle list.List
+}
+
+type idleConn struct {
+	c       Conn
+	created time.Time
+	t       time.Time
 }
 
 type pooledConnection struct {
 	Conn
-	pool *Pool
+	pool    *Pool
+	created time.Time
 }
 
 // NewDialPool returns a new connection pool. The pool uses mongo.Dial to
@@ -71,41 +163,310 @@ func NewDialPool(addr string, maxIdle int) *Pool {
 	return NewPool(func() (Conn, error) { return Dial(addr) }, maxIdle)
 }
 
+// NewDialPoolWithOptions returns a new connection pool. The pool uses
+// mongo.DialWithOptions to create new connections and maintains a maximum of
+// maxIdle connections.
+func NewDialPoolWithOptions(addr string, options *DialOptions, maxIdle int) *Pool {
+	return NewPool(func() (Conn, error) { return DialWithOptions(addr, options) }, maxIdle)
+}
+
 // NewPool returns a new connection pool. The pool uses newFn to create
 // connections as needed and maintains a maximum of maxIdle idle connections.
 func NewPool(newFn func() (Conn, error), maxIdle int) *Pool {
-	return &Pool{newFn: newFn, conns: make(chan Conn, maxIdle)}
+	return &Pool{Dial: newFn, MaxIdle: maxIdle}
 }
 
 // Get returns an idle connection from the pool if available or creates a new
 // connection. The caller should Close() the connection to return the
 // connection to the pool.
 func (p *Pool) Get() (Conn, error) {
-	var c Conn
-	select {
-	case c = <-p.conns:
-	default:
-		var err error
-		c, err = p.newFn()
-		if err != nil {
-			return nil, err
+	return p.GetContext(context.Background())
+}
+
+// GetContext returns a connection using the provided context. The context is
+// used to limit the time spent waiting for a connection when Wait is true. The
+// context is not used by the connection after GetContext returns.
+func (p *Pool) GetContext(ctx context.Context) (Conn, error) {
+	if err := p.waitVacantConn(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+
+	// Prune stale connections at the back of the idle list.
+	if p.IdleTimeout > 0 {
+		for e := p.idle.Back(); e != nil; e = p.idle.Back() {
+			ic := e.Value.(idleConn)
+			if time.Since(ic.t) < p.IdleTimeout {
+				break
+			}
+			p.idle.Remove(e)
+			p.mu.Unlock()
+			ic.c.Close()
+			p.mu.Lock()
+			p.active -= 1
+			p.closedCount += 1
 		}
 	}
-	return &pooledConnection{Conn: c, pool: p}, nil
+
+	// Get idle connection from the front of the idle list.
+	for e := p.idle.Front(); e != nil; e = p.idle.Front() {
+		ic := e.Value.(idleConn)
+		p.idle.Remove(e)
+		p.mu.Unlock()
+		if !p.expired(ic.created) && p.testOnBorrow(ic.c, ic.t) == nil {
+			return &pooledConnection{Conn: ic.c, pool: p, created: ic.created}, nil
+		}
+		ic.c.Close()
+		p.mu.Lock()
+		p.active -= 1
+		p.closedCount += 1
+	}
+
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errPoolClosed
+	}
+
+	if !p.Wait && p.MaxActive > 0 && p.active >= p.MaxActive {
+		p.mu.Unlock()
+		return nil, ErrPoolExhausted
+	}
+
+	p.active += 1
+	p.mu.Unlock()
+	c, err := p.Dial()
+	if err != nil {
+		p.mu.Lock()
+		p.active -= 1
+		if p.ch != nil && !p.closed {
+			p.ch <- struct{}{}
+		}
+		p.mu.Unlock()
+		return nil, err
+	}
+	p.mu.Lock()
+	p.created += 1
+	p.mu.Unlock()
+	return &pooledConnection{Conn: c, pool: p, created: time.Now()}, nil
 }
 
-func (c *pooledConnection) Close() error {
-	if c.Conn == nil {
+// testOnBorrow checks the health of idle connection c.
+func (p *Pool) testOnBorrow(c Conn, t time.Time) error {
+	if p.TestOnBorrow != nil {
+		return p.TestOnBorrow(c, t)
+	}
+	threshold := p.TestIdleTime
+	if threshold == 0 {
+		threshold = DefaultTestIdleTime
+	}
+	if threshold < 0 || time.Since(t) < threshold {
 		return nil
 	}
-	if c.Err() != nil {
+	return Database{Conn: c, Name: "admin"}.Run(D{{"ping", 1}}, nil)
+}
+
+// PoolStats contains pool statistics.
+type PoolStats struct {
+	// ActiveCount is the number of connections in the pool. The count
+	// includes idle connections and connections in use.
+	ActiveCount int
+
+	// IdleCount is the number of idle connections in the pool.
+	IdleCount int
+
+	// CreatedCount is the total number of connections created by the pool.
+	CreatedCount int64
+
+	// ClosedCount is the total number of connections closed by the pool.
+	ClosedCount int64
+
+	// WaitCount is the total number of connections waited for. This value is
+	// only updated when Wait is true.
+	WaitCount int64
+
+	// WaitDuration is the total time blocked waiting for a new connection.
+	// This value is only updated when Wait is true.
+	WaitDuration time.Duration
+}
+
+// Stats returns pool statistics.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	stats := PoolStats{
+		ActiveCount:  p.active,
+		IdleCount:    p.idle.Len(),
+		CreatedCount: p.created,
+		ClosedCount:  p.closedCount,
+		WaitCount:    p.waitCount,
+		WaitDuration: p.waitDuration,
+	}
+	p.mu.Unlock()
+	return stats
+}
+
+// ActiveCount returns the number of connections in the pool. The count
+// includes idle connections and connections in use.
+func (p *Pool) ActiveCount() int {
+	p.mu.Lock()
+	active := p.active
+	p.mu.Unlock()
+	return active
+}
+
+// IdleCount returns the number of idle connections in the pool.
+func (p *Pool) IdleCount() int {
+	p.mu.Lock()
+	idle := p.idle.Len()
+	p.mu.Unlock()
+	return idle
+}
+
+// Close releases the resources used by the pool. Connections in use are
+// closed when they are returned to the pool.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.active -= p.idle.Len()
+	p.closedCount += int64(p.idle.Len())
+	var conns []Conn
+	for e := p.idle.Front(); e != nil; e = e.Next() {
+		conns = append(conns, e.Value.(idleConn).c)
+	}
+	p.idle.Init()
+	if p.ch != nil {
+		close(p.ch)
+	}
+	p.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (p *Pool) expired(created time.Time) bool {
+	return p.MaxLifetime > 0 && time.Since(created) >= p.MaxLifetime
+}
+
+func (p *Pool) lazyInit() {
+	p.mu.Lock()
+	if !p.chInitialized {
+		p.ch = make(chan struct{}, p.MaxActive)
+		if p.closed {
+			close(p.ch)
+		} else {
+			for i := 0; i < p.MaxActive; i++ {
+				p.ch <- struct{}{}
+			}
+		}
+		p.chInitialized = true
+	}
+	p.mu.Unlock()
+}
+
+// waitVacantConn waits for a vacant connection slot in the pool when Wait is
+// true and MaxActive is greater than zero.
+func (p *Pool) waitVacantConn(ctx context.Context) error {
+	if !p.Wait || p.MaxActive <= 0 {
 		return nil
 	}
+	p.lazyInit()
+
 	select {
-	case c.pool.conns <- c.Conn:
+	case _, ok := <-p.ch:
+		if !ok {
+			return errPoolClosed
+		}
+		return nil
 	default:
-		c.Conn.Close()
 	}
+
+	start := time.Now()
+	defer func() {
+		p.mu.Lock()
+		p.waitCount += 1
+		p.waitDuration += time.Since(start)
+		p.mu.Unlock()
+	}()
+
+	select {
+	case _, ok := <-p.ch:
+		if !ok {
+			return errPoolClosed
+		}
+		// Additionally check that the context was not cancelled while the
+		// slot was acquired.
+		if err := ctx.Err(); err != nil {
+			p.mu.Lock()
+			if !p.closed {
+				p.ch <- struct{}{}
+			}
+			p.mu.Unlock()
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// put returns connection c to the pool. The connection is closed if forceClose
+// is true, the pool is closed or the connection has exceeded the maximum
+// lifetime.
+func (p *Pool) put(c Conn, created time.Time, forceClose bool) {
+	p.mu.Lock()
+	if !p.closed && !forceClose && !p.expired(created) {
+		p.idle.PushFront(idleConn{c: c, created: created, t: time.Now()})
+		if p.idle.Len() > p.MaxIdle {
+			c = p.idle.Remove(p.idle.Back()).(idleConn).c
+		} else {
+			c = nil
+		}
+	}
+	if c != nil {
+		p.mu.Unlock()
+		c.Close()
+		p.mu.Lock()
+		p.active -= 1
+		p.closedCount += 1
+	}
+	if p.ch != nil && !p.closed {
+		p.ch <- struct{}{}
+	}
+	p.mu.Unlock()
+}
+
+// ServerInfo returns information about the server at the other end of the
+// underlying connection.
+func (c *pooledConnection) ServerInfo() *ServerInfo {
+	return serverInfo(c.Conn)
+}
+
+func (c *pooledConnection) UpdateContext(ctx context.Context, namespace string, selector, update interface{}, options *UpdateOptions) error {
+	return updateContext(ctx, c.Conn, namespace, selector, update, options)
+}
+
+func (c *pooledConnection) InsertContext(ctx context.Context, namespace string, options *InsertOptions, documents ...interface{}) error {
+	return insertContext(ctx, c.Conn, namespace, options, documents...)
+}
+
+func (c *pooledConnection) RemoveContext(ctx context.Context, namespace string, selector interface{}, options *RemoveOptions) error {
+	return removeContext(ctx, c.Conn, namespace, selector, options)
+}
+
+func (c *pooledConnection) FindContext(ctx context.Context, namespace string, query interface{}, options *FindOptions) (Cursor, error) {
+	return findContext(ctx, c.Conn, namespace, query, options)
+}
+
+func (c *pooledConnection) Close() error {
+	if c.Conn == nil {
+		return nil
+	}
+	c.pool.put(c.Conn, c.created, c.Err() != nil)
 	c.Conn = nil
 	return nil
 }
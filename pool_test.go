@@ -15,8 +15,11 @@
 package mongo
 
 import (
+	"context"
+	"errors"
 	"io"
 	"testing"
+	"time"
 )
 
 type fakeConn struct {
@@ -81,3 +84,202 @@ func TestPool(t *testing.T) {
 		t.Fatal("expected count 12, actual", count)
 	}
 }
+
+func TestPoolMaxActive(t *testing.T) {
+	var count int
+	p := NewPool(func() (Conn, error) { count += 1; return &fakeConn{}, nil }, 2)
+	p.MaxActive = 2
+
+	c1, _ := p.Get()
+	c2, _ := p.Get()
+	if _, err := p.Get(); err != ErrPoolExhausted {
+		t.Fatal("expected ErrPoolExhausted, actual", err)
+	}
+	if n := p.ActiveCount(); n != 2 {
+		t.Fatal("expected active count 2, actual", n)
+	}
+	c1.Close()
+	c3, err := p.Get()
+	if err != nil {
+		t.Fatal("get after close", err)
+	}
+	c2.Close()
+	c3.Close()
+	if count != 2 {
+		t.Fatal("expected count 2, actual", count)
+	}
+	if n := p.IdleCount(); n != 2 {
+		t.Fatal("expected idle count 2, actual", n)
+	}
+}
+
+func TestPoolWait(t *testing.T) {
+	p := NewPool(func() (Conn, error) { return &fakeConn{}, nil }, 1)
+	p.MaxActive = 1
+	p.Wait = true
+
+	c, _ := p.Get()
+	got := make(chan error, 1)
+	go func() {
+		c, err := p.Get()
+		if err == nil {
+			c.Close()
+		}
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		t.Fatal("get did not wait, returned", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Close()
+	if err := <-got; err != nil {
+		t.Fatal("get returned", err)
+	}
+
+	c, _ = p.Get()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Fatal("expected context.DeadlineExceeded, actual", err)
+	}
+	c.Close()
+}
+
+func TestPoolTimeouts(t *testing.T) {
+	var conns []*fakeConn
+	p := NewPool(func() (Conn, error) {
+		c := &fakeConn{}
+		conns = append(conns, c)
+		return c, nil
+	}, 2)
+
+	p.IdleTimeout = 10 * time.Millisecond
+	c, _ := p.Get()
+	c.Close()
+	time.Sleep(20 * time.Millisecond)
+	c, _ = p.Get()
+	c.Close()
+	if len(conns) != 2 || !conns[0].klosed {
+		t.Fatal("idle connection not closed after timeout")
+	}
+
+	p.IdleTimeout = 0
+	p.MaxLifetime = 10 * time.Millisecond
+	c, _ = p.Get()
+	time.Sleep(20 * time.Millisecond)
+	c.Close()
+	if !conns[1].klosed || p.IdleCount() != 0 {
+		t.Fatal("connection not closed after lifetime")
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	var conns []*fakeConn
+	p := NewPool(func() (Conn, error) {
+		c := &fakeConn{}
+		conns = append(conns, c)
+		return c, nil
+	}, 2)
+	p.MaxActive = 2
+	p.Wait = true
+
+	c1, _ := p.Get()
+	c2, _ := p.Get()
+	c1.Close()
+	p.Close()
+	if !conns[0].klosed {
+		t.Error("idle connection not closed by pool close")
+	}
+	c2.Close()
+	if !conns[1].klosed {
+		t.Error("active connection not closed after pool close")
+	}
+	if _, err := p.Get(); err == nil {
+		t.Error("get from closed pool returned nil error")
+	}
+	if n := p.ActiveCount(); n != 0 {
+		t.Error("expected active count 0, actual", n)
+	}
+}
+
+func TestPoolTestOnBorrow(t *testing.T) {
+	var conns []*fakeConn
+	p := NewPool(func() (Conn, error) {
+		c := &fakeConn{}
+		conns = append(conns, c)
+		return c, nil
+	}, 2)
+	p.TestOnBorrow = func(c Conn, t time.Time) error {
+		return errors.New("bad connection")
+	}
+
+	c, _ := p.Get()
+	c.Close()
+	c, _ = p.Get()
+	c.Close()
+	if len(conns) != 2 || !conns[0].klosed {
+		t.Fatal("connection failing test not closed")
+	}
+
+	stats := p.Stats()
+	expected := PoolStats{ActiveCount: 1, IdleCount: 1, CreatedCount: 2, ClosedCount: 1}
+	if stats != expected {
+		t.Fatalf("stats = %+v, want %+v", stats, expected)
+	}
+}
+
+func TestPoolDefaultTestOnBorrow(t *testing.T) {
+	pings := 0
+	p := NewPool(func() (Conn, error) {
+		return newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+			if cmd["ping"] != nil {
+				pings += 1
+				return M{"ok": 1}
+			}
+			return M{"ok": 0, "errmsg": "unexpected command"}
+		}), nil
+	}, 1)
+	defer p.Close()
+
+	for _, tt := range []struct {
+		idle  time.Duration
+		pings int
+	}{
+		{0, 0},
+		{-1, 0},
+		{time.Nanosecond, 2},
+	} {
+		pings = 0
+		p.TestIdleTime = tt.idle
+		c, _ := p.Get()
+		c.Close()
+		time.Sleep(time.Millisecond)
+		c, _ = p.Get()
+		c.Close()
+		if pings != tt.pings {
+			t.Errorf("idle=%v, pings=%d, want %d", tt.idle, pings, tt.pings)
+		}
+	}
+	if n := p.Stats().CreatedCount; n != 1 {
+		t.Errorf("expected created count 1, actual %d", n)
+	}
+}
+
+func TestPoolWaitStats(t *testing.T) {
+	p := NewPool(func() (Conn, error) { return &fakeConn{}, nil }, 1)
+	p.MaxActive = 1
+	p.Wait = true
+
+	c, _ := p.Get()
+	time.AfterFunc(10*time.Millisecond, func() { c.Close() })
+	c2, err := p.Get()
+	if err != nil {
+		t.Fatal("get", err)
+	}
+	c2.Close()
+	stats := p.Stats()
+	if stats.WaitCount != 1 || stats.WaitDuration <= 0 {
+		t.Errorf("stats = %+v, want one wait", stats)
+	}
+}
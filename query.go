@@ -113,6 +113,12 @@ func (q *Query) SlaveOk(slaveOk bool) *Query {
 	return q
 }
 
+// ReadPreference specifies the replica set members that can serve the query.
+func (q *Query) ReadPreference(pref *ReadPreference) *Query {
+	q.Options.ReadPreference = pref
+	return q
+}
+
 // PartialResults specifies if mongos can reply with partial results when a
 // shard is missing.
 func (q *Query) PartialResults(ok bool) *Query {
@@ -0,0 +1,286 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// UnknownWriteOutcomeError is returned from a reconnecting connection when the
+// connection failed during a write. The server may or may not have applied
+// the write.
+type UnknownWriteOutcomeError struct {
+	// The network error.
+	Err error
+}
+
+func (e *UnknownWriteOutcomeError) Error() string {
+	return "mongo: write outcome unknown: " + e.Err.Error()
+}
+
+func (e *UnknownWriteOutcomeError) Unwrap() error {
+	return e.Err
+}
+
+// ReconnectOptions specifies options for NewReconnectingConn.
+type ReconnectOptions struct {
+	// Delay before the first retry after a failed dial. The delay doubles
+	// after each consecutive failure. The default is 100 milliseconds.
+	MinBackoff time.Duration
+
+	// Maximum delay between dials. The default is 10 seconds.
+	MaxBackoff time.Duration
+}
+
+type reconnectingConn struct {
+	dial     func() (Conn, error)
+	options  ReconnectOptions
+	conn     Conn
+	failures int       // number of consecutive failed dials
+	nextDial time.Time // earliest time for the next dial
+	err      error
+}
+
+// NewReconnectingConn returns a connection that uses dial to connect to the
+// server. When the connection to the server fails with a permanent error, the
+// next operation on the returned connection dials again. Consecutive failed
+// dials are spaced using exponential backoff.
+//
+// A query is retried once on a new connection if the connection fails before
+// the first document is returned to the application. Commands are not
+// retried. If the connection fails during
+// Update, Insert or Remove, then the method returns an
+// *UnknownWriteOutcomeError because the server may have applied the write.
+// Cursors are not resumed on the new connection.
+//
+// The Err method on the returned connection only returns an error after the
+// connection is closed. The options argument can be nil.
+func NewReconnectingConn(dial func() (Conn, error), options *ReconnectOptions) Conn {
+	c := &reconnectingConn{dial: dial}
+	if options != nil {
+		c.options = *options
+	}
+	if c.options.MinBackoff <= 0 {
+		c.options.MinBackoff = 100 * time.Millisecond
+	}
+	if c.options.MaxBackoff <= 0 {
+		c.options.MaxBackoff = 10 * time.Second
+	}
+	return c
+}
+
+// backoff returns the delay after the given number of consecutive failed
+// dials.
+func (c *reconnectingConn) backoff(failures int) time.Duration {
+	d := c.options.MinBackoff
+	for i := 1; i < failures && d < c.options.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.options.MaxBackoff {
+		d = c.options.MaxBackoff
+	}
+	return d
+}
+
+// get returns the current connection to the server. If the connection has a
+// permanent error, then get waits for the backoff delay and dials a new
+// connection.
+func (c *reconnectingConn) get(ctx context.Context) (Conn, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.conn != nil {
+		if c.conn.Err() == nil {
+			return c.conn, nil
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+	if d := time.Until(c.nextDial); d > 0 {
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+	}
+	conn, err := c.dial()
+	if err != nil {
+		c.failures += 1
+		c.nextDial = time.Now().Add(c.backoff(c.failures))
+		return nil, err
+	}
+	c.failures = 0
+	c.conn = conn
+	return conn, nil
+}
+
+// writeError returns the error for a failed write on conn.
+func writeError(conn Conn, err error) error {
+	if err != nil && conn.Err() != nil {
+		return &UnknownWriteOutcomeError{Err: err}
+	}
+	return err
+}
+
+func (c *reconnectingConn) Close() error {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	if c.err == nil {
+		c.err = errors.New("mongo: connection closed")
+	}
+	return nil
+}
+
+func (c *reconnectingConn) Err() error {
+	return c.err
+}
+
+// ServerInfo returns information about the server at the other end of the
+// current connection or nil if there is no current connection.
+func (c *reconnectingConn) ServerInfo() *ServerInfo {
+	if c.conn == nil {
+		return nil
+	}
+	return serverInfo(c.conn)
+}
+
+func (c *reconnectingConn) Update(namespace string, selector, update interface{}, options *UpdateOptions) error {
+	return c.UpdateContext(context.Background(), namespace, selector, update, options)
+}
+
+func (c *reconnectingConn) UpdateContext(ctx context.Context, namespace string, selector, update interface{}, options *UpdateOptions) error {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+	return writeError(conn, updateContext(ctx, conn, namespace, selector, update, options))
+}
+
+func (c *reconnectingConn) Insert(namespace string, options *InsertOptions, documents ...interface{}) error {
+	return c.InsertContext(context.Background(), namespace, options, documents...)
+}
+
+func (c *reconnectingConn) InsertContext(ctx context.Context, namespace string, options *InsertOptions, documents ...interface{}) error {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+	return writeError(conn, insertContext(ctx, conn, namespace, options, documents...))
+}
+
+func (c *reconnectingConn) Remove(namespace string, selector interface{}, options *RemoveOptions) error {
+	return c.RemoveContext(context.Background(), namespace, selector, options)
+}
+
+func (c *reconnectingConn) RemoveContext(ctx context.Context, namespace string, selector interface{}, options *RemoveOptions) error {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+	return writeError(conn, removeContext(ctx, conn, namespace, selector, options))
+}
+
+func (c *reconnectingConn) Find(namespace string, query interface{}, options *FindOptions) (Cursor, error) {
+	return c.FindContext(context.Background(), namespace, query, options)
+}
+
+func (c *reconnectingConn) FindContext(ctx context.Context, namespace string, query interface{}, options *FindOptions) (Cursor, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := findContext(ctx, conn, namespace, query, options)
+	if _, cname := SplitNamespace(namespace); cname == "$cmd" {
+		return cursor, err
+	}
+	r := &reconnectingCursor{
+		Cursor:    cursor,
+		c:         c,
+		conn:      conn,
+		namespace: namespace,
+		query:     query,
+		options:   options,
+	}
+	if err != nil && !r.retry(ctx) {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reconnectingCursor retries a query on a new connection if the connection
+// fails before the first document is returned to the application.
+type reconnectingCursor struct {
+	Cursor
+	c         *reconnectingConn
+	conn      Conn
+	namespace string
+	query     interface{}
+	options   *FindOptions
+	started   bool // a document was returned to the application
+	retried   bool
+}
+
+// retry runs the query again on a new connection if the current connection
+// failed and the query was not already retried.
+func (r *reconnectingCursor) retry(ctx context.Context) bool {
+	if r.started || r.retried || r.conn.Err() == nil || ctx.Err() != nil {
+		return false
+	}
+	r.retried = true
+	conn, err := r.c.get(ctx)
+	if err != nil {
+		return false
+	}
+	cursor, err := findContext(ctx, conn, r.namespace, r.query, r.options)
+	if err != nil {
+		return false
+	}
+	if r.Cursor != nil {
+		r.Cursor.Close()
+	}
+	r.Cursor = cursor
+	r.conn = conn
+	return true
+}
+
+func (r *reconnectingCursor) HasNext() bool {
+	return r.HasNextContext(context.Background())
+}
+
+func (r *reconnectingCursor) HasNextContext(ctx context.Context) bool {
+	more := hasNextContext(ctx, r.Cursor)
+	if err := r.Cursor.Err(); err != nil && err != Done && r.retry(ctx) {
+		more = hasNextContext(ctx, r.Cursor)
+	}
+	return more
+}
+
+func (r *reconnectingCursor) Next(value interface{}) error {
+	return r.NextContext(context.Background(), value)
+}
+
+func (r *reconnectingCursor) NextContext(ctx context.Context, value interface{}) error {
+	if !r.started {
+		r.HasNextContext(ctx)
+		r.started = true
+	}
+	return nextContext(ctx, r.Cursor, value)
+}
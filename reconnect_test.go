@@ -0,0 +1,96 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReconnectingConn(t *testing.T) {
+	var conns []*connection
+	c := NewReconnectingConn(func() (Conn, error) {
+		conn := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+			switch {
+			case cmd["find"] != nil:
+				return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "db.coll", "firstBatch": A{M{"x": 0}}}}
+			case cmd["insert"] != nil:
+				return M{"ok": 1, "n": 1}
+			}
+			return M{"ok": 0, "errmsg": "unexpected command"}
+		})
+		conns = append(conns, conn)
+		return conn, nil
+	}, nil)
+	defer c.Close()
+
+	coll := Collection{Conn: c, Namespace: "db.coll"}
+	var m M
+	if err := coll.Find(nil).One(&m); err != nil {
+		t.Fatal("find", err)
+	}
+
+	// Break the connection. The query is retried on a new connection.
+	conns[0].conn.Close()
+	if err := coll.Find(nil).One(&m); err != nil {
+		t.Fatal("find after failure", err)
+	}
+	if len(conns) != 2 {
+		t.Fatalf("dialed %d connections, want 2", len(conns))
+	}
+
+	// Break the connection. The write outcome is unknown.
+	conns[1].conn.Close()
+	err := c.Insert("db.coll", nil, M{"x": 1})
+	if _, ok := err.(*UnknownWriteOutcomeError); !ok {
+		t.Fatalf("insert after failure returned %v, want *UnknownWriteOutcomeError", err)
+	}
+	if err := c.Insert("db.coll", nil, M{"x": 1}); err != nil {
+		t.Fatal("insert after reconnect", err)
+	}
+	if err := c.Err(); err != nil {
+		t.Fatal("connection error", err)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	dials := 0
+	c := NewReconnectingConn(func() (Conn, error) {
+		dials += 1
+		return nil, errors.New("dial failed")
+	}, &ReconnectOptions{MinBackoff: 10 * time.Millisecond, MaxBackoff: 30 * time.Millisecond})
+	defer c.Close()
+
+	rc := c.(*reconnectingConn)
+	for i, expected := range []time.Duration{10, 10, 20, 30, 30} {
+		if d := rc.backoff(i); d != expected*time.Millisecond {
+			t.Errorf("backoff(%d) = %v, want %v", i, d, expected*time.Millisecond)
+		}
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := c.Remove("db.coll", nil, nil); err == nil {
+			t.Fatal("remove succeeded")
+		}
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("three dials took %v, want at least 30ms", d)
+	}
+	if dials != 3 {
+		t.Errorf("dials=%d, want 3", dials)
+	}
+}
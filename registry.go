@@ -0,0 +1,295 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"context"
+	"reflect"
+)
+
+// ValueEncoder encodes v as a BSON value. The returned data is in the same
+// format as the BSONData Data field. If kind is zero, then the value is not
+// written to the encoding.
+type ValueEncoder func(v reflect.Value) (kind int, data []byte, err error)
+
+// ValueDecoder decodes the BSON value with kind and data to v. The value v is
+// settable. The decoder must copy the data if it wishes to retain the data
+// after returning.
+type ValueDecoder func(kind int, data []byte, v reflect.Value) error
+
+type interfaceEncoder struct {
+	t   reflect.Type
+	enc ValueEncoder
+}
+
+type interfaceDecoder struct {
+	t   reflect.Type
+	dec ValueDecoder
+}
+
+// Registry holds encoders and decoders for types that are not supported by
+// Encode and Decode or that should be encoded differently from the default.
+// The encoders and decoders in a registry are used before the Marshaler
+// interfaces and the built-in encodings.
+//
+// The zero value is an empty registry. Register all encoders and decoders
+// before using the registry. Once in use, a registry is safe for concurrent
+// use by multiple goroutines.
+type Registry struct {
+	encoders          map[reflect.Type]ValueEncoder
+	decoders          map[reflect.Type]ValueDecoder
+	interfaceEncoders []interfaceEncoder
+	interfaceDecoders []interfaceDecoder
+}
+
+// RegisterEncoder registers enc as the encoder for values of type t.
+//
+// If t is an interface type, then enc is used for values where the value or a
+// pointer to the value implements t. Encoders for the exact type of a value
+// are used before interface encoders. Interface encoders are checked in the
+// order registered.
+func (r *Registry) RegisterEncoder(t reflect.Type, enc ValueEncoder) {
+	if t.Kind() == reflect.Interface {
+		r.interfaceEncoders = append(r.interfaceEncoders, interfaceEncoder{t, enc})
+		return
+	}
+	if r.encoders == nil {
+		r.encoders = make(map[reflect.Type]ValueEncoder)
+	}
+	r.encoders[t] = enc
+}
+
+// RegisterDecoder registers dec as the decoder for values of type t. If t is
+// a pointer type, then the value passed to dec can be a nil pointer.
+//
+// If t is an interface type, then dec is used for non-pointer values where
+// the value or a pointer to the value implements t. Decoders for the exact
+// type of a value are used before interface decoders. Interface decoders are
+// checked in the order registered.
+func (r *Registry) RegisterDecoder(t reflect.Type, dec ValueDecoder) {
+	if t.Kind() == reflect.Interface {
+		r.interfaceDecoders = append(r.interfaceDecoders, interfaceDecoder{t, dec})
+		return
+	}
+	if r.decoders == nil {
+		r.decoders = make(map[reflect.Type]ValueDecoder)
+	}
+	r.decoders[t] = dec
+}
+
+// implements returns true if v or a pointer to v implements interface t.
+func implements(v reflect.Value, t reflect.Type) bool {
+	return v.Type().Implements(t) || (v.CanAddr() && reflect.PtrTo(v.Type()).Implements(t))
+}
+
+// encoder returns the encoder for v or nil if the registry does not have an
+// encoder for v. Nil pointers and interfaces do not have an encoder.
+func (r *Registry) encoder(v reflect.Value) ValueEncoder {
+	if r == nil {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	if enc, ok := r.encoders[v.Type()]; ok {
+		return enc
+	}
+	for _, ie := range r.interfaceEncoders {
+		if implements(v, ie.t) {
+			return ie.enc
+		}
+	}
+	return nil
+}
+
+// decoder returns the decoder for v or nil if the registry does not have a
+// decoder for v.
+func (r *Registry) decoder(v reflect.Value) ValueDecoder {
+	if r == nil {
+		return nil
+	}
+	if dec, ok := r.decoders[v.Type()]; ok {
+		return dec
+	}
+	if v.Kind() != reflect.Ptr {
+		for _, id := range r.interfaceDecoders {
+			if implements(v, id.t) {
+				return id.dec
+			}
+		}
+	}
+	return nil
+}
+
+// NewRegistryConn returns a wrapper around a connection that encodes and
+// decodes documents using registry r.
+func NewRegistryConn(conn Conn, r *Registry) Conn {
+	return &registryConn{conn, r}
+}
+
+type registryConn struct {
+	Conn
+	registry *Registry
+}
+
+// connRegistry returns the registry used by conn or nil if conn does not use
+// a registry.
+func connRegistry(conn Conn) *Registry {
+	if c, ok := conn.(*registryConn); ok {
+		return c.registry
+	}
+	return nil
+}
+
+// encode encodes document v with the connection's registry.
+func (c *registryConn) encode(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case nil, BSONData:
+		return v, nil
+	}
+	p, err := EncodeWithRegistry(nil, v, c.registry)
+	if err != nil {
+		return nil, err
+	}
+	return BSONData{Kind: kindDocument, Data: p}, nil
+}
+
+// encodeValue encodes v with the connection's registry. Unlike encode, the
+// value is not required to be a document.
+func (c *registryConn) encodeValue(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return encodeValueData(v, c.registry)
+}
+
+func (c *registryConn) ServerInfo() *ServerInfo {
+	return serverInfo(c.Conn)
+}
+
+func (c *registryConn) Update(namespace string, selector, update interface{}, options *UpdateOptions) error {
+	return c.UpdateContext(context.Background(), namespace, selector, update, options)
+}
+
+func (c *registryConn) UpdateContext(ctx context.Context, namespace string, selector, update interface{}, options *UpdateOptions) error {
+	selector, err := c.encode(selector)
+	if err != nil {
+		return err
+	}
+	update, err = c.encode(update)
+	if err != nil {
+		return err
+	}
+	return updateContext(ctx, c.Conn, namespace, selector, update, options)
+}
+
+func (c *registryConn) Insert(namespace string, options *InsertOptions, documents ...interface{}) error {
+	return c.InsertContext(context.Background(), namespace, options, documents...)
+}
+
+func (c *registryConn) InsertContext(ctx context.Context, namespace string, options *InsertOptions, documents ...interface{}) error {
+	encoded := make([]interface{}, len(documents))
+	for i, doc := range documents {
+		var err error
+		encoded[i], err = c.encode(doc)
+		if err != nil {
+			return err
+		}
+	}
+	return insertContext(ctx, c.Conn, namespace, options, encoded...)
+}
+
+func (c *registryConn) Remove(namespace string, selector interface{}, options *RemoveOptions) error {
+	return c.RemoveContext(context.Background(), namespace, selector, options)
+}
+
+func (c *registryConn) RemoveContext(ctx context.Context, namespace string, selector interface{}, options *RemoveOptions) error {
+	selector, err := c.encode(selector)
+	if err != nil {
+		return err
+	}
+	return removeContext(ctx, c.Conn, namespace, selector, options)
+}
+
+func (c *registryConn) Find(namespace string, query interface{}, options *FindOptions) (Cursor, error) {
+	return c.FindContext(context.Background(), namespace, query, options)
+}
+
+func (c *registryConn) FindContext(ctx context.Context, namespace string, query interface{}, options *FindOptions) (Cursor, error) {
+	var err error
+	switch q := query.(type) {
+	case QuerySpec:
+		query, err = c.encodeSpec(&q)
+	case *QuerySpec:
+		query, err = c.encodeSpec(q)
+	default:
+		query, err = c.encode(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if options != nil && options.Fields != nil {
+		o := *options
+		o.Fields, err = c.encode(o.Fields)
+		if err != nil {
+			return nil, err
+		}
+		options = &o
+	}
+	r, err := findContext(ctx, c.Conn, namespace, query, options)
+	if err != nil {
+		return nil, err
+	}
+	return &registryCursor{r, c.registry}, nil
+}
+
+// encodeSpec returns a copy of the query specification with the values
+// encoded. The connection uses the fields of the specification to build the
+// find command.
+func (c *registryConn) encodeSpec(spec *QuerySpec) (*QuerySpec, error) {
+	s := *spec
+	for _, p := range []*interface{}{&s.Query, &s.Sort, &s.Hint, &s.Min, &s.Max} {
+		var err error
+		*p, err = c.encodeValue(*p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+type registryCursor struct {
+	Cursor
+	registry *Registry
+}
+
+func (r *registryCursor) HasNextContext(ctx context.Context) bool {
+	return hasNextContext(ctx, r.Cursor)
+}
+
+func (r *registryCursor) Next(value interface{}) error {
+	return r.NextContext(context.Background(), value)
+}
+
+func (r *registryCursor) NextContext(ctx context.Context, value interface{}) error {
+	var bd BSONData
+	if err := nextContext(ctx, r.Cursor, &bd); err != nil {
+		return err
+	}
+	return DecodeWithRegistry(bd.Data, value, r.registry)
+}
@@ -0,0 +1,158 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func decodeStringValue(kind int, data []byte) (string, error) {
+	var s string
+	err := BSONData{Kind: kind, Data: data}.Decode(&s)
+	return s, err
+}
+
+// newTestRegistry returns a registry that encodes *big.Int and types that
+// implement encoding.TextMarshaler as strings.
+func newTestRegistry() *Registry {
+	var r Registry
+	r.RegisterEncoder(reflect.TypeOf((*big.Int)(nil)), func(v reflect.Value) (int, []byte, error) {
+		bd, err := encodeValueData(v.Interface().(*big.Int).String(), nil)
+		return bd.Kind, bd.Data, err
+	})
+	r.RegisterDecoder(reflect.TypeOf((*big.Int)(nil)), func(kind int, data []byte, v reflect.Value) error {
+		s, err := decodeStringValue(kind, data)
+		if err != nil {
+			return err
+		}
+		n, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return errors.New("bad integer")
+		}
+		v.Set(reflect.ValueOf(n))
+		return nil
+	})
+	r.RegisterEncoder(reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem(), func(v reflect.Value) (int, []byte, error) {
+		p, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return 0, nil, err
+		}
+		bd, err := encodeValueData(string(p), nil)
+		return bd.Kind, bd.Data, err
+	})
+	r.RegisterDecoder(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem(), func(kind int, data []byte, v reflect.Value) error {
+		s, err := decodeStringValue(kind, data)
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	})
+	return &r
+}
+
+type stRegistry struct {
+	N  *big.Int `bson:"n"`
+	IP net.IP   `bson:"ip"`
+	X  int      `bson:"x"`
+}
+
+func TestRegistry(t *testing.T) {
+	r := newTestRegistry()
+	n, _ := new(big.Int).SetString("12345678901234567890", 10)
+	v := stRegistry{N: n, IP: net.IPv4(10, 0, 0, 1), X: 1}
+
+	p, err := EncodeWithRegistry(nil, &v, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m M
+	if err := Decode(p, &m); err != nil {
+		t.Fatal(err)
+	}
+	expected := "map[ip:10.0.0.1 n:12345678901234567890 x:1]"
+	if s := fmt.Sprint(m); s != expected {
+		t.Errorf("encoded %s, want %s", s, expected)
+	}
+
+	var actual stRegistry
+	if err := DecodeWithRegistry(p, &actual, r); err != nil {
+		t.Fatal(err)
+	}
+	if actual.N.Cmp(v.N) != 0 || !actual.IP.Equal(v.IP) || actual.X != 1 {
+		t.Errorf("DecodeWithRegistry() = %+v, want %+v", actual, v)
+	}
+
+	p, _ = Encode(nil, M{"n": "bad"})
+	if err := DecodeWithRegistry(p, &actual, r); err == nil {
+		t.Error("DecodeWithRegistry of bad value did not return an error")
+	}
+}
+
+func TestRegistryInvalidValue(t *testing.T) {
+	var r Registry
+	r.RegisterEncoder(reflect.TypeOf(point{}), func(v reflect.Value) (int, []byte, error) {
+		return KindDocument, []byte{1, 2, 3}, nil
+	})
+	if _, err := EncodeWithRegistry(nil, M{"p": point{1, 2}}, &r); err == nil {
+		t.Error("EncodeWithRegistry of invalid encoder output did not return an error")
+	}
+}
+
+func TestCollectionRegistry(t *testing.T) {
+	var commands []M
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		commands = append(commands, cmd)
+		switch {
+		case cmd["insert"] != nil:
+			return M{"ok": 1, "n": 1}
+		case cmd["find"] != nil:
+			return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "test.c", "firstBatch": []M{
+				{"n": "42", "ip": "10.0.0.2", "x": 2},
+			}}}
+		}
+		return M{"ok": 0, "errmsg": "unexpected command"}
+	})
+	defer c.Close()
+	coll := Collection{Conn: c, Namespace: "test.c", Registry: newTestRegistry()}
+
+	if err := coll.Insert(&stRegistry{N: big.NewInt(7), IP: net.IPv4(10, 0, 0, 1)}); err != nil {
+		t.Fatal("insert", err)
+	}
+	var v stRegistry
+	if err := coll.Find(M{"n": big.NewInt(42)}).One(&v); err != nil {
+		t.Fatal("find", err)
+	}
+	if v.N.Int64() != 42 || !v.IP.Equal(net.IPv4(10, 0, 0, 2)) || v.X != 2 {
+		t.Errorf("find returned %+v", v)
+	}
+
+	if len(commands) != 2 {
+		t.Fatalf("sent %d commands, want 2", len(commands))
+	}
+	expected := "[map[ip:10.0.0.1 n:7 x:0]]"
+	if s := fmt.Sprint(commands[0]["documents"]); s != expected {
+		t.Errorf("inserted %s, want %s", s, expected)
+	}
+	expected = "map[n:42]"
+	if s := fmt.Sprint(commands[1]["filter"]); s != expected {
+		t.Errorf("filter = %s, want %s", s, expected)
+	}
+}
@@ -0,0 +1,518 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// ReadMode specifies the replica set members that can serve a read.
+type ReadMode int
+
+const (
+	// Read from the primary.
+	ReadPrimary ReadMode = iota
+
+	// Read from the primary if available, otherwise from a secondary.
+	ReadPrimaryPreferred
+
+	// Read from a secondary.
+	ReadSecondary
+
+	// Read from a secondary if available, otherwise from the primary.
+	ReadSecondaryPreferred
+
+	// Read from the primary or a secondary with the lowest network latency.
+	ReadNearest
+)
+
+var readModeNames = []string{"primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest"}
+
+func (m ReadMode) String() string {
+	if m < 0 || int(m) >= len(readModeNames) {
+		return "ReadMode(" + strconv.Itoa(int(m)) + ")"
+	}
+	return readModeNames[m]
+}
+
+// ReadPreference specifies the replica set members that can serve a read.
+//
+// More information: https://www.mongodb.com/docs/manual/core/read-preference/
+type ReadPreference struct {
+	Mode ReadMode
+
+	// Tag sets in order of preference. A member is eligible for a read if the
+	// member's tags include all of the tags in the first tag set that matches
+	// any member. An empty tag set matches all members. Tag sets cannot be
+	// used with ReadPrimary.
+	TagSets []map[string]string
+
+	// Secondaries with an estimated replication lag greater than MaxStaleness
+	// are not eligible for reads. If zero, then replication lag is not
+	// considered. The minimum value is 90 seconds.
+	MaxStaleness time.Duration
+}
+
+// minMaxStaleness is the smallest allowed value of ReadPreference.MaxStaleness.
+const minMaxStaleness = 90 * time.Second
+
+// document returns the $readPreference document for pref.
+func (pref *ReadPreference) document() D {
+	doc := D{{"mode", pref.Mode.String()}}
+	if len(pref.TagSets) > 0 {
+		doc.Append("tags", pref.TagSets)
+	}
+	if pref.MaxStaleness > 0 {
+		doc.Append("maxStalenessSeconds", int(pref.MaxStaleness/time.Second))
+	}
+	return doc
+}
+
+// ReplicaSetOptions specifies options for DialReplicaSet.
+type ReplicaSetOptions struct {
+	// Name of the replica set. If set, then members that report a different
+	// replica set name are not used.
+	SetName string
+
+	// Read preference for queries that do not specify a read preference in
+	// FindOptions. The default is ReadPrimary.
+	ReadPreference ReadPreference
+
+	// Size of the latency window for selecting among eligible members. The
+	// default is 15 milliseconds.
+	LocalThreshold time.Duration
+
+	// Interval between checks of the replica set members. The default is 10
+	// seconds.
+	HeartbeatInterval time.Duration
+
+	// Dial connects to the member at addr. The default is Dial.
+	Dial func(addr string) (Conn, error)
+}
+
+type member struct {
+	addr       string
+	conn       Conn
+	info       *ServerInfo   // nil if member is not available
+	rtt        time.Duration // average round trip time
+	lastUpdate time.Time     // time of last successful check
+}
+
+type replicaSetConn struct {
+	options     ReplicaSetOptions
+	members     []*member
+	lastRefresh time.Time
+	err         error
+}
+
+// DialReplicaSet connects to the replica set with the given seed members. The
+// connection discovers the other members of the replica set from the seeds
+// and checks the members every HeartbeatInterval. Update, Insert and Remove
+// are sent to the primary. Find selects a member using the read preference
+// in FindOptions or the default read preference in options. Commands run on
+// the primary unless the FindOptions specify a read preference.
+//
+// The options argument can be nil.
+func DialReplicaSet(seeds []string, options *ReplicaSetOptions) (Conn, error) {
+	c := &replicaSetConn{}
+	if options != nil {
+		c.options = *options
+	}
+	if c.options.LocalThreshold == 0 {
+		c.options.LocalThreshold = 15 * time.Millisecond
+	}
+	if c.options.HeartbeatInterval == 0 {
+		c.options.HeartbeatInterval = 10 * time.Second
+	}
+	if c.options.Dial == nil {
+		c.options.Dial = Dial
+	}
+	for _, addr := range seeds {
+		c.add(addr)
+	}
+	c.refresh()
+	for _, m := range c.members {
+		if m.info != nil {
+			return c, nil
+		}
+	}
+	c.Close()
+	return nil, errors.New("mongo: no replica set member is available")
+}
+
+// add adds the member at addr to the replica set if the member is not already
+// present.
+func (c *replicaSetConn) add(addr string) {
+	addr = hostPort(addr)
+	for _, m := range c.members {
+		if m.addr == addr {
+			return
+		}
+	}
+	c.members = append(c.members, &member{addr: addr})
+}
+
+// refresh checks all members and updates the member list from the hosts
+// reported by the members.
+func (c *replicaSetConn) refresh() {
+	c.lastRefresh = time.Now()
+	for i := 0; i < len(c.members); i++ {
+		m := c.members[i]
+		c.check(m)
+		if m.info != nil {
+			for _, addr := range m.info.Hosts {
+				c.add(addr)
+			}
+			for _, addr := range m.info.Passives {
+				c.add(addr)
+			}
+			if m.info.Primary != "" {
+				c.add(m.info.Primary)
+			}
+		}
+	}
+
+	// Remove members that are not known to the primary.
+	p := primaryMember(c.members)
+	if p == nil {
+		return
+	}
+	known := map[string]bool{}
+	for _, addr := range p.info.Hosts {
+		known[hostPort(addr)] = true
+	}
+	for _, addr := range p.info.Passives {
+		known[hostPort(addr)] = true
+	}
+	members := c.members[:0]
+	for _, m := range c.members {
+		if m == p || known[m.addr] {
+			members = append(members, m)
+		} else if m.conn != nil {
+			m.conn.Close()
+		}
+	}
+	c.members = members
+}
+
+// check runs the isMaster command on member m to update the member's state.
+func (c *replicaSetConn) check(m *member) {
+	if m.conn == nil {
+		conn, err := c.options.Dial(m.addr)
+		if err != nil {
+			m.info = nil
+			return
+		}
+		m.conn = conn
+	}
+	var r struct {
+		CommandResponse
+		ServerInfo
+	}
+	start := time.Now()
+	err := runInternal(m.conn, "admin", D{{"isMaster", 1}}, runFindOptions, &r)
+	rtt := time.Since(start)
+	if err == nil {
+		err = r.CommandResponse.Err()
+	}
+	if err == nil && c.options.SetName != "" && r.SetName != c.options.SetName {
+		err = errors.New("mongo: replica set name mismatch")
+	}
+	if err != nil {
+		c.fail(m)
+		return
+	}
+	info := r.ServerInfo
+	m.info = &info
+	m.lastUpdate = time.Now()
+	if m.rtt == 0 {
+		m.rtt = rtt
+	} else {
+		m.rtt = (4*m.rtt + rtt) / 5
+	}
+}
+
+// fail closes the connection to member m and marks the member as
+// unavailable. The members are checked again before the next operation.
+func (c *replicaSetConn) fail(m *member) {
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn = nil
+	}
+	m.info = nil
+	c.lastRefresh = time.Time{}
+}
+
+// selectMember returns the member for an operation with read preference
+// pref.
+func (c *replicaSetConn) selectMember(pref *ReadPreference) (*member, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if pref.MaxStaleness > 0 && (pref.MaxStaleness < minMaxStaleness || pref.MaxStaleness < c.options.HeartbeatInterval+10*time.Second) {
+		return nil, errors.New("mongo: read preference max staleness is too small")
+	}
+	if pref.Mode == ReadPrimary && len(pref.TagSets) > 0 {
+		return nil, errors.New("mongo: read preference tag sets cannot be used with primary mode")
+	}
+	refreshed := false
+	if time.Since(c.lastRefresh) >= c.options.HeartbeatInterval {
+		c.refresh()
+		refreshed = true
+	}
+	m := pref.selectMember(c.members, c.options.HeartbeatInterval, c.options.LocalThreshold)
+	if m == nil && !refreshed {
+		c.refresh()
+		m = pref.selectMember(c.members, c.options.HeartbeatInterval, c.options.LocalThreshold)
+	}
+	if m == nil {
+		if pref.Mode == ReadPrimary {
+			return nil, errors.New("mongo: no replica set primary is available")
+		}
+		return nil, errors.New("mongo: no replica set member matches read preference " + pref.Mode.String())
+	}
+	return m, nil
+}
+
+// done handles the result of an operation on member m.
+func (c *replicaSetConn) done(m *member, err error) error {
+	if err != nil && m.conn != nil && m.conn.Err() != nil {
+		c.fail(m)
+	}
+	return err
+}
+
+func (c *replicaSetConn) Close() error {
+	for _, m := range c.members {
+		if m.conn != nil {
+			m.conn.Close()
+			m.conn = nil
+		}
+	}
+	if c.err == nil {
+		c.err = errors.New("mongo: connection closed")
+	}
+	return nil
+}
+
+// Err returns an error if the connection is closed. Errors on the connections
+// to individual members are not permanent errors for the replica set
+// connection.
+func (c *replicaSetConn) Err() error {
+	return c.err
+}
+
+// ServerInfo returns information about the primary or nil if there is no
+// primary.
+func (c *replicaSetConn) ServerInfo() *ServerInfo {
+	if p := primaryMember(c.members); p != nil {
+		return p.info
+	}
+	return nil
+}
+
+var primaryPreference = &ReadPreference{Mode: ReadPrimary}
+
+func (c *replicaSetConn) Update(namespace string, selector, update interface{}, options *UpdateOptions) error {
+	return c.UpdateContext(context.Background(), namespace, selector, update, options)
+}
+
+func (c *replicaSetConn) UpdateContext(ctx context.Context, namespace string, selector, update interface{}, options *UpdateOptions) error {
+	m, err := c.selectMember(primaryPreference)
+	if err != nil {
+		return err
+	}
+	return c.done(m, updateContext(ctx, m.conn, namespace, selector, update, options))
+}
+
+func (c *replicaSetConn) Insert(namespace string, options *InsertOptions, documents ...interface{}) error {
+	return c.InsertContext(context.Background(), namespace, options, documents...)
+}
+
+func (c *replicaSetConn) InsertContext(ctx context.Context, namespace string, options *InsertOptions, documents ...interface{}) error {
+	m, err := c.selectMember(primaryPreference)
+	if err != nil {
+		return err
+	}
+	return c.done(m, insertContext(ctx, m.conn, namespace, options, documents...))
+}
+
+func (c *replicaSetConn) Remove(namespace string, selector interface{}, options *RemoveOptions) error {
+	return c.RemoveContext(context.Background(), namespace, selector, options)
+}
+
+func (c *replicaSetConn) RemoveContext(ctx context.Context, namespace string, selector interface{}, options *RemoveOptions) error {
+	m, err := c.selectMember(primaryPreference)
+	if err != nil {
+		return err
+	}
+	return c.done(m, removeContext(ctx, m.conn, namespace, selector, options))
+}
+
+func (c *replicaSetConn) Find(namespace string, query interface{}, options *FindOptions) (Cursor, error) {
+	return c.FindContext(context.Background(), namespace, query, options)
+}
+
+func (c *replicaSetConn) FindContext(ctx context.Context, namespace string, query interface{}, options *FindOptions) (Cursor, error) {
+	pref := primaryPreference
+	switch {
+	case options != nil && options.ReadPreference != nil:
+		pref = options.ReadPreference
+	case options != nil && options.SlaveOk:
+		pref = &ReadPreference{Mode: ReadSecondaryPreferred}
+	default:
+		if _, cname := SplitNamespace(namespace); cname != "$cmd" {
+			pref = &c.options.ReadPreference
+		}
+	}
+	m, err := c.selectMember(pref)
+	if err != nil {
+		return nil, err
+	}
+	if options == nil || options.ReadPreference != pref {
+		var o FindOptions
+		if options != nil {
+			o = *options
+		}
+		o.ReadPreference = pref
+		options = &o
+	}
+	r, err := findContext(ctx, m.conn, namespace, query, options)
+	return r, c.done(m, err)
+}
+
+// primaryMember returns the available primary in members or nil.
+func primaryMember(members []*member) *member {
+	for _, m := range members {
+		if m.info != nil && m.info.IsMaster {
+			return m
+		}
+	}
+	return nil
+}
+
+// selectMember returns a member from members that matches the read preference
+// or nil if there is no matching member.
+func (pref *ReadPreference) selectMember(members []*member, heartbeat, threshold time.Duration) *member {
+	primary := primaryMember(members)
+	var secondaries []*member
+	for _, m := range members {
+		if m.info != nil && m.info.Secondary {
+			secondaries = append(secondaries, m)
+		}
+	}
+	switch pref.Mode {
+	case ReadPrimary:
+		return primary
+	case ReadPrimaryPreferred:
+		if primary != nil {
+			return primary
+		}
+		return pref.pick(secondaries, primary, secondaries, heartbeat, threshold)
+	case ReadSecondary:
+		return pref.pick(secondaries, primary, secondaries, heartbeat, threshold)
+	case ReadSecondaryPreferred:
+		if m := pref.pick(secondaries, primary, secondaries, heartbeat, threshold); m != nil {
+			return m
+		}
+		return primary
+	case ReadNearest:
+		candidates := secondaries
+		if primary != nil {
+			candidates = append(candidates, primary)
+		}
+		return pref.pick(candidates, primary, secondaries, heartbeat, threshold)
+	}
+	return nil
+}
+
+// pick filters candidates by staleness, tag sets and latency and returns a
+// random member from the result.
+func (pref *ReadPreference) pick(candidates []*member, primary *member, secondaries []*member, heartbeat, threshold time.Duration) *member {
+	// Staleness
+	if pref.MaxStaleness > 0 {
+		var fresh []*member
+		for _, m := range candidates {
+			if m == primary || staleness(m, primary, secondaries, heartbeat) <= pref.MaxStaleness {
+				fresh = append(fresh, m)
+			}
+		}
+		candidates = fresh
+	}
+
+	// Tag sets
+	if len(pref.TagSets) > 0 {
+		var tagged []*member
+		for _, tags := range pref.TagSets {
+			for _, m := range candidates {
+				if hasTags(m.info.Tags, tags) {
+					tagged = append(tagged, m)
+				}
+			}
+			if len(tagged) > 0 {
+				break
+			}
+		}
+		candidates = tagged
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// Latency window
+	min := candidates[0].rtt
+	for _, m := range candidates[1:] {
+		if m.rtt < min {
+			min = m.rtt
+		}
+	}
+	var near []*member
+	for _, m := range candidates {
+		if m.rtt <= min+threshold {
+			near = append(near, m)
+		}
+	}
+	return near[rand.Intn(len(near))]
+}
+
+// staleness returns the estimated replication lag of secondary m.
+func staleness(m, primary *member, secondaries []*member, heartbeat time.Duration) time.Duration {
+	if primary != nil {
+		return m.lastUpdate.Sub(m.info.LastWrite.LastWriteDate) -
+			primary.lastUpdate.Sub(primary.info.LastWrite.LastWriteDate) + heartbeat
+	}
+	var max time.Time
+	for _, s := range secondaries {
+		if s.info.LastWrite.LastWriteDate.After(max) {
+			max = s.info.LastWrite.LastWriteDate
+		}
+	}
+	return max.Sub(m.info.LastWrite.LastWriteDate) + heartbeat
+}
+
+// hasTags returns true if memberTags contains all of tags.
+func hasTags(memberTags, tags map[string]string) bool {
+	for k, v := range tags {
+		if memberTags[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testMembers() []*member {
+	now := time.Now()
+	newMember := func(addr string, primary bool, rtt time.Duration, lag time.Duration, tags map[string]string) *member {
+		info := &ServerInfo{IsMaster: primary, Secondary: !primary, Tags: tags}
+		info.LastWrite.LastWriteDate = now.Add(-lag)
+		return &member{addr: addr, info: info, rtt: rtt, lastUpdate: now}
+	}
+	return []*member{
+		newMember("p", true, 50*time.Millisecond, 0, map[string]string{"dc": "east"}),
+		newMember("s1", false, 10*time.Millisecond, 0, map[string]string{"dc": "west"}),
+		newMember("s2", false, 100*time.Millisecond, 5*time.Minute, map[string]string{"dc": "east"}),
+	}
+}
+
+var selectMemberTests = []struct {
+	pref     ReadPreference
+	primary  bool // primary available
+	expected string
+}{
+	{ReadPreference{Mode: ReadPrimary}, true, "p"},
+	{ReadPreference{Mode: ReadPrimary}, false, ""},
+	{ReadPreference{Mode: ReadPrimaryPreferred}, true, "p"},
+	{ReadPreference{Mode: ReadPrimaryPreferred, TagSets: []map[string]string{{"dc": "west"}}}, false, "s1"},
+	{ReadPreference{Mode: ReadSecondary, TagSets: []map[string]string{{"dc": "east"}}}, true, "s2"},
+	{ReadPreference{Mode: ReadSecondary, TagSets: []map[string]string{{"dc": "north"}, {"dc": "east"}}}, true, "s2"},
+	{ReadPreference{Mode: ReadSecondary, TagSets: []map[string]string{{"dc": "north"}}}, true, ""},
+	{ReadPreference{Mode: ReadSecondary, TagSets: []map[string]string{{"dc": "east"}}, MaxStaleness: 2 * time.Minute}, true, ""},
+	{ReadPreference{Mode: ReadSecondary, MaxStaleness: 2 * time.Minute}, false, "s1"},
+	{ReadPreference{Mode: ReadSecondary}, true, "s1"},
+	{ReadPreference{Mode: ReadSecondaryPreferred, TagSets: []map[string]string{{"dc": "north"}}}, true, "p"},
+	{ReadPreference{Mode: ReadNearest}, true, "s1"},
+	{ReadPreference{Mode: ReadNearest, TagSets: []map[string]string{{"dc": "east"}}}, true, "p"},
+}
+
+func TestSelectMember(t *testing.T) {
+	for _, tt := range selectMemberTests {
+		members := testMembers()
+		if !tt.primary {
+			members[0].info = nil
+		}
+		m := tt.pref.selectMember(members, 10*time.Second, 15*time.Millisecond)
+		addr := ""
+		if m != nil {
+			addr = m.addr
+		}
+		if addr != tt.expected {
+			t.Errorf("pref=%+v, primary=%v, selected %q, want %q", tt.pref, tt.primary, addr, tt.expected)
+		}
+	}
+}
+
+func TestDialReplicaSet(t *testing.T) {
+	var ops []string
+	handler := func(addr string, info M) msgHandler {
+		return func(cmd M, sequences map[string][][]byte) interface{} {
+			switch {
+			case cmd["isMaster"] != nil:
+				return info
+			case cmd["insert"] != nil:
+				ops = append(ops, "insert "+addr)
+				return M{"ok": 1, "n": 1}
+			case cmd["find"] != nil:
+				ops = append(ops, "find "+addr)
+				return M{"ok": 1, "cursor": M{"id": int64(0), "ns": "db.coll", "firstBatch": A{M{"x": 0}}}}
+			}
+			return M{"ok": 0, "errmsg": "unexpected command"}
+		}
+	}
+	hosts := A{"a:27017", "b:27017"}
+	handlers := map[string]msgHandler{
+		"a:27017": handler("a", M{"ok": 1, "setName": "rs", "ismaster": true, "hosts": hosts}),
+		"b:27017": handler("b", M{"ok": 1, "setName": "rs", "secondary": true, "hosts": hosts}),
+	}
+	dial := func(addr string) (Conn, error) {
+		h := handlers[addr]
+		if h == nil {
+			return nil, errors.New("unknown host")
+		}
+		return newMsgConnection(h), nil
+	}
+
+	if _, err := DialReplicaSet([]string{"a"}, &ReplicaSetOptions{SetName: "other", Dial: dial}); err == nil {
+		t.Fatal("dial with wrong set name succeeded")
+	}
+
+	c, err := DialReplicaSet([]string{"a"}, &ReplicaSetOptions{SetName: "rs", Dial: dial})
+	if err != nil {
+		t.Fatal("dial", err)
+	}
+	defer c.Close()
+
+	if err := c.Insert("db.coll", nil, M{"x": 0}); err != nil {
+		t.Fatal("insert", err)
+	}
+	for _, mode := range []ReadMode{ReadPrimary, ReadSecondary} {
+		var m M
+		if err := (Collection{Conn: c, Namespace: "db.coll"}).Find(nil).ReadPreference(&ReadPreference{Mode: mode}).One(&m); err != nil {
+			t.Fatal("find", err)
+		}
+	}
+	expected := []string{"insert a", "find a", "find b"}
+	if len(ops) != len(expected) {
+		t.Fatalf("ops=%v, want %v", ops, expected)
+	}
+	for i := range ops {
+		if ops[i] != expected[i] {
+			t.Fatalf("ops=%v, want %v", ops, expected)
+		}
+	}
+	if info := serverInfo(c); info == nil || info.SetName != "rs" || !info.IsMaster {
+		t.Errorf("info=%+v, want primary info", info)
+	}
+}
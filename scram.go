@@ -0,0 +1,453 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/bidi"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Authentication mechanisms.
+const (
+	mechanismSCRAMSHA1   = "SCRAM-SHA-1"
+	mechanismSCRAMSHA256 = "SCRAM-SHA-256"
+	mechanismMongoDBCR   = "MONGODB-CR"
+)
+
+// saslClient is the client side of a SASL mechanism.
+type saslClient interface {
+	// start returns the mechanism name and the initial client response.
+	start() (mechanism string, payload []byte, err error)
+
+	// next returns the response to challenge from the server.
+	next(challenge []byte) ([]byte, error)
+
+	// completed returns true when the client has verified the server.
+	completed() bool
+}
+
+// saslConversation authenticates conn to database dbname using the
+// saslStart and saslContinue commands.
+func saslConversation(conn Conn, dbname string, client saslClient) error {
+	mechanism, payload, err := client.start()
+	if err != nil {
+		return err
+	}
+	cmd := D{{"saslStart", 1}, {"mechanism", mechanism}, {"payload", payload}, {"autoAuthorize", 1}}
+	for {
+		var r struct {
+			CommandResponse
+			ConversationId interface{} `bson:"conversationId"`
+			Done           bool        `bson:"done"`
+			Payload        []byte      `bson:"payload"`
+		}
+		if err := runInternal(conn, dbname, cmd, runFindOptions, &r); err != nil {
+			return err
+		}
+		if err := r.Err(); err != nil {
+			return err
+		}
+		if r.Done && client.completed() {
+			return nil
+		}
+		payload, err = client.next(r.Payload)
+		if err != nil {
+			return err
+		}
+		if payload == nil {
+			payload = []byte{}
+		}
+		if r.Done {
+			if !client.completed() {
+				return errors.New("mongo: SASL conversation ended before authentication completed")
+			}
+			return nil
+		}
+		cmd = D{{"saslContinue", 1}, {"conversationId", r.ConversationId}, {"payload", payload}}
+	}
+}
+
+// authenticate authenticates conn to database dbname with the given mechanism
+// and credentials. If mechanism is empty, then the mechanism is negotiated
+// with the server.
+func authenticate(conn Conn, dbname, mechanism, name, password string) error {
+	if mechanism == "" {
+		var err error
+		if mechanism, err = negotiateMechanism(conn, dbname, name); err != nil {
+			return err
+		}
+	}
+	switch mechanism {
+	case mechanismSCRAMSHA1, mechanismSCRAMSHA256:
+		client, err := newSCRAMClient(mechanism, name, password)
+		if err != nil {
+			return err
+		}
+		return saslConversation(conn, dbname, client)
+	case mechanismMongoDBCR:
+		return authenticateMongoDBCR(conn, dbname, name, password)
+	}
+	return errors.New("mongo: unsupported authentication mechanism " + mechanism)
+}
+
+// negotiateMechanism returns the strongest password mechanism supported by
+// the server for the user.
+func negotiateMechanism(conn Conn, dbname, name string) (string, error) {
+	var r struct {
+		CommandResponse
+		MaxWireVersion     int      `bson:"maxWireVersion"`
+		SaslSupportedMechs []string `bson:"saslSupportedMechs"`
+	}
+	cmd := D{{"isMaster", 1}, {"saslSupportedMechs", dbname + "." + name}}
+	if err := runInternal(conn, "admin", cmd, runFindOptions, &r); err != nil {
+		return "", err
+	}
+	if err := r.Err(); err != nil {
+		return "", err
+	}
+	if r.SaslSupportedMechs != nil {
+		for _, m := range r.SaslSupportedMechs {
+			if m == mechanismSCRAMSHA256 {
+				return m, nil
+			}
+		}
+		return mechanismSCRAMSHA1, nil
+	}
+	// Servers before MongoDB 3.0 do not support SCRAM.
+	if r.MaxWireVersion < 3 {
+		return mechanismMongoDBCR, nil
+	}
+	return mechanismSCRAMSHA1, nil
+}
+
+// scramClient implements SCRAM-SHA-1 and SCRAM-SHA-256 as specified in RFC
+// 5802 and RFC 7677.
+type scramClient struct {
+	mechanism string
+	newHash   func() hash.Hash
+	name      string
+	password  string
+	nonce     string
+
+	clientFirstBare string
+	serverSignature []byte
+	step            int
+	verified        bool
+}
+
+func newSCRAMClient(mechanism, name, password string) (*scramClient, error) {
+	c := &scramClient{mechanism: mechanism, name: name}
+	switch mechanism {
+	case mechanismSCRAMSHA1:
+		c.newHash = sha1.New
+		c.password = passwordDigest(name, password)
+	case mechanismSCRAMSHA256:
+		c.newHash = sha256.New
+		var err error
+		if c.password, err = saslPrep(password); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("mongo: unknown SCRAM mechanism " + mechanism)
+	}
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	c.nonce = base64.StdEncoding.EncodeToString(b[:])
+	return c, nil
+}
+
+var scramNameEscaper = strings.NewReplacer("=", "=3D", ",", "=2C")
+
+func (c *scramClient) start() (string, []byte, error) {
+	c.clientFirstBare = "n=" + scramNameEscaper.Replace(c.name) + ",r=" + c.nonce
+	return c.mechanism, []byte("n,," + c.clientFirstBare), nil
+}
+
+func (c *scramClient) completed() bool {
+	return c.verified
+}
+
+func (c *scramClient) next(challenge []byte) ([]byte, error) {
+	c.step += 1
+	switch c.step {
+	case 1:
+		return c.clientFinal(challenge)
+	case 2:
+		return nil, c.verifyServer(challenge)
+	}
+	return nil, errors.New("mongo: unexpected SCRAM challenge")
+}
+
+// scramAttributes parses the attributes in a SCRAM message.
+func scramAttributes(p []byte) map[byte]string {
+	m := make(map[byte]string)
+	for _, a := range strings.Split(string(p), ",") {
+		if len(a) >= 2 && a[1] == '=' {
+			m[a[0]] = a[2:]
+		}
+	}
+	return m
+}
+
+func (c *scramClient) clientFinal(serverFirst []byte) ([]byte, error) {
+	attrs := scramAttributes(serverFirst)
+	if e, ok := attrs['e']; ok {
+		return nil, errors.New("mongo: SCRAM error: " + e)
+	}
+	nonce := attrs['r']
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return nil, errors.New("mongo: invalid SCRAM server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs['s'])
+	if err != nil || len(salt) == 0 {
+		return nil, errors.New("mongo: invalid SCRAM salt")
+	}
+	iterations, err := strconv.Atoi(attrs['i'])
+	if err != nil || iterations < 4096 {
+		return nil, errors.New("mongo: invalid SCRAM iteration count")
+	}
+
+	keys := c.keys(salt, iterations)
+
+	clientFinalWithoutProof := "c=biws,r=" + nonce
+	authMessage := c.clientFirstBare + "," + string(serverFirst) + "," + clientFinalWithoutProof
+
+	h := c.newHash()
+	h.Write(keys.clientKey)
+	storedKey := h.Sum(nil)
+	clientSignature := c.hmac(storedKey, authMessage)
+	proof := make([]byte, len(keys.clientKey))
+	for i := range proof {
+		proof[i] = keys.clientKey[i] ^ clientSignature[i]
+	}
+	c.serverSignature = c.hmac(keys.serverKey, authMessage)
+
+	return []byte(clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+func (c *scramClient) verifyServer(serverFinal []byte) error {
+	attrs := scramAttributes(serverFinal)
+	if e, ok := attrs['e']; ok {
+		return errors.New("mongo: SCRAM error: " + e)
+	}
+	v, err := base64.StdEncoding.DecodeString(attrs['v'])
+	if err != nil || subtle.ConstantTimeCompare(v, c.serverSignature) != 1 {
+		return errors.New("mongo: invalid SCRAM server signature")
+	}
+	c.verified = true
+	return nil
+}
+
+func (c *scramClient) hmac(key []byte, s string) []byte {
+	m := hmac.New(c.newHash, key)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
+
+type scramKeys struct {
+	clientKey []byte
+	serverKey []byte
+}
+
+// scramCacheKey is a SHA-256 digest of the mechanism, password, salt and
+// iteration count. The cache is keyed by a digest so that the cache does not
+// retain passwords.
+type scramCacheKey [sha256.Size]byte
+
+func newSCRAMCacheKey(mechanism, password string, salt []byte, iterations int) scramCacheKey {
+	h := sha256.New()
+	var n [8]byte
+	for _, p := range [][]byte{[]byte(mechanism), []byte(password), salt} {
+		binary.BigEndian.PutUint64(n[:], uint64(len(p)))
+		h.Write(n[:])
+		h.Write(p)
+	}
+	binary.BigEndian.PutUint64(n[:], uint64(iterations))
+	h.Write(n[:])
+	var k scramCacheKey
+	h.Sum(k[:0])
+	return k
+}
+
+// scramCache caches the keys derived from the password so that new
+// connections do not repeat the expensive PBKDF2 computation.
+var scramCache = struct {
+	sync.Mutex
+	m map[scramCacheKey]scramKeys
+}{m: make(map[scramCacheKey]scramKeys)}
+
+const maxSCRAMCacheSize = 128
+
+// scramHi computes the function Hi from RFC 5802. Hi is PBKDF2 with an output
+// length equal to the hash size.
+func scramHi(newHash func() hash.Hash, password string, salt []byte, iterations int) []byte {
+	m := hmac.New(newHash, []byte(password))
+	m.Write(salt)
+	m.Write([]byte{0, 0, 0, 1})
+	u := m.Sum(nil)
+	result := make([]byte, len(u))
+	copy(result, u)
+	for i := 1; i < iterations; i++ {
+		m.Reset()
+		m.Write(u)
+		u = m.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+// keys returns the client and server keys for the password.
+func (c *scramClient) keys(salt []byte, iterations int) scramKeys {
+	k := newSCRAMCacheKey(c.mechanism, c.password, salt, iterations)
+	scramCache.Lock()
+	keys, ok := scramCache.m[k]
+	scramCache.Unlock()
+	if ok {
+		return keys
+	}
+
+	saltedPassword := scramHi(c.newHash, c.password, salt, iterations)
+	keys = scramKeys{
+		clientKey: c.hmac(saltedPassword, "Client Key"),
+		serverKey: c.hmac(saltedPassword, "Server Key"),
+	}
+
+	scramCache.Lock()
+	if len(scramCache.m) >= maxSCRAMCacheSize {
+		scramCache.m = make(map[scramCacheKey]scramKeys)
+	}
+	scramCache.m[k] = keys
+	scramCache.Unlock()
+	return keys
+}
+
+// saslPrep prepares a password as specified in RFC 4013.
+func saslPrep(s string) (string, error) {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 || s[i] < 0x20 || s[i] == 0x7f {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s, nil
+	}
+
+	// Mapping
+	var buf bytes.Buffer
+	for _, r := range s {
+		switch {
+		case isSASLMappedToNothing(r):
+		case r != ' ' && unicode.Is(unicode.Zs, r):
+			buf.WriteByte(' ')
+		default:
+			buf.WriteRune(r)
+		}
+	}
+
+	// Normalization
+	s = norm.NFKC.String(buf.String())
+
+	// Prohibited output and bidirectional characters
+	var hasRandAL, hasL bool
+	for _, r := range s {
+		if isSASLProhibited(r) {
+			return "", errors.New("mongo: password contains prohibited character")
+		}
+		p, _ := bidi.LookupRune(r)
+		switch p.Class() {
+		case bidi.R, bidi.AL:
+			hasRandAL = true
+		case bidi.L:
+			hasL = true
+		}
+	}
+	if hasRandAL {
+		first, _ := bidi.LookupString(s)
+		last, _ := bidi.LookupRune(lastRune(s))
+		if hasL || !isRandAL(first) || !isRandAL(last) {
+			return "", errors.New("mongo: password has invalid bidirectional text")
+		}
+	}
+	return s, nil
+}
+
+func lastRune(s string) rune {
+	var last rune
+	for _, r := range s {
+		last = r
+	}
+	return last
+}
+
+func isRandAL(p bidi.Properties) bool {
+	return p.Class() == bidi.R || p.Class() == bidi.AL
+}
+
+// isSASLMappedToNothing returns true for the characters in RFC 3454 table
+// B.1.
+func isSASLMappedToNothing(r rune) bool {
+	switch {
+	case r == 0x00AD, r == 0x034F, r == 0x1806, r == 0x2060, r == 0xFEFF:
+		return true
+	case r >= 0x180B && r <= 0x180D, r >= 0x200B && r <= 0x200D, r >= 0xFE00 && r <= 0xFE0F:
+		return true
+	}
+	return false
+}
+
+// isSASLProhibited returns true for the characters prohibited by RFC 4013
+// section 2.3.
+func isSASLProhibited(r rune) bool {
+	switch {
+	case r < 0x20, r >= 0x7F && r <= 0x9F: // control characters
+		return true
+	case r == 0x06DD, r == 0x070F, r == 0x180E, r == 0x200E, r == 0x200F, r == 0x0340, r == 0x0341:
+		return true
+	case r >= 0x200C && r <= 0x200D, r >= 0x2028 && r <= 0x202E, r >= 0x2060 && r <= 0x206F:
+		return true
+	case r >= 0xFFF9 && r <= 0xFFFD, r >= 0x2FF0 && r <= 0x2FFB, r >= 0x1D173 && r <= 0x1D17A:
+		return true
+	case r == 0xE0001, r >= 0xE0020 && r <= 0xE007F: // tagging characters
+		return true
+	case r >= 0xD800 && r <= 0xDFFF: // surrogate codes
+		return true
+	case r&0xFFFE == 0xFFFE, r >= 0xFDD0 && r <= 0xFDEF: // non-character code points
+		return true
+	case unicode.Is(unicode.Co, r): // private use
+		return true
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+var scramTests = []struct {
+	mechanism   string
+	password    string // password after digest or SASLprep
+	nonce       string
+	serverFirst string
+	clientFinal string
+	serverFinal string
+}{
+	// RFC 5802, section 5
+	{
+		mechanismSCRAMSHA1,
+		"pencil",
+		"fyko+d2lbbFgONRv9qkxdawL",
+		"r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096",
+		"c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=",
+		"v=rmF9pqV8S7suAoZWja4dJRkFsKQ=",
+	},
+	// RFC 7677, section 3
+	{
+		mechanismSCRAMSHA256,
+		"pencil",
+		"rOprNGfwEbeRWgbNEkqO",
+		"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		"v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=",
+	},
+}
+
+func TestSCRAM(t *testing.T) {
+	for _, tt := range scramTests {
+		c, err := newSCRAMClient(tt.mechanism, "user", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.password = tt.password
+		c.nonce = tt.nonce
+		if _, p, _ := c.start(); string(p) != "n,,n=user,r="+tt.nonce {
+			t.Errorf("%s: client first = %q", tt.mechanism, p)
+		}
+		p, err := c.next([]byte(tt.serverFirst))
+		if err != nil {
+			t.Errorf("%s: client final returned %v", tt.mechanism, err)
+			continue
+		}
+		if string(p) != tt.clientFinal {
+			t.Errorf("%s: client final = %q, want %q", tt.mechanism, p, tt.clientFinal)
+		}
+		if _, err := c.next([]byte(tt.serverFinal)); err != nil || !c.completed() {
+			t.Errorf("%s: server final returned %v", tt.mechanism, err)
+		}
+	}
+
+	c, _ := newSCRAMClient(mechanismSCRAMSHA256, "user", "pencil")
+	c.nonce = scramTests[1].nonce
+	c.start()
+	c.next([]byte(scramTests[1].serverFirst))
+	if _, err := c.next([]byte("v=AAAA")); err == nil || c.completed() {
+		t.Error("bad server signature accepted")
+	}
+}
+
+var saslPrepTests = []struct {
+	in, out string
+	ok      bool
+}{
+	// RFC 4013, section 3
+	{"I\u00adX", "IX", true},
+	{"user", "user", true},
+	{"USER", "USER", true},
+	{"\u00aa", "a", true},
+	{"\u2168", "IX", true},
+	{"\u0007", "", false},
+	{"\u06271", "", false},
+
+	{"a\u00a0b", "a b", true},
+	{"\u06271\u0628", "\u06271\u0628", true},
+}
+
+func TestSASLPrep(t *testing.T) {
+	for _, tt := range saslPrepTests {
+		out, err := saslPrep(tt.in)
+		if (err == nil) != tt.ok || out != tt.out {
+			t.Errorf("saslPrep(%q) = %q, %v, want %q", tt.in, out, err, tt.out)
+		}
+	}
+}
+
+func TestSCRAMHi(t *testing.T) {
+	// RFC 6070 PBKDF2 HMAC-SHA1 test vectors
+	for _, tt := range []struct {
+		iterations int
+		expected   string
+	}{
+		{1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{2, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{4096, "4b007901b765489abead49d926f721d065a429c1"},
+	} {
+		if actual := hex.EncodeToString(scramHi(sha1.New, "password", []byte("salt"), tt.iterations)); actual != tt.expected {
+			t.Errorf("scramHi(%d) = %s, want %s", tt.iterations, actual, tt.expected)
+		}
+	}
+}
+
+// scramServer returns a handler that implements the server side of
+// SCRAM-SHA-256 for user "user" with password "pencil".
+func scramServer(t *testing.T, conversations *int) msgHandler {
+	salt := []byte("0123456789abcdef")
+	c := &scramClient{mechanism: mechanismSCRAMSHA256, newHash: sha256.New, password: "pencil"}
+	keys := c.keys(salt, 4096)
+	var clientFirstBare, serverFirst string
+	return func(cmd M, sequences map[string][][]byte) interface{} {
+		switch {
+		case cmd["isMaster"] != nil:
+			if cmd["saslSupportedMechs"] != "admin.user" {
+				return M{"ok": 0, "errmsg": "bad saslSupportedMechs"}
+			}
+			return M{"ok": 1, "maxWireVersion": 17, "saslSupportedMechs": A{"SCRAM-SHA-1", "SCRAM-SHA-256"}}
+		case cmd["saslStart"] != nil:
+			*conversations += 1
+			if cmd["mechanism"] != mechanismSCRAMSHA256 {
+				return M{"ok": 0, "errmsg": "bad mechanism"}
+			}
+			clientFirstBare = strings.TrimPrefix(string(cmd["payload"].([]byte)), "n,,")
+			serverFirst = "r=" + scramAttributes([]byte(clientFirstBare))['r'] + "server," +
+				"s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+			return M{"ok": 1, "conversationId": 1, "done": false, "payload": []byte(serverFirst)}
+		case cmd["saslContinue"] != nil:
+			payload := string(cmd["payload"].([]byte))
+			if payload == "" {
+				return M{"ok": 1, "conversationId": 1, "done": true, "payload": []byte{}}
+			}
+			clientFinalWithoutProof := payload[:strings.Index(payload, ",p=")]
+			authMessage := clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof
+			h := sha256.New()
+			h.Write(keys.clientKey)
+			signature := c.hmac(h.Sum(nil), authMessage)
+			proof := make([]byte, len(signature))
+			for i := range proof {
+				proof[i] = keys.clientKey[i] ^ signature[i]
+			}
+			if scramAttributes([]byte(payload))['p'] != base64.StdEncoding.EncodeToString(proof) {
+				return M{"ok": 0, "errmsg": "Authentication failed."}
+			}
+			v := base64.StdEncoding.EncodeToString(c.hmac(keys.serverKey, authMessage))
+			return M{"ok": 1, "conversationId": 1, "done": false, "payload": []byte("v=" + v)}
+		}
+		return M{"ok": 0, "errmsg": "unexpected command"}
+	}
+}
+
+func TestAuthenticateSCRAM(t *testing.T) {
+	conversations := 0
+	conn := newMsgConnection(scramServer(t, &conversations))
+	defer conn.Close()
+	db := Database{Conn: conn, Name: "admin"}
+	if err := db.Authenticate("user", "pencil"); err != nil {
+		t.Fatal("authenticate", err)
+	}
+	if err := db.Authenticate("user", "wrong"); err == nil {
+		t.Fatal("authenticate with wrong password succeeded")
+	}
+	if conversations != 2 {
+		t.Errorf("conversations=%d, want 2", conversations)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCertificate returns a self-signed certificate for 127.0.0.1 that can
+// be used by servers and clients, and the PEM encoding of the certificate and
+// key.
+func newTestCertificate(t *testing.T) (tls.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	p = append(p, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...)
+	cert, err := tls.X509KeyPair(p, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, p
+}
+
+// serveTLS starts a fake TLS server that requires a client certificate signed
+// by cert and returns the server address.
+func serveTLS(t *testing.T, cert tls.Certificate) string {
+	pool := x509.NewCertPool()
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	pool.AddCert(leaf)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveMsg(conn, func(cmd M, sequences map[string][][]byte) interface{} {
+				return M{"ok": 1, "maxWireVersion": 17}
+			})
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestDialTLS(t *testing.T) {
+	cert, _ := newTestCertificate(t)
+	addr := serveTLS(t, cert)
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	if c, err := DialWithOptions(addr, &DialOptions{TLSConfig: &tls.Config{RootCAs: roots}}); err == nil {
+		c.Close()
+		t.Fatal("dial without client certificate succeeded")
+	}
+
+	c, err := DialWithOptions(addr, &DialOptions{
+		Dialer:       &net.Dialer{Timeout: time.Second},
+		TLSConfig:    &tls.Config{RootCAs: roots},
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal("dial", err)
+	}
+	defer c.Close()
+	if info := serverInfo(c); info == nil || info.MaxWireVersion != 17 {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+func TestDialURITLS(t *testing.T) {
+	cert, p := newTestCertificate(t)
+	addr := serveTLS(t, cert)
+	name := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(name, p, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := DialURI("mongodb://" + addr + "/?tlsCAFile=" + name + "&tlsCertificateKeyFile=" + name + "&connectTimeoutMS=1000")
+	if err != nil {
+		t.Fatal("dial", err)
+	}
+	c.Close()
+
+	u, err := ParseURI("mongodb://localhost:" + addr[len("127.0.0.1:"):] + "/?tlsCAFile=" + name + "&tlsCertificateKeyFile=" + name + "&tlsAllowInvalidHostnames=true")
+	if err != nil {
+		t.Fatal("parse", err)
+	}
+	c, err = u.Dial()
+	if err != nil {
+		t.Fatal("dial with invalid host name", err)
+	}
+	c.Close()
+}
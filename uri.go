@@ -0,0 +1,566 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// URI is a parsed MongoDB connection string.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/connection-string/
+type URI struct {
+	// Server addresses in host:port format.
+	Hosts []string
+
+	// Credentials.
+	Username string
+	Password string
+
+	// Database from the path of the connection string.
+	Database string
+
+	// Database for authentication. The default is Database if set, otherwise
+	// "admin".
+	AuthSource string
+
+	// Authentication mechanism.
+	AuthMechanism string
+
+	// Name of the replica set.
+	ReplicaSet string
+
+	// Read preference for queries. Nil if not specified.
+	ReadPreference *ReadPreference
+
+	// Write concern: the w, wtimeoutMS and journal options.
+	W        string
+	WTimeout time.Duration
+	Journal  bool
+
+	// Timeout for establishing a connection to a server.
+	ConnectTimeout time.Duration
+
+	// Use TLS for connections to the servers. The tlsCAFile and
+	// tlsCertificateKeyFile options imply tls=true.
+	TLS bool
+
+	// TLS options: the tlsCAFile, tlsCertificateKeyFile, tlsInsecure and
+	// tlsAllowInvalidHostnames options.
+	TLSCAFile                string
+	TLSCertificateKeyFile    string
+	TLSInsecure              bool
+	TLSAllowInvalidHostnames bool
+
+	// Base TLS configuration. The application can set this field after
+	// parsing the connection string. The TLS options from the connection
+	// string are applied to a copy of the configuration.
+	TLSConfig *tls.Config
+
+	// Maximum number of connections in a pool.
+	MaxPoolSize int
+
+	// Close pooled connections after remaining idle for this duration.
+	MaxIdleTime time.Duration
+
+	// All options in the connection string. The keys are lowercase.
+	Options map[string][]string
+}
+
+// Resolver looks up the DNS records for mongodb+srv connection strings. The
+// *net.Resolver type implements this interface.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// ParseURI parses a connection string with the format:
+//
+//	mongodb://[username:password@]host1[:port1][,host2[:port2],...][/[database][?options]]
+//
+// or:
+//
+//	mongodb+srv://[username:password@]host[/[database][?options]]
+//
+// Hosts for the mongodb+srv scheme are looked up with net.DefaultResolver.
+func ParseURI(s string) (*URI, error) {
+	return ParseURIWithResolver(s, net.DefaultResolver)
+}
+
+// ParseURIWithResolver parses a connection string using resolver to look up
+// the hosts and options for the mongodb+srv scheme.
+func ParseURIWithResolver(s string, resolver Resolver) (*URI, error) {
+	const (
+		scheme    = "mongodb://"
+		srvScheme = "mongodb+srv://"
+	)
+	var srv bool
+	switch {
+	case strings.HasPrefix(s, scheme):
+		s = s[len(scheme):]
+	case strings.HasPrefix(s, srvScheme):
+		s = s[len(srvScheme):]
+		srv = true
+	default:
+		return nil, errors.New("mongo: connection string must start with mongodb:// or mongodb+srv://")
+	}
+
+	u := &URI{Options: make(map[string][]string)}
+
+	authority := s
+	rest := ""
+	if i := strings.IndexAny(s, "/?"); i >= 0 {
+		authority = s[:i]
+		rest = s[i:]
+	}
+
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		userinfo := authority[:i]
+		authority = authority[i+1:]
+		username, password, _ := strings.Cut(userinfo, ":")
+		var err error
+		if u.Username, err = url.PathUnescape(username); err != nil {
+			return nil, errors.New("mongo: invalid username in connection string")
+		}
+		if u.Password, err = url.PathUnescape(password); err != nil {
+			return nil, errors.New("mongo: invalid password in connection string")
+		}
+	}
+
+	if authority == "" {
+		return nil, errors.New("mongo: connection string has no hosts")
+	}
+	for _, host := range strings.Split(authority, ",") {
+		if host == "" {
+			return nil, errors.New("mongo: empty host in connection string")
+		}
+		u.Hosts = append(u.Hosts, host)
+	}
+
+	path, query, _ := strings.Cut(rest, "?")
+	if path != "" && path != "/" {
+		db, err := url.PathUnescape(path[1:])
+		if err != nil {
+			return nil, errors.New("mongo: invalid database in connection string")
+		}
+		u.Database = db
+	}
+
+	if srv {
+		if err := u.resolve(resolver); err != nil {
+			return nil, err
+		}
+	} else {
+		for i := range u.Hosts {
+			u.Hosts[i] = hostPort(u.Hosts[i])
+		}
+	}
+
+	if err := u.parseOptions(query); err != nil {
+		return nil, err
+	}
+	if u.AuthSource == "" {
+		switch {
+		case u.AuthMechanism == mechanismX509 || u.AuthMechanism == mechanismPlain:
+			u.AuthSource = "$external"
+		case u.Database != "":
+			u.AuthSource = u.Database
+		default:
+			u.AuthSource = "admin"
+		}
+	}
+	return u, nil
+}
+
+// resolve looks up the hosts and options for a mongodb+srv connection string.
+func (u *URI) resolve(resolver Resolver) error {
+	if len(u.Hosts) != 1 || strings.Contains(u.Hosts[0], ":") {
+		return errors.New("mongo: mongodb+srv connection string must have one host with no port")
+	}
+	name := u.Hosts[0]
+	parts := strings.Split(name, ".")
+	if len(parts) < 3 {
+		return errors.New("mongo: mongodb+srv host must have at least three domain name components")
+	}
+	domain := "." + strings.Join(parts[1:], ".")
+
+	ctx := context.Background()
+	_, addrs, err := resolver.LookupSRV(ctx, "mongodb", "tcp", name)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return errors.New("mongo: no SRV records for " + name)
+	}
+	u.Hosts = nil
+	for _, addr := range addrs {
+		target := strings.TrimSuffix(addr.Target, ".")
+		if !strings.HasSuffix(target, domain) {
+			return errors.New("mongo: SRV record " + target + " is not in domain " + domain[1:])
+		}
+		u.Hosts = append(u.Hosts, net.JoinHostPort(target, strconv.Itoa(int(addr.Port))))
+	}
+
+	// TLS is on by default for mongodb+srv.
+	u.TLS = true
+
+	txts, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		if e, ok := err.(*net.DNSError); ok && e.IsNotFound {
+			return nil
+		}
+		return err
+	}
+	if len(txts) > 1 {
+		return errors.New("mongo: multiple TXT records for " + name)
+	}
+	if len(txts) == 1 {
+		for _, kv := range strings.Split(txts[0], "&") {
+			key, _, _ := strings.Cut(kv, "=")
+			switch strings.ToLower(key) {
+			case "authsource", "replicaset", "loadbalanced":
+			default:
+				return errors.New("mongo: TXT record option " + key + " is not allowed")
+			}
+		}
+		if err := u.parseOptions(txts[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseOptions sets fields in u from the options in query. Options in query
+// replace options previously set in u.
+func (u *URI) parseOptions(query string) error {
+	values := make(map[string][]string)
+	for _, kv := range strings.FieldsFunc(query, func(r rune) bool { return r == '&' || r == ';' }) {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return errors.New("mongo: option " + kv + " in connection string has no value")
+		}
+		var err error
+		if key, err = url.QueryUnescape(key); err != nil {
+			return errors.New("mongo: invalid option " + kv + " in connection string")
+		}
+		if value, err = url.QueryUnescape(value); err != nil {
+			return errors.New("mongo: invalid option " + kv + " in connection string")
+		}
+		key = strings.ToLower(key)
+		values[key] = append(values[key], value)
+	}
+
+	var readPref ReadPreference
+	hasReadPref := false
+
+	// The tls option is applied after the loop because the map is not
+	// ordered and the TLS file options imply tls=true.
+	var tls []bool
+	impliedTLS := false
+
+	for key, vs := range values {
+		u.Options[key] = vs
+		value := vs[len(vs)-1]
+		var err error
+		switch key {
+		case "replicaset":
+			u.ReplicaSet = value
+		case "authsource":
+			u.AuthSource = value
+		case "authmechanism":
+			u.AuthMechanism = value
+		case "w":
+			u.W = value
+		case "wtimeoutms":
+			u.WTimeout, err = parseMilliseconds(value)
+		case "journal":
+			u.Journal, err = strconv.ParseBool(value)
+		case "connecttimeoutms":
+			u.ConnectTimeout, err = parseMilliseconds(value)
+		case "tls", "ssl":
+			var b bool
+			b, err = strconv.ParseBool(value)
+			tls = append(tls, b)
+		case "tlscafile":
+			u.TLSCAFile = value
+			impliedTLS = true
+		case "tlscertificatekeyfile":
+			u.TLSCertificateKeyFile = value
+			impliedTLS = true
+		case "tlsinsecure", "tlsallowinvalidcertificates":
+			u.TLSInsecure, err = strconv.ParseBool(value)
+		case "tlsallowinvalidhostnames":
+			u.TLSAllowInvalidHostnames, err = strconv.ParseBool(value)
+		case "maxpoolsize":
+			u.MaxPoolSize, err = strconv.Atoi(value)
+		case "maxidletimems":
+			u.MaxIdleTime, err = parseMilliseconds(value)
+		case "readpreference":
+			hasReadPref = true
+			readPref.Mode = -1
+			for i, name := range readModeNames {
+				if strings.EqualFold(name, value) {
+					readPref.Mode = ReadMode(i)
+				}
+			}
+			if readPref.Mode < 0 {
+				err = errors.New("unknown mode")
+			}
+		case "readpreferencetags":
+			hasReadPref = true
+			for _, v := range vs {
+				tags := map[string]string{}
+				for _, tag := range strings.Split(v, ",") {
+					if tag == "" {
+						continue
+					}
+					k, v, ok := strings.Cut(tag, ":")
+					if !ok {
+						return errors.New("mongo: invalid readPreferenceTags option in connection string")
+					}
+					tags[k] = v
+				}
+				readPref.TagSets = append(readPref.TagSets, tags)
+			}
+		case "maxstalenessseconds":
+			hasReadPref = true
+			var n int
+			n, err = strconv.Atoi(value)
+			if n > 0 {
+				readPref.MaxStaleness = time.Duration(n) * time.Second
+			}
+		}
+		if err != nil {
+			return errors.New("mongo: invalid value for option " + key + " in connection string")
+		}
+	}
+
+	switch {
+	case len(tls) == 2 && tls[0] != tls[1]:
+		return errors.New("mongo: conflicting tls and ssl options in connection string")
+	case len(tls) > 0 && !tls[0] && impliedTLS:
+		return errors.New("mongo: tls=false conflicts with tlsCAFile or tlsCertificateKeyFile in connection string")
+	case len(tls) > 0:
+		u.TLS = tls[0]
+	case impliedTLS:
+		u.TLS = true
+	}
+
+	if hasReadPref {
+		u.ReadPreference = &readPref
+	}
+	return nil
+}
+
+func parseMilliseconds(s string) (time.Duration, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid duration")
+	}
+	return time.Duration(n) * time.Millisecond, nil
+}
+
+// DialURI parses the connection string s and connects to the servers. See
+// the URI Dial method for details.
+func DialURI(s string) (Conn, error) {
+	u, err := ParseURI(s)
+	if err != nil {
+		return nil, err
+	}
+	return u.Dial()
+}
+
+// Dial connects to the servers in the connection string. If the connection
+// string specifies a replica set, then Dial returns a connection from
+// DialReplicaSet. Otherwise, Dial connects to the first available host. If
+// the connection string includes a username, then the connections are
+// authenticated with the credentials.
+func (u *URI) Dial() (Conn, error) {
+	dialOptions, err := u.DialOptions()
+	if err != nil {
+		return nil, err
+	}
+	dialHost := func(addr string) (Conn, error) { return u.dialHost(addr, dialOptions) }
+	if u.ReplicaSet != "" {
+		options := &ReplicaSetOptions{SetName: u.ReplicaSet, Dial: dialHost}
+		if u.ReadPreference != nil {
+			options.ReadPreference = *u.ReadPreference
+		}
+		return DialReplicaSet(u.Hosts, options)
+	}
+	for _, host := range u.Hosts {
+		var c Conn
+		c, err = dialHost(host)
+		if err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+// DialOptions returns the options for dialing the servers in the connection
+// string. The options include the authenticator for the credentials in the
+// connection string. The TLS certificate files are loaded when this method is
+// called.
+func (u *URI) DialOptions() (*DialOptions, error) {
+	options := &DialOptions{Dialer: &net.Dialer{Timeout: u.ConnectTimeout}}
+	if u.TLS {
+		if err := u.setTLSOptions(options); err != nil {
+			return nil, err
+		}
+	}
+	options.Authenticator = u.authenticator(options)
+	return options, nil
+}
+
+// setTLSOptions sets the TLS configuration and client certificates in options.
+func (u *URI) setTLSOptions(options *DialOptions) error {
+	config := &tls.Config{}
+	if u.TLSConfig != nil {
+		config = u.TLSConfig.Clone()
+	}
+	if u.TLSCAFile != "" {
+		p, err := os.ReadFile(u.TLSCAFile)
+		if err != nil {
+			return err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(p) {
+			return errors.New("mongo: no certificates found in " + u.TLSCAFile)
+		}
+	}
+	if u.TLSCertificateKeyFile != "" {
+		// The file contains the certificate and the private key.
+		p, err := os.ReadFile(u.TLSCertificateKeyFile)
+		if err != nil {
+			return err
+		}
+		cert, err := tls.X509KeyPair(p, p)
+		if err != nil {
+			return err
+		}
+		options.Certificates = []tls.Certificate{cert}
+	}
+	switch {
+	case u.TLSInsecure:
+		config.InsecureSkipVerify = true
+	case u.TLSAllowInvalidHostnames:
+		// Verify the certificate chain, but not the host name.
+		config.InsecureSkipVerify = true
+		roots := config.RootCAs
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			var leaf *x509.Certificate
+			for i, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				if i == 0 {
+					leaf = cert
+				} else {
+					opts.Intermediates.AddCert(cert)
+				}
+			}
+			if leaf == nil {
+				return errors.New("mongo: server did not present a certificate")
+			}
+			_, err := leaf.Verify(opts)
+			return err
+		}
+	}
+	options.TLSConfig = config
+	return nil
+}
+
+// dialHost connects to the server at addr and authenticates the connection.
+func (u *URI) dialHost(addr string, options *DialOptions) (Conn, error) {
+	c, err := dial(addr, options)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// authenticator returns the authenticator for the credentials and mechanism
+// in the connection string or nil if there are no credentials.
+func (u *URI) authenticator(options *DialOptions) Authenticator {
+	switch u.AuthMechanism {
+	case mechanismX509:
+		a := &X509Authenticator{Username: u.Username}
+		if len(options.Certificates) > 0 {
+			a.Certificate = &options.Certificates[0]
+		}
+		return a
+	case mechanismPlain:
+		return &PlainAuthenticator{Source: u.AuthSource, Username: u.Username, Password: u.Password}
+	}
+	if u.Username == "" {
+		return nil
+	}
+	return &PasswordAuthenticator{
+		Source:    u.AuthSource,
+		Username:  u.Username,
+		Password:  u.Password,
+		Mechanism: u.AuthMechanism,
+	}
+}
+
+// NewPool returns a pool of connections created by the URI Dial method. If the
+// connection string has the maxPoolSize option, then the pool waits for a
+// connection when the pool has maxPoolSize active connections.
+func (u *URI) NewPool() *Pool {
+	maxIdle := 10
+	if u.MaxPoolSize > 0 {
+		maxIdle = u.MaxPoolSize
+	}
+	p := NewPool(u.Dial, maxIdle)
+	p.MaxActive = u.MaxPoolSize
+	p.Wait = u.MaxPoolSize > 0
+	p.IdleTimeout = u.MaxIdleTime
+	return p
+}
+
+// DB returns the database from the path of the connection string using conn.
+// The database is configured with the write concern from the connection
+// string. If the connection string does not specify a database, then the
+// database "test" is used.
+func (u *URI) DB(conn Conn) Database {
+	name := u.Database
+	if name == "" {
+		name = "test"
+	}
+	db := Database{Conn: conn, Name: name}
+	if u.W != "" || u.WTimeout != 0 || u.Journal {
+		wc := &WriteConcern{}
+		if u.W != "" {
+			wc = parseW(u.W)
+		}
+		wc.WTimeout = u.WTimeout
+		wc.J = u.Journal
+		db.WriteConcern = wc
+	}
+	return db
+}
@@ -0,0 +1,178 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var parseURITests = []struct {
+	s        string
+	expected *URI
+}{
+	{
+		"mongodb://localhost",
+		&URI{Hosts: []string{"localhost:27017"}, AuthSource: "admin"},
+	},
+	{
+		"mongodb://us%40r:p%3Ass@h1,h2:27018/mydb?replicaSet=rs&w=majority&wtimeoutMS=500&journal=true&connectTimeoutMS=2000&maxPoolSize=5",
+		&URI{
+			Hosts:          []string{"h1:27017", "h2:27018"},
+			Username:       "us@r",
+			Password:       "p:ss",
+			Database:       "mydb",
+			AuthSource:     "mydb",
+			ReplicaSet:     "rs",
+			W:              "majority",
+			WTimeout:       500 * time.Millisecond,
+			Journal:        true,
+			ConnectTimeout: 2 * time.Second,
+			MaxPoolSize:    5,
+		},
+	},
+	{
+		"mongodb://[::1]:27017/?authSource=other&readPreference=secondaryPreferred&readPreferenceTags=dc:ny,rack:1&readPreferenceTags=&maxStalenessSeconds=120",
+		&URI{
+			Hosts:      []string{"[::1]:27017"},
+			AuthSource: "other",
+			ReadPreference: &ReadPreference{
+				Mode:         ReadSecondaryPreferred,
+				TagSets:      []map[string]string{{"dc": "ny", "rack": "1"}, {}},
+				MaxStaleness: 2 * time.Minute,
+			},
+		},
+	},
+	{
+		"mongodb://h1/?tlsCertificateKeyFile=client.pem&tlsCAFile=ca.pem",
+		&URI{
+			Hosts:                 []string{"h1:27017"},
+			AuthSource:            "admin",
+			TLS:                   true,
+			TLSCAFile:             "ca.pem",
+			TLSCertificateKeyFile: "client.pem",
+		},
+	},
+	{
+		"mongodb://h1/?tls=true&ssl=true&tlsCAFile=ca.pem",
+		&URI{Hosts: []string{"h1:27017"}, AuthSource: "admin", TLS: true, TLSCAFile: "ca.pem"},
+	},
+	{"mongodb://h1/?ssl=false", &URI{Hosts: []string{"h1:27017"}, AuthSource: "admin"}},
+	{"mongodb://h1/?tls=false&tlsCAFile=ca.pem", nil},
+	{"mongodb://h1/?tlsCertificateKeyFile=client.pem&ssl=false", nil},
+	{"mongodb://h1/?tls=true&ssl=false", nil},
+	{"mongodb://h1/?w", nil},
+	{"mongodb://h1/?readPreference=fastest", nil},
+	{"mongodb://h1/?connectTimeoutMS=soon", nil},
+	{"mongodb://", nil},
+	{"mongodb://h1,,h2", nil},
+	{"http://h1", nil},
+}
+
+func TestParseURI(t *testing.T) {
+	for _, tt := range parseURITests {
+		u, err := ParseURI(tt.s)
+		if tt.expected == nil {
+			if err == nil {
+				t.Errorf("ParseURI(%q) did not return error", tt.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseURI(%q) returned error %v", tt.s, err)
+			continue
+		}
+		u.Options = nil
+		if !reflect.DeepEqual(u, tt.expected) {
+			t.Errorf("ParseURI(%q) = %+v, want %+v", tt.s, u, tt.expected)
+		}
+	}
+}
+
+type fakeResolver struct {
+	srv []*net.SRV
+	txt []string
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if service != "mongodb" || proto != "tcp" || name != "cluster.example.com" {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return "", r.srv, nil
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if r.txt == nil {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return r.txt, nil
+}
+
+func TestParseSRVURI(t *testing.T) {
+	r := &fakeResolver{
+		srv: []*net.SRV{
+			{Target: "a.example.com.", Port: 27017},
+			{Target: "b.example.com.", Port: 27018},
+		},
+		txt: []string{"replicaSet=rs&authSource=auth"},
+	}
+	u, err := ParseURIWithResolver("mongodb+srv://cluster.example.com/db?authSource=override", r)
+	if err != nil {
+		t.Fatal("parse", err)
+	}
+	if !reflect.DeepEqual(u.Hosts, []string{"a.example.com:27017", "b.example.com:27018"}) {
+		t.Errorf("hosts = %v", u.Hosts)
+	}
+	if u.ReplicaSet != "rs" || u.AuthSource != "override" || !u.TLS {
+		t.Errorf("uri = %+v", u)
+	}
+
+	u, err = ParseURIWithResolver("mongodb+srv://cluster.example.com/?tls=false", &fakeResolver{srv: r.srv})
+	if err != nil {
+		t.Fatal("parse without TXT record", err)
+	}
+	if u.TLS {
+		t.Error("tls=false option ignored")
+	}
+
+	for _, tt := range []struct {
+		s string
+		r *fakeResolver
+	}{
+		{"mongodb+srv://cluster.example.com:27017", r},
+		{"mongodb+srv://example.com", r},
+		{"mongodb+srv://cluster.example.com", &fakeResolver{srv: []*net.SRV{{Target: "evil.com.", Port: 1}}}},
+		{"mongodb+srv://cluster.example.com", &fakeResolver{srv: r.srv, txt: []string{"w=1"}}},
+	} {
+		if _, err := ParseURIWithResolver(tt.s, tt.r); err == nil {
+			t.Errorf("ParseURIWithResolver(%q) did not return error", tt.s)
+		}
+	}
+}
+
+func TestURIDB(t *testing.T) {
+	u, err := ParseURI("mongodb://localhost/app?w=2&wtimeoutMS=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := u.DB(nil)
+	expected := &WriteConcern{W: 2, WTimeout: 100 * time.Millisecond}
+	if db.Name != "app" || !reflect.DeepEqual(db.WriteConcern, expected) {
+		t.Errorf("db = %+v", db)
+	}
+}
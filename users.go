@@ -0,0 +1,207 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+// Role identifies a role. If DB is empty, then the role is in the database
+// where the command is run.
+type Role struct {
+	Role string `bson:"role"`
+	DB   string `bson:"db"`
+}
+
+// User specifies a user for the CreateUser and UpdateUser methods.
+type User struct {
+	Name     string
+	Password string
+
+	// Optional document with arbitrary information about the user.
+	CustomData interface{}
+
+	// Roles granted to the user.
+	Roles []Role
+
+	// SCRAM mechanisms for the user's credentials. If nil, the server
+	// default is used.
+	Mechanisms []string
+}
+
+// UserInfo is the information returned by the UsersInfo method.
+type UserInfo struct {
+	Id         string   `bson:"_id"`
+	User       string   `bson:"user"`
+	DB         string   `bson:"db"`
+	Roles      []Role   `bson:"roles"`
+	CustomData M        `bson:"customData"`
+	Mechanisms []string `bson:"mechanisms"`
+}
+
+// Resource specifies the database and collection for a privilege. An empty
+// DB or Collection matches all databases or collections.
+type Resource struct {
+	DB         string `bson:"db"`
+	Collection string `bson:"collection"`
+}
+
+// Privilege specifies the actions allowed on a resource.
+type Privilege struct {
+	// A Resource value or the cluster resource D{{"cluster", true}}.
+	Resource interface{} `bson:"resource"`
+
+	Actions []string `bson:"actions"`
+}
+
+// RoleDefinition specifies a role for the CreateRole method.
+type RoleDefinition struct {
+	Name       string
+	Privileges []Privilege
+	Roles      []Role
+}
+
+// RoleInfo is the information returned by the RolesInfo method.
+type RoleInfo struct {
+	Role           string      `bson:"role"`
+	DB             string      `bson:"db"`
+	IsBuiltin      bool        `bson:"isBuiltin"`
+	Roles          []Role      `bson:"roles"`
+	InheritedRoles []Role      `bson:"inheritedRoles"`
+	Privileges     []Privilege `bson:"privileges"`
+}
+
+// roles returns roles with empty DB fields set to the database name.
+func (db Database) roles(roles []Role) []Role {
+	result := make([]Role, len(roles))
+	for i, r := range roles {
+		if r.DB == "" {
+			r.DB = db.Name
+		}
+		result[i] = r
+	}
+	return result
+}
+
+// CreateUser creates a user in the database.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/createUser/
+func (db Database) CreateUser(user *User) error {
+	cmd := struct {
+		CreateUser string      `bson:"createUser"`
+		Pwd        string      `bson:"pwd,omitempty"`
+		CustomData interface{} `bson:"customData"`
+		Roles      []Role      `bson:"roles"`
+		Mechanisms []string    `bson:"mechanisms"`
+	}{user.Name, user.Password, user.CustomData, db.roles(user.Roles), user.Mechanisms}
+	return db.Run(&cmd, nil)
+}
+
+// UpdateUser updates a user in the database. The password, custom data, roles
+// and mechanisms are updated if the corresponding field in user is set.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/updateUser/
+func (db Database) UpdateUser(user *User) error {
+	cmd := struct {
+		UpdateUser string      `bson:"updateUser"`
+		Pwd        string      `bson:"pwd,omitempty"`
+		CustomData interface{} `bson:"customData"`
+		Roles      []Role      `bson:"roles"`
+		Mechanisms []string    `bson:"mechanisms"`
+	}{UpdateUser: user.Name, Pwd: user.Password, CustomData: user.CustomData, Mechanisms: user.Mechanisms}
+	if user.Roles != nil {
+		cmd.Roles = db.roles(user.Roles)
+	}
+	return db.Run(&cmd, nil)
+}
+
+// DropUser removes the user with name from the database.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/dropUser/
+func (db Database) DropUser(name string) error {
+	return db.Run(D{{"dropUser", name}}, nil)
+}
+
+// GrantRolesToUser grants roles to the user with name.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/grantRolesToUser/
+func (db Database) GrantRolesToUser(name string, roles []Role) error {
+	return db.Run(D{{"grantRolesToUser", name}, {"roles", db.roles(roles)}}, nil)
+}
+
+// RevokeRolesFromUser removes roles from the user with name.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/revokeRolesFromUser/
+func (db Database) RevokeRolesFromUser(name string, roles []Role) error {
+	return db.Run(D{{"revokeRolesFromUser", name}, {"roles", db.roles(roles)}}, nil)
+}
+
+// UsersInfo returns information about the users with the given names. If no
+// names are specified, then UsersInfo returns information about all users in
+// the database.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/usersInfo/
+func (db Database) UsersInfo(names ...string) ([]UserInfo, error) {
+	var r struct {
+		Users []UserInfo `bson:"users"`
+	}
+	if err := db.Run(D{{"usersInfo", namesArg(names)}}, &r); err != nil {
+		return nil, err
+	}
+	return r.Users, nil
+}
+
+// CreateRole creates a user-defined role in the database.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/createRole/
+func (db Database) CreateRole(role *RoleDefinition) error {
+	privileges := role.Privileges
+	if privileges == nil {
+		privileges = []Privilege{}
+	}
+	cmd := struct {
+		CreateRole string      `bson:"createRole"`
+		Privileges []Privilege `bson:"privileges"`
+		Roles      []Role      `bson:"roles"`
+	}{role.Name, privileges, db.roles(role.Roles)}
+	return db.Run(&cmd, nil)
+}
+
+// DropRole removes the user-defined role with name from the database.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/dropRole/
+func (db Database) DropRole(name string) error {
+	return db.Run(D{{"dropRole", name}}, nil)
+}
+
+// RolesInfo returns information about the roles with the given names,
+// including the role privileges. If no names are specified, then RolesInfo
+// returns information about all user-defined roles in the database.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/command/rolesInfo/
+func (db Database) RolesInfo(names ...string) ([]RoleInfo, error) {
+	var r struct {
+		Roles []RoleInfo `bson:"roles"`
+	}
+	if err := db.Run(D{{"rolesInfo", namesArg(names)}, {"showPrivileges", true}}, &r); err != nil {
+		return nil, err
+	}
+	return r.Roles, nil
+}
+
+// namesArg returns the argument to the usersInfo and rolesInfo commands for
+// names.
+func namesArg(names []string) interface{} {
+	if len(names) == 0 {
+		return 1
+	}
+	return names
+}
@@ -0,0 +1,140 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var userCommandTests = []struct {
+	name     string
+	fn       func(db Database) error
+	expected M
+}{
+	{
+		"CreateUser",
+		func(db Database) error {
+			return db.CreateUser(&User{Name: "u", Password: "p", Roles: []Role{{Role: "read"}, {Role: "dbAdmin", DB: "other"}}})
+		},
+		M{"createUser": "u", "pwd": "p", "roles": []interface{}{
+			M{"role": "read", "db": "test"},
+			M{"role": "dbAdmin", "db": "other"}}},
+	},
+	{
+		"CreateUserNoRoles",
+		func(db Database) error {
+			return db.CreateUser(&User{Name: "u", Password: "p", Mechanisms: []string{"SCRAM-SHA-256"}})
+		},
+		M{"createUser": "u", "pwd": "p", "roles": []interface{}{}, "mechanisms": []interface{}{"SCRAM-SHA-256"}},
+	},
+	{
+		"UpdateUser",
+		func(db Database) error {
+			return db.UpdateUser(&User{Name: "u", CustomData: M{"x": "y"}})
+		},
+		M{"updateUser": "u", "customData": M{"x": "y"}},
+	},
+	{
+		"DropUser",
+		func(db Database) error { return db.DropUser("u") },
+		M{"dropUser": "u"},
+	},
+	{
+		"GrantRolesToUser",
+		func(db Database) error { return db.GrantRolesToUser("u", []Role{{Role: "readWrite"}}) },
+		M{"grantRolesToUser": "u", "roles": []interface{}{M{"role": "readWrite", "db": "test"}}},
+	},
+	{
+		"RevokeRolesFromUser",
+		func(db Database) error { return db.RevokeRolesFromUser("u", []Role{{Role: "readWrite"}}) },
+		M{"revokeRolesFromUser": "u", "roles": []interface{}{M{"role": "readWrite", "db": "test"}}},
+	},
+	{
+		"CreateRole",
+		func(db Database) error {
+			return db.CreateRole(&RoleDefinition{
+				Name:       "r",
+				Privileges: []Privilege{{Resource: Resource{DB: "test", Collection: ""}, Actions: []string{"find"}}},
+			})
+		},
+		M{"createRole": "r", "roles": []interface{}{}, "privileges": []interface{}{
+			M{"resource": M{"db": "test", "collection": ""}, "actions": []interface{}{"find"}}}},
+	},
+	{
+		"DropRole",
+		func(db Database) error { return db.DropRole("r") },
+		M{"dropRole": "r"},
+	},
+}
+
+func TestUserCommands(t *testing.T) {
+	var cmd M
+	c := newMsgConnection(func(m M, sequences map[string][][]byte) interface{} {
+		cmd = m
+		return M{"ok": 1}
+	})
+	defer c.Close()
+	db := Database{Conn: c, Name: "test"}
+
+	for _, tt := range userCommandTests {
+		cmd = nil
+		if err := tt.fn(db); err != nil {
+			t.Errorf("%s returned %v", tt.name, err)
+			continue
+		}
+		delete(cmd, "$db")
+		// Nested documents decode as map[string]interface{}. Compare the
+		// formatted values.
+		if fmt.Sprint(cmd) != fmt.Sprint(tt.expected) {
+			t.Errorf("%s sent %v, want %v", tt.name, cmd, tt.expected)
+		}
+	}
+}
+
+func TestUsersInfo(t *testing.T) {
+	var arg interface{}
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		arg = cmd["usersInfo"]
+		return M{"ok": 1, "users": []M{{
+			"_id":   "test.u",
+			"user":  "u",
+			"db":    "test",
+			"roles": []M{{"role": "read", "db": "test"}},
+		}}}
+	})
+	defer c.Close()
+	db := Database{Conn: c, Name: "test"}
+
+	users, err := db.UsersInfo()
+	if err != nil {
+		t.Fatal("UsersInfo()", err)
+	}
+	if arg != 1 {
+		t.Errorf("UsersInfo() sent usersInfo: %v, want 1", arg)
+	}
+	expected := []UserInfo{{Id: "test.u", User: "u", DB: "test", Roles: []Role{{Role: "read", DB: "test"}}}}
+	if !reflect.DeepEqual(users, expected) {
+		t.Errorf("UsersInfo() = %+v, want %+v", users, expected)
+	}
+
+	if _, err := db.UsersInfo("u", "v"); err != nil {
+		t.Fatal("UsersInfo(u, v)", err)
+	}
+	if !reflect.DeepEqual(arg, []interface{}{"u", "v"}) {
+		t.Errorf("UsersInfo(u, v) sent usersInfo: %v", arg)
+	}
+}
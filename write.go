@@ -0,0 +1,254 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"errors"
+	"strconv"
+)
+
+var errNoWriteCommands = errors.New("mongo: server does not support write commands")
+
+// WriteResult is the result of the write command methods on Collection. The
+// result is not meaningful for unacknowledged writes.
+type WriteResult struct {
+	// The _id of each inserted document keyed by the document's index in the
+	// insert.
+	InsertedIds map[int]interface{}
+
+	// Number of documents matched by an update.
+	MatchedCount int
+
+	// Number of documents modified by an update. Documents are not modified
+	// when the update does not change the document.
+	ModifiedCount int
+
+	// Number of documents inserted by an upsert.
+	UpsertedCount int
+
+	// The _id of each upserted document keyed by the update's index in the
+	// command.
+	UpsertedIds map[int]interface{}
+
+	// Number of documents removed.
+	DeletedCount int
+}
+
+// WriteError is the error for a single document in a write command.
+type WriteError struct {
+	// Index of the document in the command.
+	Index int `bson:"index"`
+
+	Code   int    `bson:"code"`
+	Errmsg string `bson:"errmsg"`
+
+	// Additional information about the error.
+	Details M `bson:"errInfo"`
+}
+
+func (e *WriteError) Error() string {
+	return e.Errmsg
+}
+
+// WriteErrors is returned from the write command methods on Collection when
+// the write fails for one or more documents. Ordered writes stop at the first
+// error. Unordered writes report an error for each document that failed.
+type WriteErrors struct {
+	// Errors in order of the document index.
+	Errors []WriteError
+
+	// The write concern error, if any.
+	WriteConcernError *WriteConcernError
+}
+
+func (e *WriteErrors) Error() string {
+	if len(e.Errors) == 0 {
+		return "mongo: write errors"
+	}
+	first := &e.Errors[0]
+	s := "mongo: write error at index " + strconv.Itoa(first.Index) + ": " + first.Errmsg
+	if n := len(e.Errors) - 1; n > 0 {
+		s += " (and " + strconv.Itoa(n) + " more)"
+	}
+	return s
+}
+
+// writeErrors returns the error from the response or nil. Unlike the Err
+// method, writeErrors returns all of the write errors in the response.
+func (r *writeReply) writeErrors() error {
+	if err := r.CommandResponse.Err(); err != nil {
+		return err
+	}
+	if len(r.WriteErrors) > 0 {
+		return &WriteErrors{Errors: r.WriteErrors, WriteConcernError: r.WriteConcernError}
+	}
+	if r.WriteConcernError != nil {
+		return r.WriteConcernError
+	}
+	return nil
+}
+
+// documentWithId returns the encoding of doc and the document's _id. If doc
+// does not have an _id, then documentWithId adds a new ObjectId to the
+// beginning of the encoding. The document doc is not modified.
+func documentWithId(doc interface{}, r *Registry) (BSONData, interface{}, error) {
+	p, err := EncodeWithRegistry(nil, doc, r)
+	if err != nil {
+		return BSONData{}, nil, err
+	}
+	var d struct {
+		Id interface{} `bson:"_id"`
+	}
+	if err := Decode(p, &d); err != nil {
+		return BSONData{}, nil, err
+	}
+	if d.Id != nil {
+		return BSONData{Kind: kindDocument, Data: p}, d.Id, nil
+	}
+	id := NewObjectId()
+	b, err := Encode(make([]byte, 0, len(p)+17), D{{"_id", id}})
+	if err != nil {
+		return BSONData{}, nil, err
+	}
+	// Replace the terminating zero of the _id document with the elements
+	// of doc.
+	b = append(b[:len(b)-1], p[4:]...)
+	wire.PutUint32(b, uint32(len(b)))
+	return BSONData{Kind: kindDocument, Data: b}, id, nil
+}
+
+// InsertWithResult adds documents to the collection using the insert command.
+// Documents without an _id are inserted with a new ObjectId. The returned
+// result contains the _id of each inserted document. If the insert fails for
+// some documents, then InsertWithResult returns the result for the inserted
+// documents and a *WriteErrors with the index of each document that failed.
+//
+// Set ContinueOnError in options for an unordered insert. The WriteConcern in
+// options overrides the collection's write concern. The options argument can
+// be nil.
+func (c Collection) InsertWithResult(options *InsertOptions, documents ...interface{}) (*WriteResult, error) {
+	if len(documents) == 0 {
+		return nil, errors.New("mongo: insert with no documents")
+	}
+	if !writeCommands(c.Conn) {
+		return nil, errNoWriteCommands
+	}
+	docs := make([]interface{}, len(documents))
+	ids := make(map[int]interface{}, len(documents))
+	for i, doc := range documents {
+		data, id, err := documentWithId(doc, c.registry())
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = data
+		ids[i] = id
+	}
+	ordered := options.flags()&insertContinueOnError == 0
+	var wc *WriteConcern
+	if options != nil {
+		wc = options.WriteConcern
+	}
+	r, err := c.runWrite(D{{"insert", c.Name()}, {"documents", docs}, {"ordered", ordered}}, wc)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.CommandResponse.Err(); err != nil {
+		return nil, err
+	}
+	for _, e := range r.WriteErrors {
+		if ordered {
+			// The server stops at the first error.
+			for i := e.Index; i < len(docs); i++ {
+				delete(ids, i)
+			}
+		}
+		delete(ids, e.Index)
+	}
+	return &WriteResult{InsertedIds: ids}, r.writeErrors()
+}
+
+// UpdateWithResult updates documents matching selector with update using the
+// update command and returns the matched, modified and upserted counts.
+//
+// The WriteConcern in options overrides the collection's write concern. The
+// options argument can be nil.
+func (c Collection) UpdateWithResult(selector, update interface{}, options *UpdateOptions) (*WriteResult, error) {
+	if !writeCommands(c.Conn) {
+		return nil, errNoWriteCommands
+	}
+	if selector == nil {
+		selector = emptyDoc
+	}
+	flags := options.flags()
+	u := D{
+		{"q", selector},
+		{"u", update},
+		{"upsert", flags&updateUpsert != 0},
+		{"multi", flags&updateMulti != 0},
+	}
+	var wc *WriteConcern
+	if options != nil {
+		wc = options.WriteConcern
+	}
+	r, err := c.runWrite(D{{"update", c.Name()}, {"updates", []interface{}{u}}}, wc)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.CommandResponse.Err(); err != nil {
+		return nil, err
+	}
+	result := &WriteResult{
+		MatchedCount:  r.N - len(r.Upserted),
+		ModifiedCount: r.NModified,
+		UpsertedCount: len(r.Upserted),
+	}
+	if len(r.Upserted) > 0 {
+		result.UpsertedIds = make(map[int]interface{}, len(r.Upserted))
+		for _, u := range r.Upserted {
+			result.UpsertedIds[u.Index] = u.Id
+		}
+	}
+	return result, r.writeErrors()
+}
+
+// RemoveWithResult removes documents matching selector using the delete
+// command and returns the number of removed documents.
+//
+// The WriteConcern in options overrides the collection's write concern. The
+// options argument can be nil.
+func (c Collection) RemoveWithResult(selector interface{}, options *RemoveOptions) (*WriteResult, error) {
+	if !writeCommands(c.Conn) {
+		return nil, errNoWriteCommands
+	}
+	if selector == nil {
+		selector = emptyDoc
+	}
+	limit := 0
+	if options.flags()&removeSingle != 0 {
+		limit = 1
+	}
+	var wc *WriteConcern
+	if options != nil {
+		wc = options.WriteConcern
+	}
+	r, err := c.runWrite(D{{"delete", c.Name()}, {"deletes", []interface{}{D{{"q", selector}, {"limit", limit}}}}}, wc)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.CommandResponse.Err(); err != nil {
+		return nil, err
+	}
+	return &WriteResult{DeletedCount: r.N}, r.writeErrors()
+}
@@ -0,0 +1,121 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDocumentWithId(t *testing.T) {
+	data, id, err := documentWithId(D{{"_id", 7}, {"x", 1}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 7 {
+		t.Errorf("id = %v, want 7", id)
+	}
+
+	doc := struct {
+		X int `bson:"x"`
+	}{1}
+	data, id, err = documentWithId(&doc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := id.(ObjectId); !ok {
+		t.Fatalf("id = %v, want ObjectId", id)
+	}
+	var m M
+	if err := Decode(data.Data, &m); err != nil {
+		t.Fatal(err)
+	}
+	expected := M{"_id": id, "x": 1}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("document = %v, want %v", m, expected)
+	}
+	if data.Data[4] != kindObjectId || string(data.Data[5:9]) != "_id\x00" {
+		t.Errorf("_id is not the first element of the document")
+	}
+}
+
+func TestInsertWithResult(t *testing.T) {
+	var ordered interface{}
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		ordered = cmd["ordered"]
+		return M{"ok": 1, "n": 2, "writeErrors": []M{
+			{"index": 1, "code": 11000, "errmsg": "E11000 duplicate key error"},
+		}}
+	})
+	defer c.Close()
+	coll := Collection{Conn: c, Namespace: "test.c"}
+
+	for _, tt := range []struct {
+		options  *InsertOptions
+		expected map[int]interface{}
+	}{
+		{nil, map[int]interface{}{0: 1}},
+		{&InsertOptions{ContinueOnError: true}, map[int]interface{}{0: 1, 2: 3}},
+	} {
+		result, err := coll.InsertWithResult(tt.options, M{"_id": 1}, M{"_id": 2}, M{"_id": 3})
+		if ordered != (tt.options == nil) {
+			t.Errorf("ordered = %v", ordered)
+		}
+		werr, ok := err.(*WriteErrors)
+		if !ok {
+			t.Fatalf("insert returned %v, want *WriteErrors", err)
+		}
+		if len(werr.Errors) != 1 || werr.Errors[0].Index != 1 || werr.Errors[0].Code != 11000 {
+			t.Errorf("insert returned %+v", werr.Errors)
+		}
+		if result == nil || !reflect.DeepEqual(result.InsertedIds, tt.expected) {
+			t.Errorf("insert returned result %+v, want InsertedIds %v", result, tt.expected)
+		}
+	}
+}
+
+func TestUpdateWithResult(t *testing.T) {
+	var update interface{}
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		if cmd["delete"] != nil {
+			return M{"ok": 1, "n": 3}
+		}
+		update = cmd["updates"]
+		return M{"ok": 1, "n": 1, "nModified": 0, "upserted": []M{{"index": 0, "_id": "a"}}}
+	})
+	defer c.Close()
+	coll := Collection{Conn: c, Namespace: "test.c"}
+
+	result, err := coll.UpdateWithResult(M{"_id": "a"}, M{"$set": M{"x": 1}}, &UpdateOptions{Upsert: true})
+	if err != nil {
+		t.Fatal("update", err)
+	}
+	expected := &WriteResult{UpsertedCount: 1, UpsertedIds: map[int]interface{}{0: "a"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("update returned %+v, want %+v", result, expected)
+	}
+	u := update.([]interface{})[0].(map[string]interface{})
+	if u["upsert"] != true || u["multi"] != false {
+		t.Errorf("update sent %v", u)
+	}
+
+	result, err = coll.RemoveWithResult(nil, nil)
+	if err != nil {
+		t.Fatal("remove", err)
+	}
+	if result.DeletedCount != 3 {
+		t.Errorf("remove returned %+v", result)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"strconv"
+	"time"
+)
+
+// WriteConcern specifies the acknowledgement requested from the server for
+// write operations. A nil *WriteConcern selects the server's default write
+// concern.
+//
+// Connections to servers that support OP_MSG send writes as commands and
+// include the write concern in the command. Connections to older servers send
+// writes with the legacy opcodes and ignore the write concern set in
+// InsertOptions, UpdateOptions and RemoveOptions; Collection applies the
+// write concern for these servers with a getLastError command.
+//
+// More information: https://www.mongodb.com/docs/manual/reference/write-concern/
+type WriteConcern struct {
+	// Number of replica set members that must acknowledge the write. If W is
+	// zero and WTag is empty, then the server default is used.
+	W int
+
+	// Tag set name or "majority". WTag overrides W.
+	WTag string
+
+	// Wait for the write to be committed to the journal.
+	J bool
+
+	// Time limit for the write concern. Zero means no limit.
+	WTimeout time.Duration
+
+	// Do not request acknowledgement. The other fields are ignored.
+	Unacknowledged bool
+}
+
+// acknowledged returns true if the write concern requests acknowledgement.
+func (wc *WriteConcern) acknowledged() bool {
+	return wc == nil || !wc.Unacknowledged
+}
+
+// document returns the writeConcern document sent with write commands.
+func (wc *WriteConcern) document() D {
+	d := D{}
+	if wc.Unacknowledged {
+		d.Append("w", 0)
+		return d
+	}
+	switch {
+	case wc.WTag != "":
+		d.Append("w", wc.WTag)
+	case wc.W != 0:
+		d.Append("w", wc.W)
+	}
+	if wc.J {
+		d.Append("j", true)
+	}
+	if wc.WTimeout > 0 {
+		d.Append("wtimeout", int(wc.WTimeout/time.Millisecond))
+	}
+	return d
+}
+
+// lastErrorCmd returns the getLastError command for the write concern.
+func (wc *WriteConcern) lastErrorCmd() D {
+	cmd := D{{"getLastError", 1}}
+	if wc != nil {
+		cmd = append(cmd, wc.document()...)
+	}
+	return cmd
+}
+
+// parseW returns the write concern with w set from a connection string
+// value.
+func parseW(w string) *WriteConcern {
+	if n, err := strconv.Atoi(w); err == nil {
+		if n == 0 {
+			return &WriteConcern{Unacknowledged: true}
+		}
+		return &WriteConcern{W: n}
+	}
+	return &WriteConcern{WTag: w}
+}
+
+// WriteConcernError is returned when the server applied a write, but could not
+// satisfy the requested write concern.
+type WriteConcernError struct {
+	Code     int    `bson:"code"`
+	CodeName string `bson:"codeName"`
+	Errmsg   string `bson:"errmsg"`
+
+	// Additional information about the error. For example, the errInfo
+	// for a timeout reports the write concern used by the server.
+	Details M `bson:"errInfo"`
+}
+
+func (e *WriteConcernError) Error() string {
+	return "mongo: write concern error: " + e.Errmsg
+}
@@ -0,0 +1,109 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package mongo
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var writeConcernDocumentTests = []struct {
+	wc       WriteConcern
+	expected D
+}{
+	{WriteConcern{}, D{}},
+	{WriteConcern{W: 2}, D{{"w", 2}}},
+	{WriteConcern{W: 2, WTag: "majority", J: true}, D{{"w", "majority"}, {"j", true}}},
+	{WriteConcern{W: 1, WTimeout: 1500 * time.Millisecond}, D{{"w", 1}, {"wtimeout", 1500}}},
+	{WriteConcern{W: 3, J: true, Unacknowledged: true}, D{{"w", 0}}},
+}
+
+func TestWriteConcernDocument(t *testing.T) {
+	for _, tt := range writeConcernDocumentTests {
+		d := tt.wc.document()
+		if !reflect.DeepEqual(d, tt.expected) {
+			t.Errorf("%+v.document() = %v, want %v", tt.wc, d, tt.expected)
+		}
+	}
+}
+
+func TestCollectionWriteConcern(t *testing.T) {
+	var cmds []M
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		cmds = append(cmds, cmd)
+		switch {
+		case cmd["update"] != nil:
+			return M{"ok": 1, "n": 0, "nModified": 0}
+		case cmd["delete"] != nil:
+			return M{"ok": 1, "n": 1, "writeConcernError": M{
+				"code":     64,
+				"codeName": "WriteConcernFailed",
+				"errmsg":   "waiting for replication timed out",
+				"errInfo":  M{"wtimeout": true},
+			}}
+		}
+		return M{"ok": 1, "n": 1}
+	})
+	defer c.Close()
+
+	db := Database{Conn: c, Name: "test", WriteConcern: &WriteConcern{WTag: "majority", WTimeout: time.Second}}
+	coll := db.C("c")
+
+	if err := coll.Insert(M{"x": 1}); err != nil {
+		t.Fatal("insert", err)
+	}
+	if err := coll.Update(M{"x": 2}, M{"$set": M{"y": 1}}); err != ErrNotFound {
+		t.Errorf("update returned %v, want ErrNotFound", err)
+	}
+	err := coll.Remove(M{"x": 1})
+	wcerr, ok := err.(*WriteConcernError)
+	if !ok {
+		t.Fatalf("remove returned %v, want *WriteConcernError", err)
+	}
+	if wcerr.Code != 64 || wcerr.CodeName != "WriteConcernFailed" || wcerr.Details["wtimeout"] != true {
+		t.Errorf("remove returned %+v", wcerr)
+	}
+
+	expected := "map[w:majority wtimeout:1000]"
+	for _, cmd := range cmds {
+		if s := fmt.Sprint(cmd["writeConcern"]); s != expected {
+			t.Errorf("command %v sent writeConcern %s, want %s", cmd, s, expected)
+		}
+	}
+}
+
+func TestConnWriteConcern(t *testing.T) {
+	var wc interface{}
+	c := newMsgConnection(func(cmd M, sequences map[string][][]byte) interface{} {
+		wc = cmd["writeConcern"]
+		return M{"ok": 1, "n": 1}
+	})
+	defer c.Close()
+
+	if err := c.Insert("test.c", nil, M{"x": 1}); err != nil {
+		t.Fatal("insert", err)
+	}
+	if wc != nil {
+		t.Errorf("insert without write concern sent %v", wc)
+	}
+	if err := c.Insert("test.c", &InsertOptions{WriteConcern: &WriteConcern{W: 2, J: true}}, M{"x": 1}); err != nil {
+		t.Fatal("insert", err)
+	}
+	if s := fmt.Sprint(wc); s != "map[j:true w:2]" {
+		t.Errorf("insert sent writeConcern %s", s)
+	}
+}